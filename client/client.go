@@ -3,56 +3,170 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
+	"os/user"
+	"path"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/tevintchuinkam/dfs/files"
 	"github.com/tevintchuinkam/dfs/helpers"
 	"github.com/tevintchuinkam/dfs/metadata"
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func init() {
 	log.SetFlags(log.Lshortfile)
 }
 
+// number of entries asked for per ReadDirPlus call
+const readDirBatch = 256
+
 type accessData struct {
 	lastAccess  time.Time
 	accessCount int
 }
 
 type Client struct {
-	mdsPort           int
+	// metadata servers, each one holding a shard of the namespace, see metadata.ShardOf
+	mdsPorts          []int
 	prefetchThreshold int
 
 	cache *ClientCache
 	// dirname: unique access count
 	predictionHistory map[string]*accessData
+
+	// owner and group of the files and directories created by the client
+	owner string
+	group string
 }
 
 func New(mdsPort int, pefetchThreshold int) *Client {
+	return NewSharded([]int{mdsPort}, pefetchThreshold)
+}
+
+// NewSharded creates a client for a namespace partitioned across the metadata
+// servers listening on mdsPorts. The order of the ports has to match the
+// shard index the servers were started with.
+func NewSharded(mdsPorts []int, pefetchThreshold int) *Client {
 
 	dirs := make(map[dirName]dirContents)
+	owner, group := currentUser()
 	// ping the server
 	return &Client{
-		mdsPort: mdsPort,
+		mdsPorts: mdsPorts,
 		cache: &ClientCache{
 			dirs: dirs,
 		},
 		predictionHistory: make(map[string]*accessData),
 		prefetchThreshold: pefetchThreshold,
+		owner:             owner,
+		group:             group,
 	}
 }
 
-func (c *Client) DeleteAllData() {
-	m := NewMDSClient(c.mdsPort)
-	_, err := m.DeleteAllData(context.Background(), &metadata.DeleteAllDataRequest{})
+// currentUser returns the name of the user running the client and of its primary group.
+func currentUser() (string, string) {
+	u, err := user.Current()
 	if err != nil {
-		log.Fatal(err)
+		slog.Warn("could not look up the current user", "err", err)
+		return "", ""
+	}
+	g, err := user.LookupGroupId(u.Gid)
+	if err != nil {
+		return u.Username, u.Gid
+	}
+	return u.Username, g.Name
+}
+
+func (c *Client) DeleteAllData() {
+	for _, port := range c.mdsPorts {
+		m := NewMDSClient(port)
+		_, err := m.DeleteAllData(context.Background(), &metadata.DeleteAllDataRequest{})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// mds connects to the metadata server that owns p.
+func (c *Client) mds(p string) metadata.MetadataServiceClient {
+	return NewMDSClient(c.mdsPorts[metadata.ShardOf(p, len(c.mdsPorts))])
+}
+
+// sharded reports whether p is the root directory of a namespace that is
+// partitioned across several metadata servers. Its entries are spread over
+// all of them.
+func (c *Client) sharded(p string) bool {
+	return len(c.mdsPorts) > 1 && path.Clean(p) == "."
+}
+
+// readRootDir lists the root directory of a sharded namespace.
+func (c *Client) readRootDir() ([]*metadata.FileInfo, error) {
+	var entries []*metadata.FileInfo
+	for _, port := range c.mdsPorts {
+		e, err := readAll(NewMDSClient(port), ".")
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e...)
+	}
+	return entries, nil
+}
+
+// shardedPages returns a reader of the pages of name, which every shard
+// holds part of, going through the shards one after another. The first byte
+// of a cursor is the shard being read, the rest its cursor.
+func shardedPages(shards []metadata.MetadataServiceClient, name string) func(cursor []byte) (*metadata.ReadDirPlusResponse, error) {
+	return func(cursor []byte) (*metadata.ReadDirPlusResponse, error) {
+		shard := 0
+		if len(cursor) > 0 {
+			shard, cursor = int(cursor[0]), cursor[1:]
+		}
+		r, err := shards[shard].ReadDirPlus(context.Background(), &metadata.ReadDirPlusRequest{
+			Name:      name,
+			Cursor:    cursor,
+			BatchSize: readDirBatch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if r.Eof && shard+1 < len(shards) {
+			return &metadata.ReadDirPlusResponse{Entries: r.Entries, Cursor: []byte{byte(shard + 1)}}, nil
+		}
+		return &metadata.ReadDirPlusResponse{Entries: r.Entries, Cursor: append([]byte{byte(shard)}, r.Cursor...), Eof: r.Eof}, nil
+	}
+}
+
+// readAll lists a directory page by page.
+func readAll(mds metadata.MetadataServiceClient, name string) ([]*metadata.FileInfo, error) {
+	var entries []*metadata.FileInfo
+	var cursor []byte
+	for {
+		r, err := mds.ReadDirPlus(context.Background(), &metadata.ReadDirPlusRequest{
+			Name:      name,
+			Cursor:    cursor,
+			BatchSize: readDirBatch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, r.Entries...)
+		if r.Eof {
+			return entries, nil
+		}
+		cursor = r.Cursor
 	}
 }
 
@@ -67,7 +181,7 @@ func (c *Client) ClearCache() {
 func (c *Client) ReadDir(name string, index int, useCache bool) (*metadata.FileInfo, error) {
 	if useCache {
 		// try finding the dirs
-		dir, ok := c.cache.dirs[dirName(name)]
+		dir, ok := c.cache.dir(dirName(name))
 		if ok {
 			if dir.full {
 				if index < 0 || index >= len(dir.entries) {
@@ -89,10 +203,10 @@ func (c *Client) ReadDir(name string, index int, useCache bool) (*metadata.FileI
 					if err != nil {
 						log.Fatalf("could not prefetch dir %s err: %v", name, err)
 					}
-					c.cache.dirs[dirName(name)] = dirContents{
+					c.cache.put(dirName(name), dirContents{
 						full:    true,
 						entries: entries,
-					}
+					})
 					if index < 0 || index >= len(entries) {
 						return nil, io.EOF
 					}
@@ -109,32 +223,42 @@ func (c *Client) ReadDir(name string, index int, useCache bool) (*metadata.FileI
 		}
 	}
 
-	// request the file from mds, no caching
-	m := NewMDSClient(c.mdsPort)
-	r, err := m.ReadDir(context.Background(), &metadata.ReadDirRequest{
-		Name:  name,
-		Index: int32(index),
-	})
-	if err != nil {
-		return nil, err
+	// request the entries from mds a page at a time, reading the directory
+	// from index 0 starts over
+	if index < 0 {
+		return nil, io.EOF
+	}
+	if c.sharded(name) {
+		var shards []metadata.MetadataServiceClient
+		for _, port := range c.mdsPorts {
+			shards = append(shards, NewMDSClient(port))
+		}
+		return c.cache.listing(dirName(name), index == 0).entry(index, shardedPages(shards, "."))
 	}
-	return r, nil
+	m := c.mds(name)
+	return c.cache.listing(dirName(name), index == 0).entry(index, func(cursor []byte) (*metadata.ReadDirPlusResponse, error) {
+		return m.ReadDirPlus(context.Background(), &metadata.ReadDirPlusRequest{
+			Name:      name,
+			Cursor:    cursor,
+			BatchSize: readDirBatch,
+		})
+	})
 }
 
 func _prefetchDir(c *Client, name string) ([]*metadata.FileInfo, error) {
-	mds := NewMDSClient(c.mdsPort)
-	r, err := mds.ReadDirAll(context.Background(), &metadata.ReadDirRequest{
-		Name: name,
-	})
+	if c.sharded(name) {
+		return c.readRootDir()
+	}
+	entries, err := readAll(c.mds(name), name)
 	if err != nil {
 		log.Fatalf("could not prefetch dir %s err : %v", name, err)
 	}
-	return r.Entries, nil
+	return entries, nil
 }
 
 // open a directory
 func (c *Client) OpenDir(name string) (string, error) {
-	m := NewMDSClient(c.mdsPort)
+	m := c.mds(name)
 	r, err := m.OpenDir(context.Background(), &metadata.OpenDirRequest{
 		Name: name,
 	})
@@ -145,35 +269,245 @@ func (c *Client) OpenDir(name string) (string, error) {
 	return r.Name, nil
 }
 
+// CreateFile stores a new file with the replication factor of its directory.
 func (c *Client) CreateFile(name string, data []byte) (int, error) {
-	// ask the mds on on what storage server to store the file
-	mds := NewMDSClient(c.mdsPort)
-	rec, err := mds.RegisterFileCreation(context.Background(), &metadata.RecRequest{
+	return c.CreateReplicatedFile(name, data, 0)
+}
+
+// CreateReplicatedFile stores a new file on replication file servers. With a
+// replication of 0 the factor of the file's directory is used.
+func (c *Client) CreateReplicatedFile(name string, data []byte, replication int) (int, error) {
+	return c.create(name, data, replication, putFile)
+}
+
+// create registers a new file, writes its data and commits it. The file only
+// becomes visible once it is committed, if the data cannot be written it is
+// removed again.
+func (c *Client) create(name string, data []byte, replication int, write func(port int32, name string, data []byte) (int64, error)) (int, error) {
+	// ask the mds on on what storage servers to store the file
+	rec, err := c.registerCreation(name, len(data), replication)
+	if err != nil {
+		return 0, err
+	}
+	n, err := store(rec, data, write)
+	if err != nil {
+		c.abortCreation(name, rec.ObjectId)
+		return 0, err
+	}
+	sum := sha256.Sum256(data)
+	_, err = c.mds(name).CommitFile(context.Background(), &metadata.CommitFileRequest{
 		Name:     name,
-		FileSize: int64(len(data)),
+		ObjectId: rec.ObjectId,
+		Size:     int64(len(data)),
+		Checksum: hex.EncodeToString(sum[:]),
 	})
 	if err != nil {
-		slog.Error("getting storage location recommendation failed", "err", err.Error())
+		slog.Error("could not commit file", "file", name, "err", err)
 		return 0, err
 	}
-	fs := helpers.NewFileServiceClient(rec.Port)
+	return n, nil
+}
+
+// abortCreation removes a file whose data could not be written, unless it
+// is not the pending file with objectID anymore. Should it fail, the
+// metadata server removes the file once it has waited too long for its
+// commit.
+func (c *Client) abortCreation(name, objectID string) {
+	if _, err := c.mds(name).Unlink(context.Background(), &metadata.UnlinkRequest{Name: name, Pending: true, ObjectId: objectID}); err != nil {
+		slog.Warn("could not remove file that was not written", "file", name, "err", err)
+	}
+}
+
+// store writes the data of a new file to the file servers picked by the
+// metadata server, either as full copies, as erasure coded fragments or as
+// stripes. write stores data under name on the file server listening on port.
+func store(rec *metadata.RecResponse, data []byte, write func(port int32, name string, data []byte) (int64, error)) (int, error) {
+	switch {
+	case rec.DataShards > 0:
+		return writeFragments(rec, data, write)
+	case rec.StripeCount > 0:
+		return writeStripes(rec, data, write)
+	}
+	return writeReplicas(replicasOf(rec.Port, rec.Replicas), func(port int32) (int64, error) {
+		return write(port, rec.ObjectId, data)
+	})
+}
+
+// putFile writes data under name to the file server listening on port.
+func putFile(port int32, name string, data []byte) (int64, error) {
+	fs := helpers.NewFileServiceClient(port)
 	fr, err := fs.CreateFile(context.Background(), &files.CreateFileRequest{
 		Name: name,
 		Data: data,
 	})
 	if err != nil {
-		slog.Error("creating file failed", "err", err.Error())
 		return 0, err
 	}
+	return fr.BytesWritten, nil
+}
 
-	// send a createfilerequest to that server
-	return int(fr.BytesWritten), nil
+// load reads the data of a file from the first copy that answers or, if it
+// is erasure coded or striped, puts it together from its fragments.
+func (c *Client) load(loc *metadata.LocResponse) ([]byte, error) {
+	switch {
+	case loc.DataShards > 0:
+		return readFragments(loc, c.GetFileFromPort)
+	case loc.StripeCount > 0:
+		return readStripes(loc, c.GetFileFromPort)
+	}
+	return readReplicas(replicasOf(loc.Port, loc.Replicas), func(port int32) ([]byte, error) {
+		return c.GetFileFromPort(port, loc.ObjectId)
+	})
+}
+
+func (c *Client) registerCreation(name string, size int, replication int) (*metadata.RecResponse, error) {
+	mds := c.mds(name)
+	rec, err := mds.RegisterFileCreation(context.Background(), &metadata.RecRequest{
+		Name:        name,
+		FileSize:    int64(size),
+		Owner:       c.owner,
+		Group:       c.group,
+		Replication: int32(replication),
+	})
+	if err != nil {
+		slog.Error("getting storage location recommendation failed", "err", err.Error())
+		return nil, err
+	}
+	return rec, nil
+}
+
+// replicasOf returns the file servers holding a file. Metadata servers that
+// predate replication only send the port.
+func replicasOf(port int32, replicas []int32) []int32 {
+	if len(replicas) == 0 {
+		return []int32{port}
+	}
+	return replicas
+}
+
+// writeReplicas writes the data to all replicas in parallel and returns the
+// bytes written to the first one. It fails if any of the writes fails.
+func writeReplicas(replicas []int32, write func(port int32) (int64, error)) (int, error) {
+	written := make([]int64, len(replicas))
+	errs := make([]error, len(replicas))
+	var wg sync.WaitGroup
+	for i, port := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			written[i], errs[i] = write(port)
+			if errs[i] != nil {
+				slog.Error("creating file failed", "port", port, "err", errs[i].Error())
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+	return int(written[0]), nil
+}
+
+// readReplicas reads the data from the first replica that answers.
+func readReplicas(replicas []int32, read func(port int32) ([]byte, error)) ([]byte, error) {
+	var err error
+	for _, port := range replicas {
+		var data []byte
+		data, err = read(port)
+		if err == nil {
+			return data, nil
+		}
+		slog.Warn("reading replica failed, trying the next one", "port", port, "err", err)
+	}
+	return nil, err
+}
+
+// SetErasureCoding makes new files below dir erasure coded, each split into
+// dataShards fragments plus parityShards parity fragments on distinct file
+// servers. With 0 data shards new files are replicated again.
+func (c *Client) SetErasureCoding(dir string, dataShards, parityShards int) error {
+	req := &metadata.SetStorageClassRequest{
+		Name:         dir,
+		StorageClass: metadata.StorageClass_REPLICATED,
+	}
+	if dataShards > 0 {
+		req.StorageClass = metadata.StorageClass_ERASURE_CODED
+		req.DataShards = int32(dataShards)
+		req.ParityShards = int32(parityShards)
+	}
+	mds := c.mds(dir)
+	if _, err := mds.SetStorageClass(context.Background(), req); err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// SetStriping makes new files below dir striped over stripeCount file
+// servers in chunks of stripeSize bytes, -1 stripes over all file servers.
+func (c *Client) SetStriping(dir string, stripeSize int64, stripeCount int) error {
+	mds := c.mds(dir)
+	_, err := mds.SetStorageClass(context.Background(), &metadata.SetStorageClassRequest{
+		Name:         dir,
+		StorageClass: metadata.StorageClass_STRIPED,
+		StripeSize:   stripeSize,
+		StripeCount:  int32(stripeCount),
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// SetQuota limits the bytes of file data and the number of files and
+// directories below dir, 0 for no limit.
+func (c *Client) SetQuota(dir string, maxBytes, maxEntries int64) error {
+	mds := c.mds(dir)
+	_, err := mds.SetQuota(context.Background(), &metadata.SetQuotaRequest{
+		Name:       dir,
+		MaxBytes:   maxBytes,
+		MaxEntries: maxEntries,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// Quota returns the quota of dir and how much of it is used.
+func (c *Client) Quota(dir string) (*metadata.QuotaResponse, error) {
+	mds := c.mds(dir)
+	q, err := mds.GetQuota(context.Background(), &metadata.QuotaRequest{Name: dir})
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, err
+	}
+	return q, nil
+}
+
+// SetReplication sets the number of file servers the data of files created
+// below dir is stored on. A factor of 0 inherits the one of the parent.
+func (c *Client) SetReplication(dir string, factor int) error {
+	mds := c.mds(dir)
+	_, err := mds.SetReplication(context.Background(), &metadata.SetReplicationRequest{
+		Name:   dir,
+		Factor: int32(factor),
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	return nil
 }
 
 func (c *Client) MkDir(name string) error {
-	mds := NewMDSClient(c.mdsPort)
+	mds := c.mds(name)
 	_, err := mds.MkDir(context.Background(), &metadata.MkDirRequest{
-		Name: name,
+		Name:  name,
+		Owner: c.owner,
+		Group: c.group,
 	})
 	if err != nil {
 		slog.Error(err.Error())
@@ -182,30 +516,449 @@ func (c *Client) MkDir(name string) error {
 	return nil
 }
 
-func (c *Client) GetFile(name string) ([]byte, error) {
-	mds := NewMDSClient(c.mdsPort)
-	loc, err := mds.GetLocation(context.Background(), &metadata.LocRequest{
+// Stat returns the attributes of a file or directory.
+func (c *Client) Stat(name string) (*metadata.FileInfo, error) {
+	mds := c.mds(name)
+	info, err := mds.Stat(context.Background(), &metadata.StatRequest{
 		Name: name,
 	})
 	if err != nil {
 		slog.Error(err.Error())
 		return nil, err
 	}
-	fs := helpers.NewFileServiceClient(loc.Port)
-	fr, err := fs.GetFile(context.Background(), &files.GetFileRequest{
+	return info, nil
+}
+
+// Unlink removes a file.
+func (c *Client) Unlink(name string) error {
+	mds := c.mds(name)
+	_, err := mds.Unlink(context.Background(), &metadata.UnlinkRequest{
+		Name: name,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	c.invalidate(path.Dir(name))
+	return nil
+}
+
+// RmDir removes a directory, including everything below it if recursive is set.
+func (c *Client) RmDir(name string, recursive bool) error {
+	mds := c.mds(name)
+	_, err := mds.RmDir(context.Background(), &metadata.RmDirRequest{
+		Name:      name,
+		Recursive: recursive,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	c.invalidate(name)
+	c.invalidate(path.Dir(name))
+	return nil
+}
+
+// Rename moves a file or directory to a new path, replacing an existing file there.
+// The shard of oldName hands the entry to the shard of newName if they differ.
+func (c *Client) Rename(oldName string, newName string) error {
+	mds := c.mds(oldName)
+	_, err := mds.Rename(context.Background(), &metadata.RenameRequest{
+		OldName: oldName,
+		NewName: newName,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	c.invalidate(oldName)
+	c.invalidate(path.Dir(oldName))
+	c.invalidate(path.Dir(newName))
+	return nil
+}
+
+// Symlink creates a symbolic link name pointing to target, which is relative
+// to the directory of the link unless it starts with a slash.
+func (c *Client) Symlink(target string, name string) error {
+	mds := c.mds(name)
+	_, err := mds.Symlink(context.Background(), &metadata.SymlinkRequest{
+		Target: target,
+		Name:   name,
+		Owner:  c.owner,
+		Group:  c.group,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	c.invalidate(path.Dir(name))
+	return nil
+}
+
+// Readlink returns the target of the symbolic link name.
+func (c *Client) Readlink(name string) (string, error) {
+	mds := c.mds(name)
+	res, err := mds.Readlink(context.Background(), &metadata.ReadlinkRequest{Name: name})
+	if err != nil {
+		slog.Error(err.Error())
+		return "", err
+	}
+	return res.Target, nil
+}
+
+// Link makes newName another name of the file oldName. Both names have to be
+// held by the same metadata server, which counts the names of the data.
+func (c *Client) Link(oldName string, newName string) error {
+	if metadata.ShardOf(oldName, len(c.mdsPorts)) != metadata.ShardOf(newName, len(c.mdsPorts)) {
+		return fmt.Errorf("cannot link %s to %s, they are held by different metadata servers", newName, oldName)
+	}
+	mds := c.mds(oldName)
+	_, err := mds.Link(context.Background(), &metadata.LinkRequest{
+		OldName: oldName,
+		NewName: newName,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	c.invalidate(path.Dir(newName))
+	return nil
+}
+
+// SetXattr sets the extended attribute key of a file or directory.
+func (c *Client) SetXattr(name string, key string, value []byte) error {
+	mds := c.mds(name)
+	_, err := mds.SetXattr(context.Background(), &metadata.SetXattrRequest{
+		Name:  name,
+		Key:   key,
+		Value: value,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// GetXattr returns the extended attribute key of a file or directory.
+func (c *Client) GetXattr(name string, key string) ([]byte, error) {
+	mds := c.mds(name)
+	res, err := mds.GetXattr(context.Background(), &metadata.GetXattrRequest{Name: name, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
+
+// ListXattr returns the keys of the extended attributes of a file or directory.
+func (c *Client) ListXattr(name string) ([]string, error) {
+	mds := c.mds(name)
+	res, err := mds.ListXattr(context.Background(), &metadata.ListXattrRequest{Name: name})
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, err
+	}
+	return res.Keys, nil
+}
+
+// RemoveXattr removes the extended attribute key of a file or directory.
+func (c *Client) RemoveXattr(name string, key string) error {
+	mds := c.mds(name)
+	_, err := mds.RemoveXattr(context.Background(), &metadata.RemoveXattrRequest{Name: name, Key: key})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// CreateSnapshot freezes the subtree of dir under name and returns the path
+// the snapshot is read under, dir/.snap/name.
+func (c *Client) CreateSnapshot(dir string, name string) (string, error) {
+	mds := c.mds(dir)
+	res, err := mds.CreateSnapshot(context.Background(), &metadata.CreateSnapshotRequest{Name: dir, Snapshot: name})
+	if err != nil {
+		slog.Error(err.Error())
+		return "", err
+	}
+	return res.Path, nil
+}
+
+// DeleteSnapshot removes the snapshot name of dir.
+func (c *Client) DeleteSnapshot(dir string, name string) error {
+	mds := c.mds(dir)
+	_, err := mds.DeleteSnapshot(context.Background(), &metadata.DeleteSnapshotRequest{Name: dir, Snapshot: name})
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	c.invalidate(path.Join(dir, ".snap", name))
+	return nil
+}
+
+// ListSnapshots returns the snapshots of dir ordered by name.
+func (c *Client) ListSnapshots(dir string) ([]*metadata.SnapshotInfo, error) {
+	mds := c.mds(dir)
+	res, err := mds.ListSnapshots(context.Background(), &metadata.ListSnapshotsRequest{Name: dir})
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, err
+	}
+	return res.Snapshots, nil
+}
+
+// FileServers returns the file servers known to the metadata servers and
+// whether they are still sending heartbeats.
+func (c *Client) FileServers() ([]*metadata.FileServerStatus, error) {
+	var servers []*metadata.FileServerStatus
+	for _, port := range c.mdsPorts {
+		r, err := NewMDSClient(port).ListFileServers(context.Background(), &metadata.ListFileServersRequest{})
+		if err != nil {
+			slog.Error(err.Error())
+			return nil, err
+		}
+		servers = append(servers, r.Servers...)
+	}
+	return servers, nil
+}
+
+// RepairStatus returns the progress of the repair of files that lack copies,
+// one status per metadata server.
+func (c *Client) RepairStatus() ([]*metadata.RepairStatusResponse, error) {
+	var res []*metadata.RepairStatusResponse
+	for _, port := range c.mdsPorts {
+		r, err := NewMDSClient(port).GetRepairStatus(context.Background(), &metadata.RepairStatusRequest{})
+		if err != nil {
+			slog.Error(err.Error())
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// invalidate drops a prefetched directory from the cache
+func (c *Client) invalidate(dir string) {
+	c.cache.drop(dirName(dir))
+}
+
+// Find returns the entries below req.Name that match the predicates of req,
+// searched by the metadata servers instead of walking the tree entry by entry.
+func (c *Client) Find(req *metadata.FindRequest) ([]*metadata.FileInfo, error) {
+	servers := []metadata.MetadataServiceClient{c.mds(req.Name)}
+	if c.sharded(req.Name) {
+		servers = nil
+		for _, port := range c.mdsPorts {
+			servers = append(servers, NewMDSClient(port))
+		}
+	}
+	var res []*metadata.FileInfo
+	for _, mds := range servers {
+		stream, err := mds.Find(context.Background(), req)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			info, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				slog.Error("could not find entries", "dir", req.Name, "err", err)
+				return nil, err
+			}
+			res = append(res, info)
+		}
+	}
+	return res, nil
+}
+
+// WalkTree calls f with every entry below root, walked by the metadata
+// servers in the given order, and waits for the calls to be done. A walk the
+// server breaks off is resumed after the last batch received.
+func (c *Client) WalkTree(root string, order metadata.WalkOrder, maxDepth int, f func(*metadata.FileInfo, *sync.WaitGroup)) error {
+	servers := []metadata.MetadataServiceClient{c.mds(root)}
+	if c.sharded(root) {
+		servers = nil
+		for _, port := range c.mdsPorts {
+			servers = append(servers, NewMDSClient(port))
+		}
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, mds := range servers {
+		req := &metadata.WalkTreeRequest{
+			Name:     root,
+			Order:    order,
+			MaxDepth: int32(maxDepth),
+		}
+		for retries := 0; ; retries++ {
+			stream, err := mds.WalkTree(context.Background(), req)
+			for err == nil {
+				var res *metadata.WalkTreeResponse
+				res, err = stream.Recv()
+				if err != nil {
+					break
+				}
+				for _, e := range res.Entries {
+					wg.Add(1)
+					f(e, &wg)
+				}
+				req.ResumeToken = res.ResumeToken
+				retries = 0
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if status.Code(err) != codes.Unavailable || retries == walkRetries {
+				slog.Error("could not walk tree", "root", root, "err", err)
+				return err
+			}
+			slog.Warn("resuming walk", "root", root, "err", err)
+			time.Sleep(watchRetry)
+		}
+	}
+	return nil
+}
+
+// times in a row a walk is resumed without receiving anything
+const walkRetries = 5
+
+// pause before a dropped watch is resumed
+const watchRetry = 500 * time.Millisecond
+
+// Watch calls fn with the changes of a directory, or of its whole subtree if
+// recursive is set, until ctx is done or fn returns an error. A watch that
+// was dropped by the server is resumed after the last event received;
+// fromSeq resumes an earlier watch and 0 only watches for new events, the
+// ones after the sequence number the server reports when the watch starts.
+// The events also drop the prefetched directories they concern.
+//
+// The root of a sharded namespace is watched on every shard, fn gets the
+// events of all of them. Each shard numbers its events on its own, so such a
+// watch can only start with the new events.
+func (c *Client) Watch(ctx context.Context, name string, recursive bool, fromSeq uint64, fn func(*metadata.WatchEvent) error) error {
+	if !c.sharded(name) {
+		return c.watch(ctx, c.mds(name), name, recursive, fromSeq, fn)
+	}
+	if fromSeq != 0 {
+		return errors.New("a watch of the root of a sharded namespace cannot be resumed from a sequence number")
+	}
+	var shards []metadata.MetadataServiceClient
+	for _, port := range c.mdsPorts {
+		shards = append(shards, NewMDSClient(port))
+	}
+	return c.watchShards(ctx, shards, name, recursive, fn)
+}
+
+// watchShards watches name on every shard and calls fn with the events of
+// all of them, one at a time. The first watch that fails ends the others.
+func (c *Client) watchShards(ctx context.Context, shards []metadata.MetadataServiceClient, name string, recursive bool, fn func(*metadata.WatchEvent) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu    sync.Mutex
+		first error
+		wg    sync.WaitGroup
+	)
+	for _, mds := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.watch(ctx, mds, name, recursive, 0, func(ev *metadata.WatchEvent) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if first != nil {
+					return first
+				}
+				return fn(ev)
+			})
+			mu.Lock()
+			if first == nil {
+				first = err
+			}
+			mu.Unlock()
+			cancel()
+		}()
+	}
+	wg.Wait()
+	return first
+}
+
+// watch follows name on a single metadata server, see Watch.
+func (c *Client) watch(ctx context.Context, mds metadata.MetadataServiceClient, name string, recursive bool, fromSeq uint64, fn func(*metadata.WatchEvent) error) error {
+	last := fromSeq
+	for {
+		stream, err := mds.Watch(ctx, &metadata.WatchRequest{
+			Name:      name,
+			Recursive: recursive,
+			FromSeq:   last,
+		})
+		// a new watch is resumed from where it started, even if it is
+		// dropped before the first event
+		if err == nil && last == 0 {
+			var header grpcmd.MD
+			if header, err = stream.Header(); err == nil {
+				if seq := header.Get(metadata.WatchSeqHeader); len(seq) == 1 {
+					last, err = strconv.ParseUint(seq[0], 10, 64)
+				}
+			}
+		}
+		for err == nil {
+			var ev *metadata.WatchEvent
+			ev, err = stream.Recv()
+			if err != nil {
+				break
+			}
+			last = ev.Seq
+			c.invalidate(path.Dir(ev.Path))
+			if ev.IsDir {
+				c.invalidate(ev.Path)
+			}
+			if ev.NewPath != "" {
+				c.invalidate(ev.Path)
+				c.invalidate(path.Dir(ev.NewPath))
+			}
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the server went away or the watch fell behind
+		if code := status.Code(err); code != codes.Unavailable && code != codes.ResourceExhausted {
+			slog.Error("watch failed", "dir", name, "err", err)
+			return err
+		}
+		slog.Warn("resuming watch", "dir", name, "after", last, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(watchRetry):
+		}
+	}
+}
+
+// GetFile reads a file, falling back to the other replicas if a file server
+// does not answer and reconstructing missing fragments of erasure coded files.
+func (c *Client) GetFile(name string) ([]byte, error) {
+	mds := c.mds(name)
+	loc, err := mds.GetLocation(context.Background(), &metadata.LocRequest{
 		Name: name,
 	})
 	if err != nil {
 		slog.Error(err.Error())
 		return nil, err
 	}
-	return fr.Data, nil
+	return c.load(loc)
 }
 
-func (c *Client) GetFileFromPort(port int32, name string) ([]byte, error) {
+// GetFileFromPort reads the data stored under objectID on the given file server.
+func (c *Client) GetFileFromPort(port int32, objectID string) ([]byte, error) {
 	fs := helpers.NewFileServiceClient(port)
 	fr, err := fs.GetFile(context.Background(), &files.GetFileRequest{
-		Name: name,
+		Name: objectID,
 	})
 	if err != nil {
 		slog.Error(err.Error())
@@ -214,10 +967,10 @@ func (c *Client) GetFileFromPort(port int32, name string) ([]byte, error) {
 	return fr.Data, nil
 }
 
-func (c *Client) GetFileFromPortWithStream(port int32, name string) ([]byte, error) {
+func (c *Client) GetFileFromPortWithStream(port int32, objectID string) ([]byte, error) {
 	fs := helpers.NewFileServiceClient(port)
 	stream, err := fs.GetFileWithStream(context.Background(), &files.GetFileWithStreamRequest{
-		Name: name,
+		Name: objectID,
 	})
 	if err != nil {
 		slog.Error(err.Error())
@@ -240,10 +993,10 @@ func (c *Client) GetFileFromPortWithStream(port int32, name string) ([]byte, err
 	return data, nil
 }
 
-func (c *Client) GrepOnFileServer(fileName string, word string, port int32) (int, error) {
+func (c *Client) GrepOnFileServer(objectID string, word string, port int32) (int, error) {
 	fs := helpers.NewFileServiceClient(port)
 	r, err := fs.Grep(context.Background(), &files.GrepRequest{
-		FileName: fileName,
+		FileName: objectID,
 		Word:     word,
 	})
 	if err != nil {
@@ -252,9 +1005,16 @@ func (c *Client) GrepOnFileServer(fileName string, word string, port int32) (int
 	return int(r.Count), nil
 }
 
+// NewMDSClient connects to the metadata server listening on port. Requests
+// rejected by a follower of a replicated metadata service are retried on
+// the leader.
 func NewMDSClient(port int) metadata.MetadataServiceClient {
 	var conn *grpc.ClientConn
-	conn, err := grpc.NewClient(fmt.Sprintf(":%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(fmt.Sprintf(":%d", port),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(metadata.FollowLeader),
+		grpc.WithStreamInterceptor(metadata.FollowLeaderStream),
+	)
 	if err != nil {
 		log.Fatalf("could not connect. err: %v", err)
 	}
@@ -262,31 +1022,29 @@ func NewMDSClient(port int) metadata.MetadataServiceClient {
 }
 
 func (c *Client) CreateFileWithStream(name string, data []byte) (int, error) {
-	mds := NewMDSClient(c.mdsPort)
-	rec, err := mds.RegisterFileCreation(context.Background(), &metadata.RecRequest{
-		Name:     name,
-		FileSize: int64(len(data)),
-	})
+	n, err := c.create(name, data, 0, streamFile)
 	if err != nil {
-		slog.Error("getting storage location recommendation failed", "err", err.Error())
 		return 0, err
 	}
+	slog.Info("file uploaded", "size", n)
+	return n, nil
+}
 
-	fs := helpers.NewFileServiceClient(rec.Port)
+// streamFile writes data in chunks to the file server listening on port.
+func streamFile(port int32, objectID string, data []byte) (int64, error) {
+	fs := helpers.NewFileServiceClient(port)
 	stream, err := fs.CreateFileWithStream(context.Background())
 	if err != nil {
-		slog.Error(err.Error())
 		return 0, err
 	}
 	err = stream.Send(&files.CreateFileWithStreamRequest{
 		Data: &files.CreateFileWithStreamRequest_Info{
 			Info: &files.FileInfo{
-				Name: name,
+				Name: objectID,
 			},
 		},
 	})
 	if err != nil {
-		slog.Error(err.Error())
 		return 0, err
 	}
 
@@ -299,7 +1057,6 @@ func (c *Client) CreateFileWithStream(name string, data []byte) (int, error) {
 			break
 		}
 		if err != nil {
-			slog.Error(err.Error())
 			return 0, err
 		}
 		err = stream.Send(
@@ -310,22 +1067,19 @@ func (c *Client) CreateFileWithStream(name string, data []byte) (int, error) {
 			},
 		)
 		if err != nil {
-			slog.Error(err.Error())
 			return 0, err
 		}
 	}
 
 	res, err := stream.CloseAndRecv()
 	if err != nil {
-		log.Fatal("cannot receive response: ", err)
+		return 0, fmt.Errorf("cannot receive response: %w", err)
 	}
-
-	slog.Info("file uploaded", "size", res.GetBytesWritten())
-	return int(res.BytesWritten), nil
+	return res.BytesWritten, nil
 }
 
 func (c *Client) GetFileWithStream(name string) ([]byte, error) {
-	mds := NewMDSClient(c.mdsPort)
+	mds := c.mds(name)
 	loc, err := mds.GetLocation(context.Background(), &metadata.LocRequest{
 		Name: name,
 	})
@@ -333,7 +1087,7 @@ func (c *Client) GetFileWithStream(name string) ([]byte, error) {
 		slog.Error(err.Error())
 		return nil, err
 	}
-	data, err := c.GetFileFromPort(loc.Port, name)
+	data, err := c.load(loc)
 	if err != nil {
 		slog.Error(err.Error())
 		return nil, err
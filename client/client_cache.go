@@ -1,6 +1,11 @@
 package client
 
-import "github.com/tevintchuinkam/dfs/metadata"
+import (
+	"io"
+	"sync"
+
+	"github.com/tevintchuinkam/dfs/metadata"
+)
 
 type dirContents struct {
 	// is this the entire directory or just parts of it ?
@@ -13,5 +18,73 @@ type dirName string
 // metadata as part of the metadata prefetching
 // optimization
 type ClientCache struct {
+	// guards dirs and listings, watches drop directories concurrently
+	mu   sync.Mutex
 	dirs map[dirName]dirContents
+	// directories being read entry by entry
+	listings map[dirName]*listing
+}
+
+func (c *ClientCache) dir(name dirName) (dirContents, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.dirs[name]
+	return d, ok
+}
+
+func (c *ClientCache) put(name dirName, d dirContents) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dirs[name] = d
+}
+
+func (c *ClientCache) drop(name dirName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.dirs, name)
+}
+
+// listing holds the pages of a directory read so far and the cursor to read
+// the next one with.
+type listing struct {
+	// guards the fields below, the readers of a directory share its listing
+	mu      sync.Mutex
+	entries []*metadata.FileInfo
+	cursor  []byte
+	eof     bool
+}
+
+// entry returns the entry at index, reading pages with next until it is
+// listed or the directory ended, when it returns io.EOF.
+func (l *listing) entry(index int, next func(cursor []byte) (*metadata.ReadDirPlusResponse, error)) (*metadata.FileInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for index >= len(l.entries) && !l.eof {
+		r, err := next(l.cursor)
+		if err != nil {
+			return nil, err
+		}
+		l.entries = append(l.entries, r.Entries...)
+		l.cursor = r.Cursor
+		l.eof = r.Eof
+	}
+	if index >= len(l.entries) {
+		return nil, io.EOF
+	}
+	return l.entries[index], nil
+}
+
+// listing returns the listing of a directory, starting a new one if fresh is set.
+func (c *ClientCache) listing(name dirName, fresh bool) *listing {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listings == nil {
+		c.listings = make(map[dirName]*listing)
+	}
+	l, ok := c.listings[name]
+	if !ok || fresh {
+		l = new(listing)
+		c.listings[name] = l
+	}
+	return l
 }
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/tevintchuinkam/dfs/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestListing(t *testing.T) {
+	var entries []*metadata.FileInfo
+	for i := range 10 {
+		entries = append(entries, &metadata.FileInfo{Name: fmt.Sprintf("f%d", i)})
+	}
+	var mu sync.Mutex
+	pages := 0
+	// returns pages of 3 entries, the cursor is the index of the next one
+	next := func(cursor []byte) (*metadata.ReadDirPlusResponse, error) {
+		mu.Lock()
+		pages++
+		mu.Unlock()
+		i := 0
+		if len(cursor) > 0 {
+			i = int(cursor[0])
+		}
+		end := min(i+3, len(entries))
+		return &metadata.ReadDirPlusResponse{Entries: entries[i:end], Cursor: []byte{byte(end)}, Eof: end == len(entries)}, nil
+	}
+
+	// readers of the same directory share its pages
+	l := new(listing)
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, want := range entries {
+				if e, err := l.entry(i, next); err != nil || e != want {
+					t.Errorf("expected entry %d to be %s, got %v, %v", i, want.Name, e, err)
+				}
+			}
+			if _, err := l.entry(len(entries), next); err != io.EOF {
+				t.Errorf("expected the listing to end, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if pages != 4 {
+		t.Errorf("expected the 4 pages to be read once, got %d reads", pages)
+	}
+}
+
+// fakeShard lists entries two at a time like the root of a metadata shard.
+type fakeShard struct {
+	metadata.MetadataServiceClient
+	entries  []*metadata.FileInfo
+	pages    int
+	events   []*metadata.WatchEvent
+	watchErr error
+}
+
+func (f *fakeShard) ReadDirPlus(ctx context.Context, in *metadata.ReadDirPlusRequest, opts ...grpc.CallOption) (*metadata.ReadDirPlusResponse, error) {
+	f.pages++
+	i := 0
+	if len(in.Cursor) > 0 {
+		i = int(in.Cursor[0])
+	}
+	end := min(i+2, len(f.entries))
+	return &metadata.ReadDirPlusResponse{Entries: f.entries[i:end], Cursor: []byte{byte(end)}, Eof: end == len(f.entries)}, nil
+}
+
+func (f *fakeShard) Watch(ctx context.Context, in *metadata.WatchRequest, opts ...grpc.CallOption) (metadata.MetadataService_WatchClient, error) {
+	return &fakeWatch{ctx: ctx, events: f.events, err: f.watchErr}, nil
+}
+
+// fakeWatch sends its events, then fails with err or waits for the end of
+// the watch.
+type fakeWatch struct {
+	grpc.ClientStream
+	ctx    context.Context
+	events []*metadata.WatchEvent
+	err    error
+}
+
+func (w *fakeWatch) Header() (grpcmd.MD, error) {
+	return nil, nil
+}
+
+func (w *fakeWatch) Recv() (*metadata.WatchEvent, error) {
+	if len(w.events) > 0 {
+		ev := w.events[0]
+		w.events = w.events[1:]
+		return ev, nil
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	<-w.ctx.Done()
+	return nil, status.FromContextError(w.ctx.Err()).Err()
+}
+
+func TestShardedWatch(t *testing.T) {
+	c := NewSharded(nil, 0)
+	var shards []metadata.MetadataServiceClient
+	want := map[string]bool{}
+	for s := range 3 {
+		f := new(fakeShard)
+		for i := range 2 {
+			p := fmt.Sprintf("s%d-d%d", s, i)
+			f.events = append(f.events, &metadata.WatchEvent{Seq: uint64(i + 1), Path: p})
+			want[p] = true
+		}
+		shards = append(shards, f)
+	}
+
+	// the events of every shard reach fn
+	stop := errors.New("stop")
+	got := map[string]bool{}
+	err := c.watchShards(context.Background(), shards, ".", true, func(ev *metadata.WatchEvent) error {
+		got[ev.Path] = true
+		if len(got) == len(want) {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected the watch to end with the error of fn, got %v", err)
+	}
+	for p := range want {
+		if !got[p] {
+			t.Errorf("expected an event for %s", p)
+		}
+	}
+
+	// a shard that fails ends the watch of the others
+	failed := status.Error(codes.PermissionDenied, "denied")
+	shards[1].(*fakeShard).watchErr = failed
+	err = c.watchShards(context.Background(), shards, ".", true, func(*metadata.WatchEvent) error { return nil })
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected the watch to end with the failure of a shard, got %v", err)
+	}
+}
+
+func TestShardedListing(t *testing.T) {
+	var shards []metadata.MetadataServiceClient
+	var fakes []*fakeShard
+	var want []string
+	for s, n := range []int{3, 0, 2} {
+		f := new(fakeShard)
+		for i := range n {
+			name := fmt.Sprintf("s%d-f%d", s, i)
+			f.entries = append(f.entries, &metadata.FileInfo{Name: name})
+			want = append(want, name)
+		}
+		fakes = append(fakes, f)
+		shards = append(shards, f)
+	}
+
+	// the shards are read one after another, each page once
+	l := new(listing)
+	next := shardedPages(shards, ".")
+	for range 2 {
+		var got []string
+		for i := 0; ; i++ {
+			e, err := l.entry(i, next)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, e.Name)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("expected the root to list %v, got %v", want, got)
+		}
+	}
+	for s, pages := range []int{2, 1, 1} {
+		if fakes[s].pages != pages {
+			t.Errorf("expected %d pages of shard %d to be read, got %d", pages, s, fakes[s].pages)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/tevintchuinkam/dfs/metadata"
+)
+
+// writeFragments splits data into the fragments of an erasure coded file and
+// writes them to their file servers in parallel.
+func writeFragments(rec *metadata.RecResponse, data []byte, write func(port int32, name string, data []byte) (int64, error)) (int, error) {
+	shards, err := encode(data, int(rec.DataShards), int(rec.ParityShards))
+	if err != nil {
+		return 0, err
+	}
+	if len(shards) != len(rec.Fragments) {
+		return 0, fmt.Errorf("%d fragments but %d file servers to store them on", len(shards), len(rec.Fragments))
+	}
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, port := range rec.Fragments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = write(port, metadata.FragmentName(rec.ObjectId, i), shards[i])
+			if errs[i] != nil {
+				slog.Error("writing fragment failed", "fragment", i, "port", port, "err", errs[i].Error())
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// readFragments rebuilds the data of an erasure coded file. The data
+// fragments are read first, the parity fragments only if some of them are
+// missing.
+func readFragments(loc *metadata.LocResponse, read func(port int32, name string) ([]byte, error)) ([]byte, error) {
+	dataShards, parityShards := int(loc.DataShards), int(loc.ParityShards)
+	if len(loc.Fragments) != dataShards+parityShards {
+		return nil, fmt.Errorf("%d fragments expected but %d are known", dataShards+parityShards, len(loc.Fragments))
+	}
+	shards := make([][]byte, len(loc.Fragments))
+	fetch := func(from, to int) (missing int) {
+		var wg sync.WaitGroup
+		for i := from; i < to; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				data, err := read(loc.Fragments[i], metadata.FragmentName(loc.ObjectId, i))
+				if err != nil {
+					slog.Warn("reading fragment failed", "fragment", i, "port", loc.Fragments[i], "err", err)
+					return
+				}
+				shards[i] = data
+			}()
+		}
+		wg.Wait()
+		for i := from; i < to; i++ {
+			if shards[i] == nil {
+				missing++
+			}
+		}
+		return missing
+	}
+	if missing := fetch(0, dataShards); missing > 0 {
+		slog.Info("reconstructing missing fragments", "missing", missing)
+		fetch(dataShards, len(shards))
+	}
+	return decode(shards, dataShards, parityShards, loc.Size)
+}
+
+// encode splits data into dataShards fragments and computes parityShards
+// parity fragments from them.
+func encode(data []byte, dataShards, parityShards int) ([][]byte, error) {
+	if len(data) == 0 {
+		return make([][]byte, dataShards+parityShards), nil
+	}
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	shards, err := enc.Split(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(shards); err != nil {
+		return nil, err
+	}
+	return shards, nil
+}
+
+// decode joins the fragments of size bytes of data, missing fragments are nil.
+func decode(shards [][]byte, dataShards, parityShards int, size int64) ([]byte, error) {
+	if size == 0 {
+		return []byte{}, nil
+	}
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, err
+	}
+	if err := enc.ReconstructData(shards); err != nil {
+		return nil, fmt.Errorf("too many fragments are missing: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := enc.Join(&buf, shards, int(size)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
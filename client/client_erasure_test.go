@@ -0,0 +1,63 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestErasureCoding(t *testing.T) {
+	data := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i * 7)
+		}
+		return b
+	}
+	for _, tc := range []struct {
+		size         int
+		dataShards   int
+		parityShards int
+		missing      []int
+		wantErr      bool
+	}{
+		{0, 4, 2, nil, false},
+		{1, 4, 2, nil, false},
+		{1000, 4, 2, nil, false},
+		// sizes that do not divide evenly into the data fragments
+		{1001, 4, 2, []int{0}, false},
+		{7, 4, 2, []int{1, 3}, false},
+		{1000, 4, 2, []int{4, 5}, false},
+		{1000, 4, 2, []int{0, 5}, false},
+		{1000, 4, 2, []int{0, 1, 2}, true},
+		{1000, 6, 3, []int{0, 2, 4}, false},
+		{1000, 6, 3, []int{6, 7, 8, 1}, true},
+	} {
+		t.Run(fmt.Sprintf("%d bytes %d+%d missing %v", tc.size, tc.dataShards, tc.parityShards, tc.missing), func(t *testing.T) {
+			want := data(tc.size)
+			shards, err := encode(want, tc.dataShards, tc.parityShards)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(shards) != tc.dataShards+tc.parityShards {
+				t.Fatalf("expected %d fragments, got %d", tc.dataShards+tc.parityShards, len(shards))
+			}
+			for _, i := range tc.missing {
+				shards[i] = nil
+			}
+			got, err := decode(shards, tc.dataShards, tc.parityShards, int64(tc.size))
+			if tc.wantErr {
+				if err == nil {
+					t.Error("expected decoding to fail with too many missing fragments")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("expected the decoded data to match, got %d bytes instead of %d", len(got), len(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/tevintchuinkam/dfs/metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// shortest pause between two renewals of a lease
+const minLeaseRenewal = 100 * time.Millisecond
+
+// Lease is an advisory lock held by the client. Its lease is renewed in the
+// background until Unlock is called or a renewal fails, e.g. because the
+// metadata server holding the lock restarted, when Lost is closed.
+type Lease struct {
+	name string
+	id   string
+	mds  metadata.MetadataServiceClient
+	once sync.Once
+	// closed by Unlock to stop the renewals, done once they stopped
+	stop chan struct{}
+	done chan struct{}
+	// closed when the lock was lost, err tells why
+	lost chan struct{}
+	err  error
+}
+
+// Lock takes an advisory lock on name, which does not need to exist, and
+// waits for it while ctx allows. A shared lock only conflicts with exclusive
+// locks on name, its directories or the entries below it, an exclusive lock
+// with all of them.
+func (c *Client) Lock(ctx context.Context, name string, exclusive bool) (*Lease, error) {
+	mode := metadata.LockMode_SHARED
+	if exclusive {
+		mode = metadata.LockMode_EXCLUSIVE
+	}
+	mds := c.mds(name)
+	res, err := mds.Lock(ctx, &metadata.LockRequest{
+		Name:  name,
+		Mode:  mode,
+		Owner: c.owner,
+		Wait:  true,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		return nil, err
+	}
+	return startLease(mds, name, res.LeaseId, res.Expires.AsTime()), nil
+}
+
+// startLease returns the lease id of a lock on name that expires at expires
+// and starts renewing it.
+func startLease(mds metadata.MetadataServiceClient, name, id string, expires time.Time) *Lease {
+	l := &Lease{
+		name: name,
+		id:   id,
+		mds:  mds,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		lost: make(chan struct{}),
+	}
+	go l.renew(expires)
+	return l
+}
+
+// renew extends the lease whenever half of it is left. Renewals that fail
+// for another reason than the lease being gone are tried again until it
+// expires.
+func (l *Lease) renew(expires time.Time) {
+	defer close(l.done)
+	for {
+		timer := time.NewTimer(max(time.Until(expires)/2, minLeaseRenewal))
+		select {
+		case <-l.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		res, err := l.mds.RenewLease(context.Background(), &metadata.RenewLeaseRequest{Name: l.name, LeaseId: l.id})
+		if err == nil {
+			expires = res.Expires.AsTime()
+			continue
+		}
+		if status.Code(err) != codes.NotFound && time.Now().Before(expires) {
+			slog.Warn("could not renew lease", "name", l.name, "err", err)
+			continue
+		}
+		slog.Error("lost lock", "name", l.name, "err", err)
+		l.err = err
+		close(l.lost)
+		return
+	}
+}
+
+// Lost is closed when the lock was lost before it was released, after which
+// Err returns why.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err returns why the lock was lost, nil while it is held.
+func (l *Lease) Err() error {
+	select {
+	case <-l.lost:
+		return l.err
+	default:
+		return nil
+	}
+}
+
+// Unlock releases the lock and stops renewing its lease. It returns why the
+// lock was lost if it was.
+func (l *Lease) Unlock() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+		if err = l.Err(); err != nil {
+			return
+		}
+		if _, err = l.mds.Unlock(context.Background(), &metadata.UnlockRequest{Name: l.name, LeaseId: l.id}); err != nil {
+			slog.Error(err.Error())
+		}
+	})
+	return err
+}
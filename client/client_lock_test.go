@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tevintchuinkam/dfs/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeLocks answers the lease requests of a Lease like a metadata server.
+type fakeLocks struct {
+	metadata.MetadataServiceClient
+	mu sync.Mutex
+	// errors returned by the next renewals, nil ones renew the lease
+	errs     []error
+	renewals int
+	unlocked []string
+}
+
+func (f *fakeLocks) RenewLease(ctx context.Context, in *metadata.RenewLeaseRequest, opts ...grpc.CallOption) (*metadata.RenewLeaseResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.renewals++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &metadata.RenewLeaseResponse{Expires: timestamppb.New(time.Now().Add(2 * minLeaseRenewal))}, nil
+}
+
+func (f *fakeLocks) Unlock(ctx context.Context, in *metadata.UnlockRequest, opts ...grpc.CallOption) (*metadata.UnlockResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unlocked = append(f.unlocked, in.LeaseId)
+	return &metadata.UnlockResponse{}, nil
+}
+
+func (f *fakeLocks) count() (renewals, unlocked int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.renewals, len(f.unlocked)
+}
+
+func TestLeaseRenewal(t *testing.T) {
+	// failed renewals are tried again while the lease lasts
+	mds := &fakeLocks{errs: []error{status.Error(codes.Unavailable, "no leader")}}
+	l := startLease(mds, "a", "id", time.Now().Add(2*minLeaseRenewal))
+	deadline := time.Now().Add(5 * time.Second)
+	for renewals, _ := mds.count(); renewals < 3; renewals, _ = mds.count() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the lease to be renewed, got %d renewals", renewals)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := l.Err(); err != nil {
+		t.Errorf("expected the lock to be held, got %v", err)
+	}
+
+	// unlocking stops the renewals and releases the lock once
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Errorf("expected unlocking again to do nothing, got %v", err)
+	}
+	renewals, unlocked := mds.count()
+	if unlocked != 1 || mds.unlocked[0] != "id" {
+		t.Errorf("expected the lease to be released once, got %v", mds.unlocked)
+	}
+	time.Sleep(2 * minLeaseRenewal)
+	if after, _ := mds.count(); after != renewals {
+		t.Errorf("expected the renewals to stop, got %d more", after-renewals)
+	}
+}
+
+func TestLeaseLost(t *testing.T) {
+	// a lease the server does not know anymore is lost right away
+	mds := &fakeLocks{errs: []error{status.Error(codes.NotFound, "lease expired")}}
+	l := startLease(mds, "a", "id", time.Now().Add(2*minLeaseRenewal))
+	select {
+	case <-l.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the lock to be lost")
+	}
+	if status.Code(l.Err()) != codes.NotFound {
+		t.Errorf("expected the lock to be lost since the lease expired, got %v", l.Err())
+	}
+	if err := l.Unlock(); status.Code(err) != codes.NotFound {
+		t.Errorf("expected unlocking to report the lost lock, got %v", err)
+	}
+	if _, unlocked := mds.count(); unlocked != 0 {
+		t.Errorf("expected a lost lock not to be released, got %d releases", unlocked)
+	}
+
+	// so is one that could not be renewed before it expired
+	mds = &fakeLocks{errs: []error{status.Error(codes.Unavailable, "no leader")}}
+	l = startLease(mds, "a", "id", time.Now().Add(minLeaseRenewal/2))
+	select {
+	case <-l.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the lock to be lost")
+	}
+	if status.Code(l.Err()) != codes.Unavailable {
+		t.Errorf("expected the lock to be lost with the last error, got %v", l.Err())
+	}
+}
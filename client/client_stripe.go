@@ -0,0 +1,81 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/tevintchuinkam/dfs/metadata"
+)
+
+// writeStripes cuts data into the stripe objects of a striped file and writes
+// them to their file servers in parallel.
+func writeStripes(rec *metadata.RecResponse, data []byte, write func(port int32, name string, data []byte) (int64, error)) (int, error) {
+	objects := splitStripes(data, rec.StripeSize, len(rec.Fragments))
+	errs := make([]error, len(objects))
+	var wg sync.WaitGroup
+	for i, port := range rec.Fragments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = write(port, metadata.FragmentName(rec.ObjectId, i), objects[i])
+			if errs[i] != nil {
+				slog.Error("writing stripe failed", "stripe", i, "port", port, "err", errs[i].Error())
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// readStripes reads the stripe objects of a striped file in parallel and
+// puts the data back together.
+func readStripes(loc *metadata.LocResponse, read func(port int32, name string) ([]byte, error)) ([]byte, error) {
+	objects := make([][]byte, len(loc.Fragments))
+	errs := make([]error, len(loc.Fragments))
+	var wg sync.WaitGroup
+	for i, port := range loc.Fragments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			objects[i], errs[i] = read(port, metadata.FragmentName(loc.ObjectId, i))
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return joinStripes(objects, loc.StripeSize, loc.Size)
+}
+
+// splitStripes cuts data into stripeSize chunks and deals them round-robin
+// to count stripe objects.
+func splitStripes(data []byte, stripeSize int64, count int) [][]byte {
+	objects := make([][]byte, count)
+	for c := 0; int64(c)*stripeSize < int64(len(data)); c++ {
+		start := int64(c) * stripeSize
+		end := min(start+stripeSize, int64(len(data)))
+		objects[c%count] = append(objects[c%count], data[start:end]...)
+	}
+	return objects
+}
+
+// joinStripes reverses splitStripes for size bytes of data.
+func joinStripes(objects [][]byte, stripeSize int64, size int64) ([]byte, error) {
+	data := make([]byte, 0, size)
+	count := len(objects)
+	for c := 0; int64(len(data)) < size; c++ {
+		object := objects[c%count]
+		start := int64(c/count) * stripeSize
+		end := min(start+stripeSize, int64(len(object)), start+size-int64(len(data)))
+		if start >= end {
+			return nil, fmt.Errorf("stripe object %d is too short", c%count)
+		}
+		data = append(data, object[start:end]...)
+	}
+	return data, nil
+}
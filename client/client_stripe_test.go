@@ -0,0 +1,59 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStripes(t *testing.T) {
+	for _, tc := range []struct {
+		size       int
+		stripeSize int64
+		count      int
+		// bytes of each stripe object
+		want []int
+	}{
+		{0, 4, 3, []int{0, 0, 0}},
+		{3, 4, 3, []int{3, 0, 0}},
+		{12, 4, 3, []int{4, 4, 4}},
+		// a short last stripe, on the first object or on a later one
+		{13, 4, 3, []int{5, 4, 4}},
+		{18, 4, 3, []int{8, 6, 4}},
+		{22, 4, 3, []int{8, 8, 6}},
+		{5, 8, 1, []int{5}},
+	} {
+		t.Run(fmt.Sprintf("%d bytes in %d stripes of %d", tc.size, tc.count, tc.stripeSize), func(t *testing.T) {
+			data := make([]byte, tc.size)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			objects := splitStripes(data, tc.stripeSize, tc.count)
+			var sizes []int
+			for _, o := range objects {
+				sizes = append(sizes, len(o))
+			}
+			if fmt.Sprint(sizes) != fmt.Sprint(tc.want) {
+				t.Errorf("expected stripe objects of %v bytes, got %v", tc.want, sizes)
+			}
+			got, err := joinStripes(objects, tc.stripeSize, int64(tc.size))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("expected the joined data to match, got %v", got)
+			}
+			// an object that lost its tail cannot be joined
+			for i, o := range objects {
+				if len(o) == 0 {
+					continue
+				}
+				short := append([][]byte(nil), objects...)
+				short[i] = o[:len(o)-1]
+				if _, err := joinStripes(short, tc.stripeSize, int64(tc.size)); err == nil {
+					t.Errorf("expected joining with a short object %d to fail", i)
+				}
+			}
+		})
+	}
+}
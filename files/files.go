@@ -9,12 +9,18 @@ import (
 	"net"
 	"os"
 	"path"
+	"slices"
+	"strings"
 	sync "sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tevintchuinkam/dfs/grep"
 
 	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 func init() {
@@ -24,11 +30,26 @@ func init() {
 // ensures that FileServer implements chunkServiceClient
 var _ FileServiceServer = (*FileServer)(nil)
 
-func New(port int) *FileServer {
-	return &FileServer{
+// Option configures a FileServer created with New.
+type Option func(*FileServer)
+
+// WithRootDir sets the directory the data of the files is stored in. It
+// defaults to dfs-data/<port>.
+func WithRootDir(dir string) Option {
+	return func(s *FileServer) {
+		s.rootDir = dir
+	}
+}
+
+func New(port int, opts ...Option) *FileServer {
+	s := &FileServer{
 		port:    port,
 		rootDir: path.Join("./", "dfs-data", fmt.Sprint(port)),
 	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
 }
 
 type FileServer struct {
@@ -38,6 +59,16 @@ type FileServer struct {
 	grpcServer *grpc.Server
 	listener   net.Listener
 	mu         sync.Mutex
+
+	// bytes stored on the server, reported with the heartbeats
+	used           atomic.Int64
+	stopHeartbeats chan struct{}
+	// set once the server joined the metadata service, see Join
+	registrar Registrar
+	// file servers registered with the metadata service as of the last
+	// heartbeats, the only ones CopyFile pulls files from
+	muPeers sync.Mutex
+	peers   map[int32]bool
 }
 
 func (s *FileServer) Ping(ctx context.Context, req *PingRequest) (*PingResponse, error) {
@@ -53,6 +84,7 @@ func (s *FileServer) CreateFile(ctx context.Context, in *CreateFileRequest) (*Cr
 		log.Fatal(err)
 	}
 	p := path.Join(s.rootDir, in.Name)
+	old := fileSize(p)
 	file, err := os.Create(p)
 	if err != nil {
 		log.Fatal(err)
@@ -61,6 +93,7 @@ func (s *FileServer) CreateFile(ctx context.Context, in *CreateFileRequest) (*Cr
 	if err != nil {
 		log.Fatal(err)
 	}
+	s.used.Add(int64(written) - old)
 	return &CreateFileResponse{BytesWritten: int64(written)}, nil
 }
 
@@ -132,6 +165,20 @@ func (s *FileServer) Stop() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.registrar != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := s.registrar.Deregister(ctx, s.port); err != nil {
+			slog.Warn("could not deregister from the metadata service", "port", s.port, "err", err)
+		}
+		cancel()
+		s.registrar = nil
+	}
+
+	if s.stopHeartbeats != nil {
+		close(s.stopHeartbeats)
+		s.stopHeartbeats = nil
+	}
+
 	if s.grpcServer != nil {
 		s.grpcServer.GracefulStop()
 		s.grpcServer = nil
@@ -171,12 +218,13 @@ func (s *FileServer) CreateFileWithStream(stream FileService_CreateFileWithStrea
 		log.Fatal(err)
 	}
 	p := path.Join(s.rootDir, name)
+	s.used.Add(-fileSize(p))
 	file, err := os.Create(p)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fileSize := 0
+	written := 0
 	for {
 		req, err := stream.Recv()
 		if err == io.EOF {
@@ -191,10 +239,11 @@ func (s *FileServer) CreateFileWithStream(stream FileService_CreateFileWithStrea
 		if err != nil {
 			log.Fatal(err)
 		}
-		fileSize += n
+		written += n
+		s.used.Add(int64(n))
 	}
 	res := &CreateFileWithStreamResponse{
-		BytesWritten: int64(fileSize),
+		BytesWritten: int64(written),
 	}
 	err = stream.SendAndClose(res)
 	if err != nil {
@@ -204,6 +253,101 @@ func (s *FileServer) CreateFileWithStream(stream FileService_CreateFileWithStrea
 	return nil
 }
 
+// localPath returns where the file name is stored. Names are relative to the
+// data directory of the server and must not lead out of it.
+func (s *FileServer) localPath(name string) (string, error) {
+	if name == "" || path.IsAbs(name) || slices.Contains(strings.Split(name, "/"), "..") {
+		return "", status.Errorf(codes.InvalidArgument, "invalid file name %q", name)
+	}
+	root := path.Clean(s.rootDir)
+	p := path.Join(root, name)
+	if !strings.HasPrefix(p, root+"/") {
+		return "", status.Errorf(codes.InvalidArgument, "invalid file name %q", name)
+	}
+	return p, nil
+}
+
+// DeleteFile removes a file and the directories that became empty because of it.
+func (s *FileServer) DeleteFile(ctx context.Context, req *DeleteFileRequest) (*DeleteFileResponse, error) {
+	p, err := s.localPath(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	name := path.Clean(req.Name)
+	size := fileSize(p)
+	if err := os.Remove(p); err != nil {
+		slog.Error("could not delete file", "file", name, "err", err)
+		return nil, err
+	}
+	s.used.Add(-size)
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		// fails as soon as a directory still has entries
+		if err := os.Remove(path.Join(s.rootDir, dir)); err != nil {
+			break
+		}
+	}
+	return &DeleteFileResponse{}, nil
+}
+
+// CopyFile pulls a file from another file server, e.g. to replace a copy that
+// was lost with a failed server. The file only appears once it is complete.
+// The source has to be registered with the metadata service, which the
+// server learns from its heartbeats.
+func (s *FileServer) CopyFile(ctx context.Context, req *CopyFileRequest) (*CopyFileResponse, error) {
+	p, err := s.localPath(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !s.isPeer(req.Source) {
+		return nil, status.Errorf(codes.PermissionDenied, "%d is not a registered file server", req.Source)
+	}
+	name := path.Clean(req.Name)
+	conn, err := grpc.NewClient(fmt.Sprintf(":%d", req.Source), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stream, err := NewFileServiceClient(conn).GetFileWithStream(ctx, &GetFileWithStreamRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(path.Dir(p), os.ModePerm); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(path.Dir(p), ".copy-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	var written int64
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			slog.Error("failed to receive chunk", "file", name, "source", req.Source, "err", err)
+			return nil, err
+		}
+		n, err := tmp.Write(res.GetChunkData())
+		if err != nil {
+			return nil, err
+		}
+		written += int64(n)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	old := fileSize(p)
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return nil, err
+	}
+	s.used.Add(written - old)
+	return &CopyFileResponse{BytesWritten: written}, nil
+}
+
 func (s *FileServer) GetFileWithStream(req *GetFileWithStreamRequest, stream FileService_GetFileWithStreamServer) error {
 	// Build the file path
 	filePath := path.Join(s.rootDir, req.GetName())
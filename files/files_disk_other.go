@@ -0,0 +1,11 @@
+//go:build !unix
+
+package files
+
+import "errors"
+
+// diskFree is not supported on this platform, the capacity of the server is
+// reported as unknown.
+func diskFree(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
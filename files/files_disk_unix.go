@@ -0,0 +1,20 @@
+//go:build unix
+
+package files
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskFree returns the number of bytes available on the file system holding dir.
+func diskFree(dir string) (int64, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
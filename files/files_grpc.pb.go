@@ -28,6 +28,9 @@ type FileServiceClient interface {
 	Grep(ctx context.Context, in *GrepRequest, opts ...grpc.CallOption) (*GrepResponse, error)
 	CreateFileWithStream(ctx context.Context, opts ...grpc.CallOption) (FileService_CreateFileWithStreamClient, error)
 	GetFileWithStream(ctx context.Context, in *GetFileWithStreamRequest, opts ...grpc.CallOption) (FileService_GetFileWithStreamClient, error)
+	DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error)
+	// CopyFile pulls a file from another file server
+	CopyFile(ctx context.Context, in *CopyFileRequest, opts ...grpc.CallOption) (*CopyFileResponse, error)
 }
 
 type fileServiceClient struct {
@@ -140,6 +143,24 @@ func (x *fileServiceGetFileWithStreamClient) Recv() (*GetFileWithStreamResponse,
 	return m, nil
 }
 
+func (c *fileServiceClient) DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error) {
+	out := new(DeleteFileResponse)
+	err := c.cc.Invoke(ctx, "/files.FileService/DeleteFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) CopyFile(ctx context.Context, in *CopyFileRequest, opts ...grpc.CallOption) (*CopyFileResponse, error) {
+	out := new(CopyFileResponse)
+	err := c.cc.Invoke(ctx, "/files.FileService/CopyFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // FileServiceServer is the server API for FileService service.
 // All implementations must embed UnimplementedFileServiceServer
 // for forward compatibility
@@ -150,6 +171,9 @@ type FileServiceServer interface {
 	Grep(context.Context, *GrepRequest) (*GrepResponse, error)
 	CreateFileWithStream(FileService_CreateFileWithStreamServer) error
 	GetFileWithStream(*GetFileWithStreamRequest, FileService_GetFileWithStreamServer) error
+	DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error)
+	// CopyFile pulls a file from another file server
+	CopyFile(context.Context, *CopyFileRequest) (*CopyFileResponse, error)
 	mustEmbedUnimplementedFileServiceServer()
 }
 
@@ -175,6 +199,12 @@ func (UnimplementedFileServiceServer) CreateFileWithStream(FileService_CreateFil
 func (UnimplementedFileServiceServer) GetFileWithStream(*GetFileWithStreamRequest, FileService_GetFileWithStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetFileWithStream not implemented")
 }
+func (UnimplementedFileServiceServer) DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (UnimplementedFileServiceServer) CopyFile(context.Context, *CopyFileRequest) (*CopyFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CopyFile not implemented")
+}
 func (UnimplementedFileServiceServer) mustEmbedUnimplementedFileServiceServer() {}
 
 // UnsafeFileServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -307,6 +337,42 @@ func (x *fileServiceGetFileWithStreamServer) Send(m *GetFileWithStreamResponse)
 	return x.ServerStream.SendMsg(m)
 }
 
+func _FileService_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/files.FileService/DeleteFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).DeleteFile(ctx, req.(*DeleteFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_CopyFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CopyFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).CopyFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/files.FileService/CopyFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).CopyFile(ctx, req.(*CopyFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // FileService_ServiceDesc is the grpc.ServiceDesc for FileService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -330,6 +396,14 @@ var FileService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Grep",
 			Handler:    _FileService_Grep_Handler,
 		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _FileService_DeleteFile_Handler,
+		},
+		{
+			MethodName: "CopyFile",
+			Handler:    _FileService_CopyFile_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -345,3 +419,89 @@ var FileService_ServiceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "files.proto",
 }
+
+// HeartbeatServiceClient is the client API for HeartbeatService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type HeartbeatServiceClient interface {
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+}
+
+type heartbeatServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHeartbeatServiceClient(cc grpc.ClientConnInterface) HeartbeatServiceClient {
+	return &heartbeatServiceClient{cc}
+}
+
+func (c *heartbeatServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, "/files.HeartbeatService/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HeartbeatServiceServer is the server API for HeartbeatService service.
+// All implementations must embed UnimplementedHeartbeatServiceServer
+// for forward compatibility
+type HeartbeatServiceServer interface {
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	mustEmbedUnimplementedHeartbeatServiceServer()
+}
+
+// UnimplementedHeartbeatServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedHeartbeatServiceServer struct {
+}
+
+func (UnimplementedHeartbeatServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedHeartbeatServiceServer) mustEmbedUnimplementedHeartbeatServiceServer() {}
+
+// UnsafeHeartbeatServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HeartbeatServiceServer will
+// result in compilation errors.
+type UnsafeHeartbeatServiceServer interface {
+	mustEmbedUnimplementedHeartbeatServiceServer()
+}
+
+func RegisterHeartbeatServiceServer(s grpc.ServiceRegistrar, srv HeartbeatServiceServer) {
+	s.RegisterService(&HeartbeatService_ServiceDesc, srv)
+}
+
+func _HeartbeatService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeartbeatServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/files.HeartbeatService/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeartbeatServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// HeartbeatService_ServiceDesc is the grpc.ServiceDesc for HeartbeatService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var HeartbeatService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "files.HeartbeatService",
+	HandlerType: (*HeartbeatServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Heartbeat",
+			Handler:    _HeartbeatService_Heartbeat_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "files.proto",
+}
@@ -0,0 +1,195 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	grpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Registrar registers file servers with the metadata service. It is
+// implemented by the metadata package, which the files package cannot import.
+type Registrar interface {
+	Register(ctx context.Context, port int, capacity int64) error
+	Deregister(ctx context.Context, port int) error
+}
+
+// Join registers the server, which must already be serving, with the
+// metadata service through r. Should the metadata service forget the server,
+// e.g. after it lost its data, the heartbeats register it again. Stop
+// deregisters the server.
+func (s *FileServer) Join(ctx context.Context, r Registrar) error {
+	if err := s.loadUsage(); err != nil {
+		return err
+	}
+	if err := r.Register(ctx, s.port, s.capacity()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registrar = r
+	return nil
+}
+
+// StartHeartbeats reports to the metadata servers listening on mdsPorts that
+// the server is alive, every interval until the server is stopped. All
+// members of a replicated metadata service should be given, so that a new
+// leader knows the state of the file servers right away.
+func (s *FileServer) StartHeartbeats(interval time.Duration, mdsPorts ...int) error {
+	if err := s.loadUsage(); err != nil {
+		return err
+	}
+
+	var clients []HeartbeatServiceClient
+	var conns []*grpc.ClientConn
+	for _, port := range mdsPorts {
+		conn, err := grpc.NewClient(fmt.Sprintf(":%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		clients = append(clients, NewHeartbeatServiceClient(conn))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopHeartbeats == nil {
+		s.stopHeartbeats = make(chan struct{})
+	}
+	stop := s.stopHeartbeats
+	go func() {
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.sendHeartbeat(clients, interval)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *FileServer) sendHeartbeat(clients []HeartbeatServiceClient, timeout time.Duration) {
+	req := &HeartbeatRequest{
+		Port:     int32(s.port),
+		Used:     s.used.Load(),
+		Capacity: s.capacity(),
+	}
+	unknown := false
+	var peers map[int32]bool
+	for _, c := range clients {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		res, err := c.Heartbeat(ctx, req)
+		cancel()
+		if status.Code(err) == codes.NotFound {
+			unknown = true
+			continue
+		}
+		if err != nil {
+			slog.Debug("heartbeat failed", "port", s.port, "err", err)
+			continue
+		}
+		if peers == nil {
+			peers = make(map[int32]bool)
+		}
+		for _, port := range res.Servers {
+			peers[port] = true
+		}
+	}
+	if peers != nil {
+		s.muPeers.Lock()
+		s.peers = peers
+		s.muPeers.Unlock()
+	}
+	if !unknown {
+		return
+	}
+	s.mu.Lock()
+	r := s.registrar
+	s.mu.Unlock()
+	if r == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := r.Register(ctx, s.port, req.Capacity); err != nil {
+		slog.Warn("could not register again with the metadata service", "port", s.port, "err", err)
+		return
+	}
+	slog.Info("registered again with the metadata service", "port", s.port)
+}
+
+// isPeer reports whether the metadata service told the last heartbeats that
+// the file server listening on port is registered.
+func (s *FileServer) isPeer(port int32) bool {
+	s.muPeers.Lock()
+	defer s.muPeers.Unlock()
+	return s.peers[port]
+}
+
+// loadUsage sets the bytes stored on the server from the files on disk.
+func (s *FileServer) loadUsage() error {
+	used, err := diskUsage(s.rootDir)
+	if err != nil {
+		return err
+	}
+	s.used.Store(used)
+	return nil
+}
+
+// capacity returns the bytes the server can store in total or 0 if the free
+// disk space is unknown.
+func (s *FileServer) capacity() int64 {
+	free, err := diskFree(s.rootDir)
+	if err != nil {
+		return 0
+	}
+	return s.used.Load() + free
+}
+
+// diskUsage returns the number of bytes stored below dir.
+func diskUsage(dir string) (int64, error) {
+	var used int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			used += info.Size()
+		}
+		return nil
+	})
+	return used, err
+}
+
+// fileSize returns the size of the file at p or 0 if it does not exist.
+func fileSize(p string) int64 {
+	info, err := os.Stat(p)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
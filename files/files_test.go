@@ -0,0 +1,116 @@
+package files
+
+import (
+	"context"
+	"net"
+	"os"
+	"path"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDeleteFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := &FileServer{rootDir: path.Join(dir, "data")}
+	if err := os.MkdirAll(path.Join(s.rootDir, "a", "b"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a/b/f", "a/g", "../outside"} {
+		if err := os.WriteFile(path.Join(s.rootDir, name), []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.used.Store(12)
+
+	for _, name := range []string{"", "/a/g", "../outside", "a/../../outside", "a/b/../../../outside"} {
+		if _, err := s.DeleteFile(ctx, &DeleteFileRequest{Name: name}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected deleting %q to be rejected, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path.Join(dir, "outside")); err != nil {
+		t.Errorf("expected the file outside of the data directory to be kept, got %v", err)
+	}
+
+	// directories that became empty are removed, up to the data directory
+	if _, err := s.DeleteFile(ctx, &DeleteFileRequest{Name: "a/b/f"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(s.rootDir, "a", "b")); !os.IsNotExist(err) {
+		t.Errorf("expected the empty directory to be removed, got %v", err)
+	}
+	if _, err := s.DeleteFile(ctx, &DeleteFileRequest{Name: "a/g"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.rootDir); err != nil {
+		t.Errorf("expected the data directory to be kept, got %v", err)
+	}
+	if used := s.used.Load(); used != 4 {
+		t.Errorf("expected 4 bytes to be left in use, got %d", used)
+	}
+	if _, err := s.DeleteFile(ctx, &DeleteFileRequest{Name: "a/g"}); err == nil {
+		t.Error("expected deleting a missing file to fail")
+	}
+}
+
+// serve serves s on a free port and returns the port.
+func serve(t *testing.T, s *FileServer) int32 {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	RegisterFileServiceServer(srv, s)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return int32(lis.Addr().(*net.TCPAddr).Port)
+}
+
+func TestCopyFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := &FileServer{rootDir: path.Join(dir, "source")}
+	if err := os.MkdirAll(path.Join(source.rootDir, "a"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(source.rootDir, "a", "f"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	port := serve(t, source)
+	s := &FileServer{rootDir: path.Join(dir, "target")}
+
+	// only registered file servers are copied from
+	if _, err := s.CopyFile(ctx, &CopyFileRequest{Name: "a/f", Source: port}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected copying from an unknown server to be rejected, got %v", err)
+	}
+	s.peers = map[int32]bool{port: true}
+	for _, name := range []string{"", "/a/f", "../source/a/f", "a/../../outside"} {
+		if _, err := s.CopyFile(ctx, &CopyFileRequest{Name: name, Source: port}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected copying to %q to be rejected, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path.Join(dir, "outside")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written outside of the data directory, got %v", err)
+	}
+
+	res, err := s.CopyFile(ctx, &CopyFileRequest{Name: "a/f", Source: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.BytesWritten != 4 || s.used.Load() != 4 {
+		t.Errorf("expected 4 bytes to be copied, got %d with %d in use", res.BytesWritten, s.used.Load())
+	}
+	if data, err := os.ReadFile(path.Join(s.rootDir, "a", "f")); err != nil || string(data) != "data" {
+		t.Errorf("expected the copy to hold the data, got %q, %v", data, err)
+	}
+	if _, err := s.CopyFile(ctx, &CopyFileRequest{Name: "a/missing", Source: port}); err == nil {
+		t.Error("expected copying a missing file to fail")
+	}
+	if entries, err := os.ReadDir(path.Join(s.rootDir, "a")); err != nil || len(entries) != 1 {
+		t.Errorf("expected a failed copy to leave nothing behind, got %v, %v", entries, err)
+	}
+}
@@ -3,12 +3,14 @@ module github.com/tevintchuinkam/dfs
 go 1.22.4
 
 require (
+	github.com/klauspost/reedsolomon v1.10.0
 	github.com/tevintchuinkam/dfs v0.0.0-20240706103911-ece53df10063
 	google.golang.org/grpc v1.65.0
 	google.golang.org/protobuf v1.34.2
 )
 
 require (
+	github.com/klauspost/cpuid/v2 v2.2.5 // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 	golang.org/x/text v0.15.0 // indirect
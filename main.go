@@ -30,6 +30,7 @@ var (
 
 var mds *metadata.MetaDataServer
 var fileServers []*files.FileServer
+var registrar *metadata.Registrar
 
 func startAllServers(latency time.Duration) {
 	// create the metadata server
@@ -48,8 +49,16 @@ func startAllServers(latency time.Duration) {
 		go s.Start(latency)
 	}
 	time.Sleep(1 * time.Second)
-	for _, port := range fsPorts {
-		if err := mds.RegisterFileServer(port); err != nil {
+	var err error
+	registrar, err = metadata.NewRegistrar(MDS_PORT)
+	if err != nil {
+		panic(err)
+	}
+	for i, port := range fsPorts {
+		if err := fileServers[i].Join(context.Background(), registrar); err != nil {
+			panic(err)
+		}
+		if err := fileServers[i].StartHeartbeats(time.Second, MDS_PORT); err != nil {
 			panic(err)
 		}
 		slog.Info("registered chunk server", "port", port)
@@ -57,14 +66,19 @@ func startAllServers(latency time.Duration) {
 }
 
 func stopAllServers() {
-	// create the metadata server
 	if mds != nil {
 		mds.DeleteAllData(context.Background(), &metadata.DeleteAllDataRequest{})
-		mds.Stop()
 	}
+	// the file servers deregister while the metadata server is still running
 	for _, s := range fileServers {
 		s.Stop()
 	}
+	if registrar != nil {
+		registrar.Close()
+	}
+	if mds != nil {
+		mds.Stop()
+	}
 }
 
 func measureLatency() {
@@ -174,7 +188,7 @@ func gatherDataProximityOptimizationData(iterations int) {
 						defer wg.Done()
 						// fetch the file from the chunk server
 						//start := time.Now()
-						bytes, err := c.GetFileFromPortWithStream(file.Port, file.FullPath)
+						bytes, err := c.GetFileFromPortWithStream(file.Port, file.ObjectId)
 						if err != nil {
 							log.Fatal(err)
 						}
@@ -192,7 +206,7 @@ func gatherDataProximityOptimizationData(iterations int) {
 						go func() {
 							// compute the count on the fileserver and reduce on the client
 							// start := time.Now()
-							count, err := c.GrepOnFileServer(file.FullPath, word, file.Port)
+							count, err := c.GrepOnFileServer(file.ObjectId, word, file.Port)
 							if err != nil {
 								log.Fatal(err)
 							}
@@ -258,6 +272,10 @@ func gatherWorkStealingOptimisationData(iterations int) {
 					traverse: traverseDirectoryWorkStealing,
 					name:     "workstealing",
 				},
+				TraversalAlgo{
+					traverse: traverseDirectoryServerSide,
+					name:     "serverside",
+				},
 			} {
 				slog.Info("iteration", "count", i)
 				c.ClearCache()
@@ -343,6 +361,19 @@ func traverseDirectoryWorkStealing(c *client.Client, dirPath string, useCache bo
 	return nil
 }
 
+// traverseDirectoryServerSide lets the metadata server walk the tree in a
+// single call. Like the other traversals it calls f for files only, symbolic
+// links have no data to read and are skipped with the directories.
+func traverseDirectoryServerSide(c *client.Client, dirPath string, useCache bool, f func(*metadata.FileInfo, *sync.WaitGroup)) error {
+	return c.WalkTree(dirPath, metadata.WalkOrder_DFS, 0, func(entry *metadata.FileInfo, wg *sync.WaitGroup) {
+		if entry.IsDir || entry.Target != "" {
+			wg.Done()
+			return
+		}
+		f(entry, wg)
+	})
+}
+
 func traverseDirectorySimple(c *client.Client, dirPath string, useCache bool, f func(*metadata.FileInfo, *sync.WaitGroup)) error {
 	// Open the directory
 	dir, err := c.OpenDir(dirPath)
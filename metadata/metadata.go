@@ -2,21 +2,25 @@ package metadata
 
 import (
 	context "context"
+	crand "crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
-	"math/rand"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"log"
 	"net"
 
 	"github.com/tevintchuinkam/dfs/files"
-	"github.com/tevintchuinkam/dfs/helpers"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func init() {
@@ -31,36 +35,99 @@ type fileServer struct {
 	port   int
 
 	// how many bytes are stored in the given
-	load   int
+	load int
+	// guards load and the fields below
 	muLoad sync.Mutex
+	// registration counts as the first heartbeat
+	lastHeartbeat time.Time
+	// state last logged, see markState
+	state FileServerState
+	// bytes as reported with the last heartbeat
+	capacity int64
+	used     int64
 }
 
 type MetaDataServer struct {
 	UnimplementedMetadataServiceServer
+	UnimplementedShardServiceServer
+	files.UnimplementedHeartbeatServiceServer
 	port        int
 	muFile      sync.Mutex
 	fileServers []*fileServer
-	muDir       sync.Mutex
-	rootDir     *fileInfo
-	// map from file to file server address
-	fileLocation map[string]*fileServer
+	// held shared by operations within a single directory and exclusively
+	// by operations on whole subtrees, see apply
+	muDir   sync.RWMutex
+	rootDir *fileInfo
+	// held while the usage of the directories is checked against their
+	// quotas and added to, see reserveQuota
+	muQuota sync.Mutex
+	// map from file to its info, which holds the file server address
+	fileLocation map[string]*fileInfo
+	muLocation   sync.RWMutex
 	grpcServer   *grpc.Server
 	listener     net.Listener
 	mu           sync.Mutex
+
+	opts options
+	// write-ahead log of all namespace mutations
+	journal    *journal
+	compacting atomic.Bool
+	muSnapshot sync.Mutex
+	// replicates the journal to the other metadata servers, nil if the
+	// server runs standalone
+	raft *raftNode
+	// closed when the server is stopped
+	stop chan struct{}
+	// wakes up the repair of under-replicated files, see repairLoop
+	repairNow chan struct{}
+	repair    repairStats
+	// changes of the namespace sent to watchers
+	events *watchHub
+	// number of snapshots of directories, while there are none nothing is
+	// shared that would need to be copied, see own
+	dirSnapshots atomic.Int64
+	// advisory locks held by clients
+	locks *lockTable
+	// entries being moved to other shards by the id of the move and the
+	// moves received from other shards, see moveOut. Guarded by muDir.
+	moves   map[string]*move
+	movedIn map[string]bool
+	// ids of the moves that are being finished
+	moving sync.Map
 }
 
-func New(port int) *MetaDataServer {
-	return &MetaDataServer{
-		port:        port,
-		muFile:      sync.Mutex{},
-		fileServers: []*fileServer{},
-		muDir:       sync.Mutex{},
-		rootDir: &fileInfo{
-			name:  ".",
-			isDir: true,
-		},
-		fileLocation: make(map[string]*fileServer),
+// New creates a MetaDataServer and restores the namespace persisted by a
+// previous instance from the data directory.
+func New(port int, opts ...Option) *MetaDataServer {
+	o := defaultOptions(port)
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := &MetaDataServer{
+		port:         port,
+		muFile:       sync.Mutex{},
+		fileServers:  []*fileServer{},
+		muDir:        sync.RWMutex{},
+		rootDir:      newRootDir(time.Now()),
+		fileLocation: make(map[string]*fileInfo),
+		opts:         o,
+		repairNow:    make(chan struct{}, 1),
+		events:       newWatchHub(),
+		moves:        make(map[string]*move),
+		movedIn:      make(map[string]bool),
+	}
+	s.locks = newLockTable(s.lockEpoch)
+	if err := s.restore(); err != nil {
+		log.Fatalf("could not restore namespace from %s: %v", o.dataDir, err)
+	}
+	if len(o.peers) > 0 {
+		r, err := newRaftNode(s, s.journal.seq)
+		if err != nil {
+			log.Fatalf("could not open raft log in %s: %v", o.dataDir, err)
+		}
+		s.raft = r
 	}
+	return s
 }
 
 // UnaryInterceptor adds artificial latency to unary RPCs
@@ -103,17 +170,30 @@ func (s *MetaDataServer) Start(latency time.Duration) {
 	s.listener = lis
 
 	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(UnaryInterceptor(latency)),
-		grpc.StreamInterceptor(StreamInterceptor(latency)),
+		grpc.ChainUnaryInterceptor(UnaryInterceptor(latency), s.checkShard, s.redirectToLeader),
+		grpc.ChainStreamInterceptor(StreamInterceptor(latency), s.checkShardStream, s.redirectStreamToLeader),
 	)
 	s.grpcServer = grpcServer
 
 	RegisterMetadataServiceServer(grpcServer, s)
+	RegisterShardServiceServer(grpcServer, s)
+	files.RegisterHeartbeatServiceServer(grpcServer, s)
+	if s.raft != nil {
+		RegisterRaftServiceServer(grpcServer, s.raft)
+	}
 	go func() {
 		if err := grpcServer.Serve(lis); err != nil {
 			log.Fatal(err)
 		}
 	}()
+	if s.raft != nil {
+		s.raft.start()
+	}
+	s.stop = make(chan struct{})
+	go s.monitorFileServers(s.stop)
+	go s.repairLoop(s.stop)
+	go s.collectLoop(s.stop)
+	go s.moveLoop(s.stop)
 }
 
 // Stop gracefully stops the MetaDataServer.
@@ -121,6 +201,18 @@ func (s *MetaDataServer) Stop() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// fails pending requests, which could otherwise keep the server from stopping
+	if s.raft != nil {
+		if err := s.raft.close(); err != nil {
+			slog.Error("could not close raft log", "err", err)
+		}
+	}
+
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+
 	if s.grpcServer != nil {
 		s.grpcServer.GracefulStop()
 		s.grpcServer = nil
@@ -130,55 +222,116 @@ func (s *MetaDataServer) Stop() {
 		s.listener.Close()
 		s.listener = nil
 	}
-}
 
-func (s *MetaDataServer) RegisterFileServer(port int) error {
-	f := helpers.NewFileServiceClient(int32(port))
-	// ping the server
-	challenge := rand.Int63()
-	resp, err := f.Ping(context.Background(), &files.PingRequest{Challenge: challenge})
-	if err != nil {
-		slog.Error(err.Error())
-		return err
-	}
-	if challenge != resp.Challenge {
-		return fmt.Errorf("file server failed challenge: %d !=  %d (expected)", resp.Challenge, challenge)
+	// wait for a snapshot in progress before closing the journal
+	s.muSnapshot.Lock()
+	defer s.muSnapshot.Unlock()
+	if err := s.journal.close(); err != nil {
+		slog.Error("could not close journal", "err", err)
 	}
+}
+
+// serverByPort returns the file server listening on the given port. Servers
+// referenced by restored files but not registered yet are added without a
+// client so that their load is tracked from the start.
+func (s *MetaDataServer) serverByPort(port int) *fileServer {
 	s.muFile.Lock()
-	srv := new(fileServer)
-	srv.client = &f
-	srv.port = port
+	defer s.muFile.Unlock()
+	for _, srv := range s.fileServers {
+		if srv.port == port {
+			return srv
+		}
+	}
+	srv := &fileServer{port: port}
 	s.fileServers = append(s.fileServers, srv)
-	s.muFile.Unlock()
+	return srv
+}
+
+// registeredServer returns the file server listening on the given port or
+// nil if it has not registered.
+func (s *MetaDataServer) registeredServer(port int) *fileServer {
+	s.muFile.Lock()
+	defer s.muFile.Unlock()
+	for _, srv := range s.fileServers {
+		if srv.port == port && srv.client != nil {
+			return srv
+		}
+	}
+	return nil
+}
 
+// clientFor returns the client of the file server listening on the given
+// port or nil if the server has not registered.
+func (s *MetaDataServer) clientFor(port int) *files.FileServiceClient {
+	s.muFile.Lock()
+	defer s.muFile.Unlock()
+	for _, srv := range s.fileServers {
+		if srv.port == port {
+			return srv.client
+		}
+	}
 	return nil
 }
 
+// resolve returns the entry at p.
+func (s *MetaDataServer) resolve(p string) (*fileInfo, error) {
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	return s.rootDir.walkTo(p)
+}
+
+// registeredServers returns the file servers that have registered.
+func (s *MetaDataServer) registeredServers() []*fileServer {
+	s.muFile.Lock()
+	defer s.muFile.Unlock()
+	var servers []*fileServer
+	for _, srv := range s.fileServers {
+		if srv.client != nil {
+			servers = append(servers, srv)
+		}
+	}
+	return servers
+}
+
 func (s *MetaDataServer) MkDir(ctx context.Context, in *MkDirRequest) (*MkDirResponse, error) {
-	dir := path.Clean(in.Name)
+	dir, err := s.follow(in.Name, false)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check if the parent directory exists
-	if !isDir(s.rootDir, path.Dir(dir)) {
+	if parent, err := s.resolve(path.Dir(dir)); err != nil || !parent.isDir {
 		slog.Error("parent directory does not exist", "dir", dir)
 		return nil, fmt.Errorf("parent directory %s does not exist", dir)
 	}
 	res := new(MkDirResponse)
 	res.Name = dir
 	// Check if the directory to be created already exists
-	if entryAlreadyExists(s.rootDir, dir) {
+	if _, err := s.resolve(dir); err == nil {
 		return res, nil
 	}
+	if err := s.checkQuota(path.Dir(dir), 0, 1); err != nil {
+		slog.Error("could not create directory", "dir", dir, "error", err)
+		return nil, err
+	}
 
-	// Lock the directory structure and create the new directory
-	s.muDir.Lock()
-	defer s.muDir.Unlock()
-	newDir := &fileInfo{
-		name:     path.Base(dir),
-		fullPath: dir,
-		isDir:    true,
+	mode := in.Mode & uint32(fs.ModePerm)
+	if mode == 0 {
+		mode = 0o755
 	}
-	err := storeFileInfo(s.rootDir, dir, newDir)
-	if err != nil {
+	if err := s.commit(ctx, &logEntry{
+		Op:    opMkDir,
+		Path:  dir,
+		Mode:  mode,
+		Owner: in.Owner,
+		Group: in.Group,
+		Time:  time.Now().UnixNano(),
+	}); err != nil {
+		// it may have been created concurrently
+		var exists EntryAlreadyExistsError
+		if errors.As(err, &exists) {
+			return res, nil
+		}
 		slog.Error("failed to store new directory info", "dir", dir, "error", err)
 		return nil, err
 	}
@@ -188,108 +341,361 @@ func (s *MetaDataServer) MkDir(ctx context.Context, in *MkDirRequest) (*MkDirRes
 
 // assumes the client will indeed write the data to the given client
 func (s *MetaDataServer) RegisterFileCreation(ctx context.Context, req *RecRequest) (*RecResponse, error) {
+	if req.FileSize < 0 {
+		return nil, fmt.Errorf("negative size provided: %d", req.FileSize)
+	}
 	// first check to see if the dir even exists where the file is supposed to be placed
-	p := path.Clean(req.Name)
+	p, err := s.follow(req.Name, false)
+	if err != nil {
+		return nil, err
+	}
 	dir := path.Dir(p)
-	if !isDir(s.rootDir, dir) {
+	if parent, err := s.resolve(dir); err != nil || !parent.isDir {
 		slog.Error("directory does not exist", "dir", dir, "original_dir", req.Name)
 		return nil, fmt.Errorf("directory %s does not exist", dir)
 	}
-	if entryAlreadyExists(s.rootDir, p) {
+	// checked again when the creation is applied, this only avoids placing
+	// files that cannot be created
+	if _, err := s.resolve(p); err == nil {
 		err := fmt.Errorf("file %s already exists", p)
 		slog.Error(err.Error())
 		return nil, err
 	}
-	// also check if a file with the given name already exists
-	if len(s.fileServers) == 0 {
+	if err := s.checkQuota(dir, req.FileSize, 1); err != nil {
+		slog.Error("could not create file", "file", p, "error", err)
+		return nil, err
+	}
+	if len(s.registeredServers()) == 0 {
 		return nil, errors.New("no file servers have been registered")
 	}
-	min := s.fileServers[0]
-	minLoad := min.load
-	for _, s := range s.fileServers {
-		s.muLoad.Lock()
-		if s.load < minLoad {
-			min = s
+	// dead servers are left out
+	candidates := s.liveServers()
+	if len(candidates) == 0 {
+		return nil, errors.New("all file servers are dead")
+	}
+	mode := req.Mode & uint32(fs.ModePerm)
+	if mode == 0 {
+		mode = 0o644
+	}
+	e := &logEntry{
+		Op:       opCreateFile,
+		Path:     p,
+		Size:     req.FileSize,
+		ObjectID: newObjectID(),
+		Mode:     mode,
+		Owner:    req.Owner,
+		Group:    req.Group,
+		Time:     time.Now().UnixNano(),
+		// the file stays hidden until the client commits it
+		Pending: true,
+	}
+	switch l := s.layoutFor(p); l.class {
+	case StorageClass_ERASURE_CODED:
+		fragments, err := s.placeFragments(p, req.FileSize, l.dataShards, l.parityShards, candidates)
+		if err != nil {
+			slog.Error("could not place file", "file", p, "error", err)
+			return nil, err
 		}
-		s.muLoad.Unlock()
-	}
-	min.muLoad.Lock()
-	min.load += int(req.FileSize)
-	min.muLoad.Unlock()
-	s.muDir.Lock()
-	if err := storeFileInfo(s.rootDir, p, &fileInfo{
-		name:     path.Base(p),
-		fullPath: p,
-		size:     req.FileSize,
-		port:     min.port,
-		isDir:    false,
-	}); err != nil {
-		log.Fatal(err)
+		e.Port = fragments[0]
+		e.Fragments = fragments
+		e.DataShards = l.dataShards
+		e.ParityShards = l.parityShards
+	case StorageClass_STRIPED:
+		fragments, err := s.placeStripes(p, req.FileSize, l.stripeCount, candidates)
+		if err != nil {
+			slog.Error("could not place file", "file", p, "error", err)
+			return nil, err
+		}
+		e.Port = fragments[0]
+		e.Fragments = fragments
+		e.StripeSize = l.stripeSize
+		e.StripeCount = len(fragments)
+	default:
+		factor := s.replicationFor(p, int(req.Replication))
+		replicas, err := s.placeReplicas(p, req.FileSize, factor, candidates)
+		if err != nil {
+			slog.Error("could not place file", "file", p, "error", err)
+			return nil, err
+		}
+		e.Port = replicas[0]
+		e.Replicas = replicas
+		e.Replication = factor
+	}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to store new file info", "file", p, "error", err)
+		return nil, err
 	}
-	s.fileLocation[p] = min
-	s.muDir.Unlock()
 	return &RecResponse{
-		Port: int32(min.port),
+		Port:         int32(e.Port),
+		ObjectId:     e.ObjectID,
+		Replicas:     toPorts32(e.Replicas),
+		DataShards:   int32(e.DataShards),
+		ParityShards: int32(e.ParityShards),
+		Fragments:    toPorts32(e.Fragments),
+		StripeSize:   e.StripeSize,
+		StripeCount:  int32(e.StripeCount),
 	}, nil
 }
 
+// newObjectID returns a random name for the data of a new file. The first
+// byte is used as a directory to keep directories on the file servers small.
+func newObjectID() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	id := hex.EncodeToString(b)
+	return path.Join(id[:2], id)
+}
+
+// Unlink removes a file from the namespace and deletes its data.
+func (s *MetaDataServer) Unlink(ctx context.Context, req *UnlinkRequest) (*UnlinkResponse, error) {
+	p, err := s.follow(req.Name, false)
+	if err != nil {
+		return nil, err
+	}
+	if req.Pending && req.ObjectId == "" {
+		return nil, status.Error(codes.InvalidArgument, "removing a pending file needs its object id")
+	}
+	e := &logEntry{Op: opUnlink, Path: p, ObjectID: req.ObjectId, Pending: req.Pending, Time: time.Now().UnixNano()}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to unlink file", "file", p, "error", err)
+		return nil, err
+	}
+	s.deleteData(e.orphaned)
+	return &UnlinkResponse{}, nil
+}
+
+// RmDir removes a directory. Unless the request is recursive the directory must be empty.
+func (s *MetaDataServer) RmDir(ctx context.Context, req *RmDirRequest) (*RmDirResponse, error) {
+	p, err := s.follow(req.Name, false)
+	if err != nil {
+		return nil, err
+	}
+	e := &logEntry{Op: opRmDir, Path: p, Recursive: req.Recursive, Time: time.Now().UnixNano()}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to remove directory", "dir", p, "error", err)
+		return nil, err
+	}
+	s.deleteData(e.orphaned)
+	return &RmDirResponse{FilesRemoved: int64(len(e.removed))}, nil
+}
+
+// Rename atomically moves a file or a directory subtree to a new path. A new
+// path owned by another shard is handed to that shard, see moveOut.
+func (s *MetaDataServer) Rename(ctx context.Context, req *RenameRequest) (*RenameResponse, error) {
+	oldName, err := s.follow(req.OldName, false)
+	if err != nil {
+		return nil, err
+	}
+	newName, err := s.followAcross(req.NewName, false)
+	if err != nil {
+		return nil, err
+	}
+	if !s.owns(newName) {
+		if err := s.moveOut(ctx, oldName, newName); err != nil {
+			slog.Error("failed to move to another shard", "old", oldName, "new", newName, "error", err)
+			return nil, err
+		}
+		return &RenameResponse{}, nil
+	}
+	if err := s.checkMoveQuota(oldName, newName); err != nil {
+		slog.Error("could not rename", "old", oldName, "new", newName, "error", err)
+		return nil, err
+	}
+	e := &logEntry{
+		Op:      opRename,
+		Path:    oldName,
+		NewPath: newName,
+		Time:    time.Now().UnixNano(),
+	}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to rename", "old", e.Path, "new", e.NewPath, "error", err)
+		return nil, err
+	}
+	s.deleteData(e.orphaned)
+	return &RenameResponse{}, nil
+}
+
+// deleteData asks the file servers to delete the data of removed files. The
+// files are already gone from the namespace, so failures are only logged.
+func (s *MetaDataServer) deleteData(removed []*fileInfo) {
+	for _, f := range removed {
+		ports, names := f.objects()
+		for i, port := range ports {
+			client := s.clientFor(port)
+			if client == nil {
+				slog.Warn("file server is not registered, leaving data behind", "file", f.fullPath, "port", port)
+				continue
+			}
+			_, err := (*client).DeleteFile(context.Background(), &files.DeleteFileRequest{
+				Name: names[i],
+			})
+			if err != nil {
+				slog.Error("could not delete file data", "file", f.fullPath, "port", port, "err", err)
+			}
+		}
+	}
+}
+
 func (s *MetaDataServer) GetLocation(ctx context.Context, req *LocRequest) (*LocResponse, error) {
 	fullPath := path.Clean(req.Name)
-	cs, ok := s.fileLocation[fullPath]
+	f, ok := s.location(fullPath)
 	if !ok {
+		// the path may lead to the file through symbolic links
+		p, err := s.follow(fullPath, true)
+		if err != nil {
+			return nil, err
+		}
+		f, ok = s.location(p)
+		if !ok && inSnapshot(p) {
+			// files of snapshots have no location of their own
+			if sf, err := s.resolve(p); err == nil && !sf.isDir && !sf.isSymlink() {
+				f, ok = sf, true
+			}
+		}
+	}
+	if !ok || f.pending {
 		return nil, fmt.Errorf("the file %s doesn't exist", fullPath)
 	}
+	// access times are not journaled, they are persisted with the next snapshot
+	f.access(time.Now())
+	if f.fragmented() {
+		return &LocResponse{
+			Port:         int32(f.port),
+			ObjectId:     f.objectID,
+			DataShards:   int32(f.dataShards),
+			ParityShards: int32(f.parityShards),
+			Fragments:    toPorts32(f.fragments),
+			Size:         f.size,
+			StripeSize:   f.stripeSize,
+			StripeCount:  int32(f.stripeCount),
+		}, nil
+	}
+	replicas := s.replicasOf(f)
 	return &LocResponse{
-		Port: int32(cs.port),
+		Port:     int32(replicas[0]),
+		ObjectId: f.objectID,
+		Replicas: toPorts32(replicas),
+		Size:     f.size,
 	}, nil
 }
 
 func (s *MetaDataServer) OpenDir(ctx context.Context, req *OpenDirRequest) (*OpenDirResponse, error) {
 	p := path.Clean(req.Name)
 	// check if this is a valid directory
-	if !isDir(s.rootDir, req.Name) {
+	d, err := s.lookupFollow(p)
+	if err != nil || !d.isDir {
 		slog.Error("dir does not exist", "name", req.Name)
 		return nil, fmt.Errorf("the directory %s doesn't exist", p)
 	}
+	d.access(time.Now())
 	return &OpenDirResponse{
 		Name: p,
 	}, nil
 }
 
 func (s *MetaDataServer) DeleteAllData(ctx context.Context, req *DeleteAllDataRequest) (*DeleteAllDataReponse, error) {
-	s.rootDir = &fileInfo{
-		name:  ".",
-		isDir: true,
+	if err := s.commit(ctx, &logEntry{Op: opReset, Time: time.Now().UnixNano()}); err != nil {
+		slog.Error("failed to delete all data", "error", err)
+		return nil, err
+	}
+	// nothing before the reset is needed to restore the namespace anymore
+	if err := s.snapshot(); err != nil {
+		slog.Error("failed to compact journal", "error", err)
 	}
 	return &DeleteAllDataReponse{}, nil
 }
 
 func (s *MetaDataServer) ReadDir(ctx context.Context, req *ReadDirRequest) (*FileInfo, error) {
-	p := path.Clean(req.Name)
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
 
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
 	info, err := getFileInfoAtIndex(s, p, int(req.Index))
 	if err != nil {
 		return nil, err
 	}
-	return convert(info), nil
+	res := convert(info)
+	res.FullPath = path.Join(p, info.name)
+	return res, nil
+}
+
+// Stat returns the attributes of a single file or directory.
+func (s *MetaDataServer) Stat(ctx context.Context, req *StatRequest) (*FileInfo, error) {
+	p := path.Clean(req.Name)
+	// keeps renames from changing the entry while it is converted
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	resolved, err := followLinks(s.rootDir, p, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.local(p, resolved); err != nil {
+		return nil, err
+	}
+	f, err := s.rootDir.walkTo(resolved)
+	if err != nil || f.pending {
+		slog.Error("entry does not exist", "name", p)
+		return nil, fmt.Errorf("the entry %s doesn't exist", p)
+	}
+	info := convert(f)
+	if inSnapshot(resolved) {
+		// the nodes of a snapshot are shared with the live tree
+		info.Name = path.Base(resolved)
+		info.FullPath = resolved
+	}
+	return info, nil
 }
 
 func convert(f *fileInfo) *FileInfo {
-	return &FileInfo{
-		Name:     f.name,
-		FullPath: f.fullPath,
-		Size:     f.size,
-		Mode:     int32(fs.ModePerm),
-		ModTime:  time.Now().String(),
-		IsDir:    f.isDir,
-		Port:     int32(f.port),
+	info := &FileInfo{
+		Name:         f.name,
+		FullPath:     f.fullPath,
+		Size:         f.size,
+		Mode:         int32(f.mode.Perm()),
+		ModTime:      f.modified().String(),
+		IsDir:        f.isDir,
+		Port:         int32(f.port),
+		ObjectId:     f.objectID,
+		CreateTime:   timestamppb.New(f.createTime),
+		ModifyTime:   timestamppb.New(f.modified()),
+		AccessTime:   timestamppb.New(f.accessed()),
+		Owner:        f.owner,
+		Group:        f.group,
+		Replication:  int32(f.replication),
+		StorageClass: f.storageClass,
+		DataShards:   int32(f.dataShards),
+		ParityShards: int32(f.parityShards),
+		StripeSize:   f.stripeSize,
+		StripeCount:  int32(f.stripeCount),
+		Checksum:     f.checksum,
+		Target:       f.target,
+		Links:        int32(f.linkCount()),
+	}
+	switch {
+	case f.isDir, f.isSymlink():
+	case f.fragmented():
+		info.Fragments = toPorts32(f.fragments)
+	default:
+		info.Replicas = toPorts32(f.servers())
 	}
+	return info
 }
 
 func (s *MetaDataServer) ReadDirAll(ctx context.Context, req *ReadDirRequest) (*ReadDirAllResponse, error) {
-	p := path.Clean(req.Name)
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
 	// check if this is a valid directory
-	if !isDir(s.rootDir, req.Name) {
+	d, err := s.rootDir.walkTo(p)
+	if err != nil || !d.isDir {
 		slog.Error("dir does not exist")
 		return nil, fmt.Errorf("the directory %s doesn't exist", p)
 	}
@@ -299,14 +705,62 @@ func (s *MetaDataServer) ReadDirAll(ctx context.Context, req *ReadDirRequest) (*
 		slog.Error(err.Error())
 		return nil, err
 	}
+	d.access(time.Now())
 	res := new(ReadDirAllResponse)
 	for _, e := range entries {
-		res.Entries = append(res.Entries, convert(e))
+		info := convert(e)
+		info.FullPath = path.Join(p, e.name)
+		res.Entries = append(res.Entries, info)
 	}
 
 	return res, nil
 }
 
+// ReadDirPlus returns a page of the entries of a directory together with
+// their attributes and a cursor to continue the listing with.
+func (s *MetaDataServer) ReadDirPlus(ctx context.Context, req *ReadDirPlusRequest) (*ReadDirPlusResponse, error) {
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	n := int(req.BatchSize)
+	if n <= 0 {
+		n = defaultReadDirBatch
+	}
+	n = min(n, maxReadDirBatch)
+
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	d, err := s.rootDir.walkTo(p)
+	if err != nil || !d.isDir {
+		slog.Error("dir does not exist", "name", p)
+		return nil, fmt.Errorf("the directory %s doesn't exist", p)
+	}
+	cursor := dirCursor{created: d.generation()}
+	if len(req.Cursor) > 0 {
+		c, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if c.created != cursor.created {
+			return nil, status.Errorf(codes.InvalidArgument, "the cursor belongs to another directory than %s", p)
+		}
+		cursor = c
+	}
+	d.rlock()
+	entries, last, more := d.entriesAfter(cursor.seq, n)
+	d.runlock()
+	d.access(time.Now())
+	cursor.seq = last
+	res := &ReadDirPlusResponse{Cursor: cursor.encode(), Eof: !more}
+	for _, e := range entries {
+		info := convert(e)
+		info.FullPath = path.Join(p, e.name)
+		res.Entries = append(res.Entries, info)
+	}
+	return res, nil
+}
+
 func (s *MetaDataServer) Ping(ctx context.Context, req *PingRequest) (*PingResponse, error) {
 	return &PingResponse{}, nil
 }
@@ -0,0 +1,705 @@
+package metadata
+
+import (
+	context "context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tevintchuinkam/dfs/helpers"
+)
+
+// restore rebuilds the namespace from the latest snapshot and the journal
+// entries written after it.
+func (s *MetaDataServer) restore() error {
+	j, err := openJournal(s.opts.dataDir, s.opts.syncWrites)
+	if err != nil {
+		return err
+	}
+	snap, err := j.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	var after uint64
+	if snap != nil {
+		s.loadSnapshot(snap)
+		after = snap.Seq
+		s.events.forget(after)
+	}
+	noop := func() error { return nil }
+	replay := func(e *logEntry) error {
+		return s.apply(e, func() error { return s.events.reserve(e, noop) })
+	}
+	if err := j.replay(after, replay); err != nil {
+		return err
+	}
+	s.journal = j
+	slog.Debug("namespace restored", "dir", s.opts.dataDir, "seq", j.seq)
+	return nil
+}
+
+func (s *MetaDataServer) loadSnapshot(snap *snapshot) {
+	s.rootDir = fromRecord(snap.Root, "")
+	for _, port := range snap.Servers {
+		s.connect(port)
+	}
+	s.moves = make(map[string]*move)
+	for _, m := range snap.Moves {
+		s.moves[m.ID] = m
+	}
+	s.movedIn = make(map[string]bool)
+	for _, id := range snap.MovedIn {
+		s.movedIn[id] = true
+	}
+	seen := make(map[string]*linkGroup)
+	// nodes shared with snapshots are counted once, and only the ones of the
+	// live tree have a location
+	visited := make(map[*fileInfo]bool)
+	var snapRoots []*fileInfo
+	var index func(f *fileInfo, live bool)
+	index = func(f *fileInfo, live bool) {
+		if f.isSymlink() || visited[f] {
+			return
+		}
+		visited[f] = true
+		if !f.isDir {
+			if !linkNames(seen, f, live) {
+				s.addLoad(f, int(f.size))
+			}
+			if live {
+				s.setLocation(f.fullPath, f)
+			}
+			return
+		}
+		for _, snap := range f.snaps {
+			snapRoots = append(snapRoots, snap.root)
+		}
+		for _, e := range f.subEntries {
+			index(e, live)
+		}
+	}
+	index(s.rootDir, true)
+	s.dirSnapshots.Store(int64(len(snapRoots)))
+	for len(snapRoots) > 0 {
+		root := snapRoots[0]
+		snapRoots = snapRoots[1:]
+		index(root, false)
+	}
+}
+
+// commit journals e and applies it to the namespace. Entries that are not
+// valid against the current namespace are rejected without being journaled.
+// In a cluster e is replicated to the other metadata servers first, which
+// is waited for until ctx is done.
+func (s *MetaDataServer) commit(ctx context.Context, e *logEntry) error {
+	if s.raft != nil {
+		return s.raft.propose(ctx, e)
+	}
+	if err := s.applyJournaled(e); err != nil {
+		return err
+	}
+	s.maybeCompact()
+	return nil
+}
+
+// applyJournaled applies e to the namespace and journals it if it is valid.
+func (s *MetaDataServer) applyJournaled(e *logEntry) error {
+	return s.apply(e, func() error {
+		return s.events.reserve(e, func() error { return s.journal.append(e) })
+	})
+}
+
+// applyReplicated applies an entry committed by raft. The raft log already
+// holds it, so it is not written to the journal a second time.
+func (s *MetaDataServer) applyReplicated(e *logEntry) error {
+	return s.apply(e, func() error {
+		return s.events.reserve(e, func() error { return s.journal.advance(e) })
+	})
+}
+
+// maybeCompact takes a snapshot in the background once enough entries have
+// been journaled since the last one.
+func (s *MetaDataServer) maybeCompact() {
+	if s.journal.pending() >= s.opts.snapshotInterval {
+		go s.compact()
+	}
+}
+
+// apply validates e and, if it is valid, calls persist before mutating the
+// namespace. Replaying the journal passes a persist func that does nothing.
+//
+// Operations that only change the entries of a single directory hold muDir
+// shared and write lock that directory, so operations in unrelated
+// directories proceed in parallel. Operations that move or remove whole
+// subtrees hold muDir exclusively. Since persist is called while the locks
+// are held, conflicting entries are journaled in the order they are applied.
+// Entries below a directory that shares nodes with a snapshot copy them
+// first, see own, and hold muDir exclusively for that.
+// Once e is applied its event is handed to the watchers.
+func (s *MetaDataServer) apply(e *logEntry, persist func() error) error {
+	persisted := false
+	err := s.applyLocked(e, func() error {
+		if err := persist(); err != nil {
+			return err
+		}
+		persisted = true
+		return nil
+	})
+	if persisted {
+		var ev *WatchEvent
+		if err == nil {
+			ev = watchEvent(e)
+		}
+		s.events.done(e.Seq, ev)
+	}
+	return err
+}
+
+func (s *MetaDataServer) applyLocked(e *logEntry, persist func() error) error {
+	exclusive := true
+	switch e.Op {
+	case opNoop:
+		return nil
+	case opMkDir, opCreateFile, opCommit, opSymlink, opUnlink, opSetXattr, opRemoveXattr, opRegister, opDeregister:
+		s.muDir.RLock()
+		// a snapshot cannot be taken while muDir is held
+		exclusive = s.sharedPath(e.Path)
+		if !exclusive && (e.Op == opSetXattr || e.Op == opRemoveXattr) {
+			// the other names of the file share its attributes
+			exclusive = s.linked(e.Path)
+		}
+		if exclusive {
+			s.muDir.RUnlock()
+		}
+	}
+	if exclusive {
+		s.muDir.Lock()
+		defer s.muDir.Unlock()
+	} else {
+		defer s.muDir.RUnlock()
+	}
+	switch e.Op {
+	case opMoveDone, opMoveAbort, opMoveForget, opReset, opRegister, opDeregister:
+	default:
+		if err := s.checkMoving(e); err != nil {
+			return err
+		}
+	}
+	switch e.Op {
+	case opMkDir:
+		return s.applyMkDir(e, persist)
+	case opCreateFile:
+		return s.applyCreateFile(e, persist)
+	case opCommit:
+		return s.applyCommit(e, persist)
+	case opUnlink:
+		return s.applyUnlink(e, persist)
+	case opRmDir:
+		return s.applyRmDir(e, persist)
+	case opRename:
+		return s.applyRename(e, persist)
+	case opReset:
+		return s.applyReset(e, persist)
+	case opRegister:
+		return s.applyRegister(e, persist)
+	case opDeregister:
+		return s.applyDeregister(e, persist)
+	case opSetRepl:
+		return s.applySetReplication(e, persist)
+	case opReplicas:
+		return s.applyReplicas(e, persist)
+	case opSetStorage:
+		return s.applySetStorageClass(e, persist)
+	case opSetQuota:
+		return s.applySetQuota(e, persist)
+	case opSymlink:
+		return s.applySymlink(e, persist)
+	case opLink:
+		return s.applyLink(e, persist)
+	case opSetXattr:
+		return s.applySetXattr(e, persist)
+	case opRemoveXattr:
+		return s.applyRemoveXattr(e, persist)
+	case opCreateSnapshot:
+		return s.applyCreateSnapshot(e, persist)
+	case opDeleteSnapshot:
+		return s.applyDeleteSnapshot(e, persist)
+	case opMoveOut:
+		return s.applyMoveOut(e, persist)
+	case opMoveIn:
+		return s.applyMoveIn(e, persist)
+	case opMoveDone:
+		return s.applyMoveDone(e, persist)
+	case opMoveAbort:
+		return s.applyMoveAbort(e, persist)
+	case opMoveForget:
+		return s.applyMoveForget(e, persist)
+	default:
+		return fmt.Errorf("unknown journal operation %q", e.Op)
+	}
+}
+
+// lockParent walks to the parent directory of p, copying the directories
+// shared with snapshots on the way, and write locks it. The caller must hold
+// muDir.
+func (s *MetaDataServer) lockParent(p string) (*fileInfo, error) {
+	if err := writable(p); err != nil {
+		return nil, err
+	}
+	dir := path.Dir(p)
+	parent, err := s.own(dir)
+	if err != nil || !parent.isDir {
+		return nil, fmt.Errorf("directory %s does not exist", dir)
+	}
+	parent.lock()
+	return parent, nil
+}
+
+func (s *MetaDataServer) applyMkDir(e *logEntry, persist func() error) error {
+	parent, err := s.lockParent(e.Path)
+	if err != nil {
+		return fmt.Errorf("parent directory %s does not exist", e.Path)
+	}
+	defer parent.unlock()
+	if parent.lookup(path.Base(e.Path)) != nil {
+		return EntryAlreadyExistsError{e.Path}
+	}
+	if err := s.reserveQuota(path.Dir(e.Path), 0, 1); err != nil {
+		return err
+	}
+	if err := persist(); err != nil {
+		s.addUsage(path.Dir(e.Path), 0, -1)
+		return err
+	}
+	t := e.time()
+	if err := parent.insert(&fileInfo{
+		name:       path.Base(e.Path),
+		fullPath:   e.Path,
+		isDir:      true,
+		mu:         new(sync.RWMutex),
+		index:      make(map[string]*fileInfo),
+		mode:       fs.ModeDir | fs.FileMode(e.Mode).Perm(),
+		modTime:    e.Time,
+		createTime: t,
+		accessTime: e.Time,
+		owner:      e.Owner,
+		group:      e.Group,
+	}); err != nil {
+		return err
+	}
+	parent.modify(t)
+	return nil
+}
+
+func (s *MetaDataServer) applyCreateFile(e *logEntry, persist func() error) error {
+	parent, err := s.lockParent(e.Path)
+	if err != nil {
+		return err
+	}
+	defer parent.unlock()
+	if parent.lookup(path.Base(e.Path)) != nil {
+		return fmt.Errorf("file %s already exists", e.Path)
+	}
+	if err := s.reserveQuota(path.Dir(e.Path), e.Size, 1); err != nil {
+		return err
+	}
+	if err := persist(); err != nil {
+		s.addUsage(path.Dir(e.Path), -e.Size, -1)
+		return err
+	}
+	objectID := e.ObjectID
+	if objectID == "" {
+		// entries journaled before object ids existed
+		objectID = e.Path
+	}
+	t := e.time()
+	f := &fileInfo{
+		name:         path.Base(e.Path),
+		fullPath:     e.Path,
+		size:         e.Size,
+		port:         e.Port,
+		replicas:     e.Replicas,
+		replication:  e.Replication,
+		dataShards:   e.DataShards,
+		parityShards: e.ParityShards,
+		stripeSize:   e.StripeSize,
+		stripeCount:  e.StripeCount,
+		fragments:    e.Fragments,
+		pending:      e.Pending,
+		isDir:        false,
+		objectID:     objectID,
+		mode:         fs.FileMode(e.Mode).Perm(),
+		modTime:      e.Time,
+		createTime:   t,
+		accessTime:   e.Time,
+		owner:        e.Owner,
+		group:        e.Group,
+	}
+	if err := parent.insert(f); err != nil {
+		return err
+	}
+	if f.pending {
+		parent.pendingEntries++
+	}
+	parent.modify(t)
+	s.addLoad(f, int(e.Size))
+	s.setLocation(e.Path, f)
+	return nil
+}
+
+func (s *MetaDataServer) applyUnlink(e *logEntry, persist func() error) error {
+	parent, err := s.lockParent(e.Path)
+	if err != nil {
+		return fmt.Errorf("the file %s doesn't exist", e.Path)
+	}
+	defer parent.unlock()
+	f := parent.lookup(path.Base(e.Path))
+	if f == nil {
+		return fmt.Errorf("the file %s doesn't exist", e.Path)
+	}
+	if f.isDir {
+		return fmt.Errorf("%s is a directory", e.Path)
+	}
+	if e.Pending && (!f.pending || f.objectID != e.ObjectID) {
+		return fmt.Errorf("the file %s was committed or replaced", e.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	parent.remove(f.name)
+	if f.pending {
+		parent.pendingEntries--
+	}
+	parent.modify(e.time())
+	s.addUsage(path.Dir(e.Path), -f.size, -1)
+	e.removed = []*fileInfo{f}
+	e.orphaned = s.forgetFiles(e.removed, release(f))
+	return nil
+}
+
+func (s *MetaDataServer) applyRmDir(e *logEntry, persist func() error) error {
+	if e.Path == "." {
+		return errors.New("the root directory cannot be removed")
+	}
+	if err := writable(e.Path); err != nil {
+		return err
+	}
+	if _, err := s.own(path.Dir(e.Path)); err != nil {
+		return fmt.Errorf("the directory %s doesn't exist", e.Path)
+	}
+	dir, err := s.rootDir.walkTo(e.Path)
+	if err != nil {
+		return fmt.Errorf("the directory %s doesn't exist", e.Path)
+	}
+	if !dir.isDir {
+		return fmt.Errorf("%s is not a directory", e.Path)
+	}
+	if len(dir.subEntries) > 0 && !e.Recursive {
+		return fmt.Errorf("the directory %s is not empty", e.Path)
+	}
+	if hasSnapshots(dir) {
+		return fmt.Errorf("the directory %s has snapshots", e.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	if _, err := removeFileInfo(s.rootDir, e.Path); err != nil {
+		return err
+	}
+	bytes, entries := dir.usage()
+	s.addUsage(path.Dir(e.Path), -bytes, -entries)
+	e.removed = filesBelow(dir)
+	e.orphaned = s.forgetFiles(e.removed, release(dir))
+	s.touch(path.Dir(e.Path), e.time())
+	return nil
+}
+
+// applyRename moves a file or a directory subtree. An existing file or empty
+// directory at the target is replaced, like rename(2) does.
+func (s *MetaDataServer) applyRename(e *logEntry, persist func() error) error {
+	if e.Path == "." || e.NewPath == "." {
+		return errors.New("the root directory cannot be renamed")
+	}
+	for _, p := range []string{e.Path, e.NewPath} {
+		if err := writable(p); err != nil {
+			return err
+		}
+	}
+	// the entry gets another name and position, so it is copied too
+	src, err := s.own(e.Path)
+	if err != nil {
+		return fmt.Errorf("the entry %s doesn't exist", e.Path)
+	}
+	if e.NewPath == e.Path {
+		return nil
+	}
+	if strings.HasPrefix(e.NewPath, e.Path+"/") {
+		return fmt.Errorf("cannot move %s into itself", e.Path)
+	}
+	if src.pending {
+		return fmt.Errorf("the file %s is not committed yet", e.Path)
+	}
+	if d, err := s.own(path.Dir(e.NewPath)); err != nil || !d.isDir {
+		return fmt.Errorf("directory %s does not exist", path.Dir(e.NewPath))
+	}
+	dst, err := s.rootDir.walkTo(e.NewPath)
+	replace := err == nil
+	if replace {
+		if err := replaceable(e.Path, src.isDir, e.NewPath, dst); err != nil {
+			return err
+		}
+	}
+	// muDir is held exclusively, so the usage cannot change until the entry
+	// is moved
+	if err := s.moveQuota(e.Path, e.NewPath); err != nil {
+		return err
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	if replace {
+		if _, err := removeFileInfo(s.rootDir, e.NewPath); err != nil {
+			return err
+		}
+		bytes, entries := dst.usage()
+		s.addUsage(path.Dir(e.NewPath), -bytes, -entries)
+		e.removed = filesBelow(dst)
+		e.orphaned = s.forgetFiles(e.removed, release(dst))
+	}
+	if _, err := removeFileInfo(s.rootDir, e.Path); err != nil {
+		return err
+	}
+	bytes, entries := src.usage()
+	s.addUsage(path.Dir(e.Path), -bytes, -entries)
+	src.name = path.Base(e.NewPath)
+	s.relocate(src, e.NewPath)
+	if err := storeFileInfo(s.rootDir, e.NewPath, src); err != nil {
+		return err
+	}
+	s.addUsage(path.Dir(e.NewPath), bytes, entries)
+	s.touch(path.Dir(e.Path), e.time())
+	s.touch(path.Dir(e.NewPath), e.time())
+	return nil
+}
+
+// replaceable fails if the entry dst at newPath cannot be replaced by the
+// one at p, a directory if isDir is set.
+func replaceable(p string, isDir bool, newPath string, dst *fileInfo) error {
+	switch {
+	case isDir && !dst.isDir:
+		return fmt.Errorf("cannot overwrite non-directory %s with directory %s", newPath, p)
+	case !isDir && dst.isDir:
+		return fmt.Errorf("cannot overwrite directory %s with non-directory %s", newPath, p)
+	case dst.isDir && len(dst.subEntries) > 0:
+		return fmt.Errorf("the directory %s is not empty", newPath)
+	case dst.pending:
+		return fmt.Errorf("the file %s is not committed yet", newPath)
+	case len(dst.snaps) > 0:
+		return fmt.Errorf("the directory %s has snapshots", newPath)
+	}
+	return nil
+}
+
+// touch updates the modification time of a directory whose entries changed.
+func (s *MetaDataServer) touch(dir string, t time.Time) {
+	d, err := s.rootDir.walkTo(dir)
+	if err != nil {
+		return
+	}
+	d.modify(t)
+}
+
+// relocate updates the full path of every entry in the tree rooted at f
+// after it has been moved to p. Entries shared with a snapshot are copied
+// first, so the snapshot keeps seeing them where they were.
+func (s *MetaDataServer) relocate(f *fileInfo, p string) {
+	if !f.isDir && !f.isSymlink() {
+		s.deleteLocation(f.fullPath)
+		s.setLocation(p, f)
+	}
+	f.fullPath = p
+	for i := range f.subEntries {
+		e := f.subEntries[i]
+		if e.shared > 0 {
+			e = s.unshare(f, e)
+		}
+		s.relocate(e, path.Join(p, e.name))
+	}
+}
+
+// forgetFiles drops the location of files removed from the tree and
+// releases the space taken on their file servers by the ones that are gone,
+// i.e. not kept by a snapshot either, see release. It returns the files
+// whose data is not used by another name anymore.
+func (s *MetaDataServer) forgetFiles(removed, gone []*fileInfo) []*fileInfo {
+	for _, f := range removed {
+		if !f.isSymlink() {
+			s.deleteLocation(f.fullPath)
+			f.unlinkName()
+		}
+	}
+	var orphaned []*fileInfo
+	for _, f := range gone {
+		if f.isSymlink() {
+			continue
+		}
+		if s.dropHolder(f) > 0 {
+			continue
+		}
+		s.addLoad(f, -int(f.size))
+		orphaned = append(orphaned, f)
+	}
+	return orphaned
+}
+
+func (s *MetaDataServer) applyReset(e *logEntry, persist func() error) error {
+	if err := persist(); err != nil {
+		return err
+	}
+	s.rootDir = newRootDir(e.time())
+	s.dirSnapshots.Store(0)
+	s.moves = make(map[string]*move)
+	s.movedIn = make(map[string]bool)
+	s.forgetAll()
+	return nil
+}
+
+// forgetAll drops the locations of all files and the load they put on the
+// file servers. muDir must be held exclusively.
+func (s *MetaDataServer) forgetAll() {
+	s.muLocation.Lock()
+	s.fileLocation = make(map[string]*fileInfo)
+	s.muLocation.Unlock()
+	s.muFile.Lock()
+	for _, srv := range s.fileServers {
+		srv.muLoad.Lock()
+		srv.load = 0
+		srv.muLoad.Unlock()
+	}
+	s.muFile.Unlock()
+}
+
+func (s *MetaDataServer) applyRegister(e *logEntry, persist func() error) error {
+	if err := persist(); err != nil {
+		return err
+	}
+	// registering again only changes the capacity
+	if s.clientFor(e.Port) == nil {
+		s.connect(e.Port)
+	}
+	if e.Capacity > 0 {
+		srv := s.serverByPort(e.Port)
+		srv.muLoad.Lock()
+		srv.capacity = e.Capacity
+		srv.muLoad.Unlock()
+	}
+	return nil
+}
+
+// applyDeregister drops the client of a file server so that no new files are
+// placed on it. Files already stored on it keep their location.
+func (s *MetaDataServer) applyDeregister(e *logEntry, persist func() error) error {
+	if err := persist(); err != nil {
+		return err
+	}
+	srv := s.serverByPort(e.Port)
+	s.muFile.Lock()
+	srv.client = nil
+	s.muFile.Unlock()
+	return nil
+}
+
+// connect sets up the client of a registered file server.
+func (s *MetaDataServer) connect(port int) {
+	f := helpers.NewFileServiceClient(int32(port))
+	srv := s.serverByPort(port)
+	s.muFile.Lock()
+	srv.client = &f
+	s.muFile.Unlock()
+	srv.muLoad.Lock()
+	srv.lastHeartbeat = time.Now()
+	srv.muLoad.Unlock()
+}
+
+// installSnapshot replaces the namespace with a snapshot taken by the leader.
+func (s *MetaDataServer) installSnapshot(snap *snapshot) error {
+	s.muSnapshot.Lock()
+	defer s.muSnapshot.Unlock()
+	s.muDir.Lock()
+	defer s.muDir.Unlock()
+	if err := s.journal.install(snap); err != nil {
+		return err
+	}
+	s.forgetAll()
+	s.loadSnapshot(snap)
+	s.events.forget(snap.Seq)
+	return nil
+}
+
+// compact takes a snapshot unless one is already being taken.
+func (s *MetaDataServer) compact() {
+	if !s.compacting.CompareAndSwap(false, true) {
+		return
+	}
+	defer s.compacting.Store(false)
+	if err := s.snapshot(); err != nil {
+		slog.Error("failed to compact journal", "error", err)
+	}
+}
+
+// snapshot writes the whole namespace to disk and drops the journal
+// segments it supersedes.
+func (s *MetaDataServer) snapshot() error {
+	s.muSnapshot.Lock()
+	defer s.muSnapshot.Unlock()
+	// holding muDir keeps mutations out while the cut is taken
+	s.muDir.Lock()
+	seq, err := s.journal.rotate()
+	if err != nil {
+		s.muDir.Unlock()
+		return err
+	}
+	snap := &snapshot{Seq: seq, Root: toRecord(s.rootDir)}
+	for _, srv := range s.registeredServers() {
+		snap.Servers = append(snap.Servers, srv.port)
+	}
+	for _, m := range s.moves {
+		snap.Moves = append(snap.Moves, m)
+	}
+	for id := range s.movedIn {
+		snap.MovedIn = append(snap.MovedIn, id)
+	}
+	s.muDir.Unlock()
+	if err := s.journal.writeSnapshot(snap); err != nil {
+		return err
+	}
+	if s.raft != nil {
+		return s.raft.compact(seq)
+	}
+	return nil
+}
+
+func (s *MetaDataServer) setLocation(p string, f *fileInfo) {
+	s.muLocation.Lock()
+	defer s.muLocation.Unlock()
+	s.fileLocation[p] = f
+}
+
+func (s *MetaDataServer) deleteLocation(p string) {
+	s.muLocation.Lock()
+	defer s.muLocation.Unlock()
+	delete(s.fileLocation, p)
+}
+
+// location returns the file stored at p.
+func (s *MetaDataServer) location(p string) (*fileInfo, bool) {
+	s.muLocation.RLock()
+	defer s.muLocation.RUnlock()
+	f, ok := s.fileLocation[p]
+	return f, ok
+}
@@ -0,0 +1,167 @@
+package metadata
+
+import (
+	context "context"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+)
+
+// CommitFile marks a file registered with RegisterFileCreation as complete
+// once its data has been written. Until then the file is hidden from
+// listings and lookups, and it is removed if it is not committed in time,
+// see WithPendingTimeout.
+func (s *MetaDataServer) CommitFile(ctx context.Context, req *CommitFileRequest) (*CommitFileResponse, error) {
+	p, err := s.follow(req.Name, false)
+	if err != nil {
+		return nil, err
+	}
+	if req.Size < 0 {
+		return nil, fmt.Errorf("negative size provided: %d", req.Size)
+	}
+	// the quotas were checked for the size the file was registered with,
+	// data written beyond it has to fit as well
+	if f, err := s.resolve(p); err == nil && f.pending && f.objectID == req.ObjectId && req.Size > f.size {
+		if err := s.checkQuota(path.Dir(p), req.Size-f.size, 0); err != nil {
+			slog.Error("could not commit file", "file", p, "error", err)
+			return nil, err
+		}
+	}
+	e := &logEntry{
+		Op:       opCommit,
+		Path:     p,
+		ObjectID: req.ObjectId,
+		Size:     req.Size,
+		Checksum: req.Checksum,
+		Time:     time.Now().UnixNano(),
+	}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to commit file", "file", p, "error", err)
+		return nil, err
+	}
+	return &CommitFileResponse{}, nil
+}
+
+// applyCommit replaces the pending file with a committed copy instead of
+// changing it, since its attributes are read without holding its directory.
+func (s *MetaDataServer) applyCommit(e *logEntry, persist func() error) error {
+	parent, err := s.lockParent(e.Path)
+	if err != nil {
+		return fmt.Errorf("the file %s doesn't exist", e.Path)
+	}
+	defer parent.unlock()
+	f := parent.lookup(path.Base(e.Path))
+	if f == nil || f.isDir || f.objectID != e.ObjectID {
+		return fmt.Errorf("the file %s was removed or replaced before it was committed", e.Path)
+	}
+	if !f.pending {
+		return fmt.Errorf("the file %s is already committed", e.Path)
+	}
+	if err := s.reserveQuota(path.Dir(e.Path), e.Size-f.size, 0); err != nil {
+		return err
+	}
+	if err := persist(); err != nil {
+		s.addUsage(path.Dir(e.Path), f.size-e.Size, 0)
+		return err
+	}
+	c := f.committed(e)
+	if f.shared > 0 {
+		// the pending file stays in the snapshots, which keep its data
+		f.shared--
+		s.shareData(f, c)
+	}
+	parent.replace(c)
+	parent.pendingEntries--
+	parent.modify(e.time())
+	s.setLocation(e.Path, c)
+	s.addLoad(c, int(c.size-f.size))
+	return nil
+}
+
+// committed returns a copy of the pending file f with the size and checksum
+// of its written data.
+func (f *fileInfo) committed(e *logEntry) *fileInfo {
+	c := f.clone()
+	c.size = e.Size
+	c.modTime = e.Time
+	c.checksum = e.Checksum
+	c.pending = false
+	return c
+}
+
+// clone returns a copy of the file f, which shares its extended attributes.
+func (f *fileInfo) clone() *fileInfo {
+	return &fileInfo{
+		name:         f.name,
+		size:         f.size,
+		mode:         f.mode,
+		modTime:      f.modified().UnixNano(),
+		seq:          f.seq,
+		prev:         f.prev,
+		sys:          f.sys,
+		port:         f.port,
+		replicas:     f.replicas,
+		replication:  f.replication,
+		dataShards:   f.dataShards,
+		parityShards: f.parityShards,
+		stripeSize:   f.stripeSize,
+		stripeCount:  f.stripeCount,
+		fragments:    f.fragments,
+		pending:      f.pending,
+		checksum:     f.checksum,
+		target:       f.target,
+		links:        f.links,
+		xattrs:       f.xattrs,
+		fullPath:     f.fullPath,
+		objectID:     f.objectID,
+		createTime:   f.createTime,
+		accessTime:   f.accessed().UnixNano(),
+		owner:        f.owner,
+		group:        f.group,
+	}
+}
+
+// collectLoop removes files that were never committed, e.g. because their
+// client crashed while writing them. Only the leader collects, the followers
+// learn about the removals from its log.
+func (s *MetaDataServer) collectLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(max(s.opts.pendingTimeout/2, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if s.raft != nil {
+			if _, ok := s.raft.isLeader(); !ok {
+				continue
+			}
+		}
+		s.collectPending(time.Now().Add(-s.opts.pendingTimeout))
+	}
+}
+
+// collectPending removes the files created before deadline that are still
+// pending and deletes whatever data was written for them.
+func (s *MetaDataServer) collectPending(deadline time.Time) {
+	orphans := make(map[string]string)
+	s.muLocation.RLock()
+	for p, f := range s.fileLocation {
+		if f.pending && f.createTime.Before(deadline) {
+			orphans[p] = f.objectID
+		}
+	}
+	s.muLocation.RUnlock()
+	for p, objectID := range orphans {
+		// only removes the file if it was not committed in the meantime
+		e := &logEntry{Op: opUnlink, Path: p, ObjectID: objectID, Pending: true, Time: time.Now().UnixNano()}
+		if err := s.commit(context.Background(), e); err != nil {
+			slog.Debug("did not remove pending file", "file", p, "err", err)
+			continue
+		}
+		slog.Info("removed file that was never committed", "file", p)
+		s.deleteData(e.orphaned)
+	}
+}
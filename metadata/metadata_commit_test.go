@@ -0,0 +1,116 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommitFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	rec, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "f", FileSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hidden := func(name string) {
+		t.Helper()
+		if _, err := s.Stat(ctx, &StatRequest{Name: name}); err == nil {
+			t.Errorf("expected pending file %s to be hidden from Stat", name)
+		}
+		if _, err := s.GetLocation(ctx, &LocRequest{Name: name}); err == nil {
+			t.Errorf("expected pending file %s to be hidden from GetLocation", name)
+		}
+		if all, err := s.ReadDirAll(ctx, &ReadDirRequest{Name: "."}); err != nil || len(all.Entries) != 0 {
+			t.Errorf("expected no entries, got %v, %v", all, err)
+		}
+		if _, err := s.ReadDir(ctx, &ReadDirRequest{Name: ".", Index: 0}); !errors.As(err, new(EndOfDirectoryError)) {
+			t.Errorf("expected the end of the directory, got %v", err)
+		}
+	}
+	hidden("f")
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "f", NewName: "g"}); err == nil {
+		t.Error("expected renaming a pending file to fail")
+	}
+	if _, err := s.CommitFile(ctx, &CommitFileRequest{Name: "f", ObjectId: "other", Size: 8}); err == nil {
+		t.Error("expected a commit with the wrong object id to fail")
+	}
+
+	// a restart keeps the file pending
+	s.Stop()
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	hidden("f")
+
+	if _, err := s.CommitFile(ctx, &CommitFileRequest{Name: "f", ObjectId: rec.ObjectId, Size: 8, Checksum: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CommitFile(ctx, &CommitFileRequest{Name: "f", ObjectId: rec.ObjectId, Size: 8}); err == nil {
+		t.Error("expected a second commit to fail")
+	}
+	info, err := s.Stat(ctx, &StatRequest{Name: "f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 8 || info.Checksum != "abc" {
+		t.Errorf("expected the committed size and checksum, got %d and %q", info.Size, info.Checksum)
+	}
+	if res, err := s.ReadDir(ctx, &ReadDirRequest{Name: ".", Index: 0}); err != nil || res.Name != "f" {
+		t.Errorf("expected f to be listed, got %v, %v", res, err)
+	}
+	if load := s.serverByPort(1).load; load != 8 {
+		t.Errorf("expected the load to follow the committed size, got %d", load)
+	}
+
+	// files that are never committed are removed together with their data
+	fake := (*s.serverByPort(1).client).(*fakeFileServer)
+	orphan, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "orphan", FileSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := s.ReadDir(ctx, &ReadDirRequest{Name: ".", Index: 1}); !errors.As(err, new(EndOfDirectoryError)) {
+		t.Errorf("expected the pending file to be skipped, got %v, %v", res, err)
+	}
+	s.collectPending(time.Now().Add(-time.Hour))
+	if _, err := s.CommitFile(ctx, &CommitFileRequest{Name: "orphan", ObjectId: orphan.ObjectId}); err != nil {
+		t.Errorf("expected a recent file to be kept, got %v", err)
+	}
+	orphan, err = s.RegisterFileCreation(ctx, &RecRequest{Name: "late", FileSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.collectPending(time.Now().Add(time.Hour))
+	if len(fake.deleted) != 1 || fake.deleted[0] != orphan.ObjectId {
+		t.Errorf("expected the data of the orphan to be deleted, got %v", fake.deleted)
+	}
+	if _, err := s.CommitFile(ctx, &CommitFileRequest{Name: "late", ObjectId: orphan.ObjectId}); err == nil {
+		t.Error("expected committing a removed file to fail")
+	}
+	if _, err := s.Stat(ctx, &StatRequest{Name: "f"}); err != nil {
+		t.Errorf("expected committed files to be kept, got %v", err)
+	}
+
+	// an aborted creation only removes the file while it is pending
+	aborted, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "aborted", FileSize: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []*UnlinkRequest{
+		{Name: "f", Pending: true, ObjectId: rec.ObjectId},
+		{Name: "aborted", Pending: true, ObjectId: "other"},
+		{Name: "aborted", Pending: true},
+	} {
+		if _, err := s.Unlink(ctx, req); err == nil {
+			t.Errorf("expected %v to be rejected", req)
+		}
+	}
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "aborted", Pending: true, ObjectId: aborted.ObjectId}); err != nil {
+		t.Errorf("expected the pending file to be removed, got %v", err)
+	}
+	if _, err := s.Stat(ctx, &StatRequest{Name: "f"}); err != nil {
+		t.Errorf("expected the committed file to be kept, got %v", err)
+	}
+}
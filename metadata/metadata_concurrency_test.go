@@ -0,0 +1,111 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentClients runs namespace operations from many goroutines and
+// checks that the result is the same as if they had run one after another.
+// Run it with -race.
+func TestConcurrentClients(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir, WithSnapshotInterval(50))
+
+	const (
+		workers = 8
+		files   = 50
+	)
+	for _, d := range []string{"shared", "mv-a"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sharedCreated := 0
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := fmt.Sprintf("w-%d", w)
+			if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+				t.Error(err)
+				return
+			}
+			for i := range files {
+				if _, err := createFile(ctx, s, &RecRequest{Name: fmt.Sprintf("%s/f-%d", d, i), FileSize: 1}); err != nil {
+					t.Error(err)
+				}
+				// every worker tries to create the same files, only one may succeed
+				if _, err := createFile(ctx, s, &RecRequest{Name: fmt.Sprintf("shared/f-%d", i), FileSize: 1}); err == nil {
+					mu.Lock()
+					sharedCreated++
+					mu.Unlock()
+				}
+				if _, err := s.ReadDirAll(ctx, &ReadDirRequest{Name: "shared"}); err != nil {
+					t.Error(err)
+				}
+				s.ReadDir(ctx, &ReadDirRequest{Name: d, Index: int32(i)})
+				s.Stat(ctx, &StatRequest{Name: "shared"})
+				s.GetLocation(ctx, &LocRequest{Name: fmt.Sprintf("shared/f-%d", i)})
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// moves a subtree back and forth while the other directories change
+		for i := range files {
+			from, to := "mv-a", "mv-b"
+			if i%2 == 1 {
+				from, to = to, from
+			}
+			if _, err := s.Rename(ctx, &RenameRequest{OldName: from, NewName: to}); err != nil {
+				t.Error(err)
+			}
+			s.OpenDir(ctx, &OpenDirRequest{Name: to})
+		}
+	}()
+	wg.Wait()
+
+	if sharedCreated != files {
+		t.Errorf("expected %d files to be created in the shared directory, got %d", files, sharedCreated)
+	}
+	check := func(s *MetaDataServer) {
+		t.Helper()
+		res, err := s.ReadDirAll(ctx, &ReadDirRequest{Name: "shared"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Entries) != files {
+			t.Errorf("expected %d entries in the shared directory, got %d", files, len(res.Entries))
+		}
+		for w := range workers {
+			res, err := s.ReadDirAll(ctx, &ReadDirRequest{Name: fmt.Sprintf("w-%d", w)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Entries) != files {
+				t.Errorf("expected %d entries in w-%d, got %d", files, w, len(res.Entries))
+			}
+		}
+		if !isDir(s.rootDir, "mv-a") {
+			t.Error("mv-a does not exist after an even number of renames")
+		}
+		if load := s.serverByPort(1).load; load != (workers+1)*files {
+			t.Errorf("expected a load of %d, got %d", (workers+1)*files, load)
+		}
+	}
+	check(s)
+	s.Stop()
+
+	// the journal has to replay to the same state
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	check(s)
+}
@@ -1,27 +1,150 @@
 package metadata
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"os"
 	"path"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type fileInfo struct {
-	name       string
-	size       int64
-	mode       fs.FileMode
-	modTime    time.Time
-	isDir      bool
+	name string
+	size int64
+	mode fs.FileMode
+	// unix nanoseconds, accessed atomically because the modification time of
+	// a directory changes while its parent is listed
+	modTime int64
+	isDir   bool
+	// guards the entries of a directory. It is only taken while holding
+	// MetaDataServer.muDir, see MetaDataServer.apply.
+	mu *sync.RWMutex
+	// entries of a directory ordered by seq, i.e. in the order they were added
 	subEntries []*fileInfo
-	prev       *fileInfo
-	sys        any
-	port       int
-	fullPath   string
+	// entries of a directory by name, kept in step with subEntries
+	index map[string]*fileInfo
+	// position of the entry in its parent's subEntries
+	seq uint64
+	// seq given to the next entry added to a directory
+	nextSeq uint64
+	prev    *fileInfo
+	sys     any
+	port    int
+	// ports of the file servers holding copies of the data, the first one
+	// is port. Files created before replication existed only have port.
+	replicas []int
+	// replication factor of new files below a directory, 0 to inherit it,
+	// or the number of copies to keep of a file, 0 for the server's default
+	replication int
+	// storage class of new files below a directory
+	storageClass StorageClass
+	// erasure coding of new files below a directory or of the data of a
+	// file, 0 data shards if it is not erasure coded
+	dataShards   int
+	parityShards int
+	// striping of new files below a directory or of the data of a file
+	stripeSize  int64
+	stripeCount int
+	// ports of the file servers holding the fragments of an erasure coded or
+	// striped file, fragment i is on fragments[i]
+	fragments []int
+	// a file that was registered but whose data has not been committed yet.
+	// Pending files are hidden from everything but Unlink.
+	pending bool
+	// number of pending files in a directory
+	pendingEntries int
+	// limits on the bytes of file data and the number of entries below a
+	// directory, 0 for no limit
+	quotaBytes   int64
+	quotaEntries int64
+	// bytes and entries below a directory, accessed atomically because
+	// creations in different subdirectories update them concurrently
+	usedBytes   int64
+	usedEntries int64
+	// hex encoded SHA-256 of the data of a committed file
+	checksum string
+	// path a symbolic link points to
+	target string
+	// names of a file with hard links, nil if it has a single name
+	links *linkGroup
+	// extended attributes, guarded by the lock of the parent directory. The
+	// names of a file with hard links share them, so they are only changed
+	// while holding MetaDataServer.muDir exclusively.
+	xattrs   *xattrSet
+	fullPath string
+	// name of the file's data on its file server. It stays the same when
+	// the file is renamed.
+	objectID   string
+	createTime time.Time
+	// unix nanoseconds, accessed atomically because reads update it
+	accessTime int64
+	owner      string
+	group      string
+	// number of trees besides the first that hold the node since a
+	// snapshot was taken above it, see MetaDataServer.own. Only changed
+	// while holding MetaDataServer.muDir exclusively.
+	shared int32
+	// snapshots of a directory by name
+	snaps map[string]*dirSnapshot
+}
+
+// newRootDir returns an empty root directory created at t.
+func newRootDir(t time.Time) *fileInfo {
+	return &fileInfo{
+		name:       ".",
+		isDir:      true,
+		mu:         new(sync.RWMutex),
+		index:      make(map[string]*fileInfo),
+		mode:       fs.ModeDir | 0o755,
+		modTime:    t.UnixNano(),
+		createTime: t,
+		accessTime: t.UnixNano(),
+	}
+}
+
+// modify records that the file or the entries of the directory changed at t.
+func (fi *fileInfo) modify(t time.Time) {
+	atomic.StoreInt64(&fi.modTime, t.UnixNano())
+}
+
+// modified returns the time the file or the entries of the directory last changed.
+func (fi *fileInfo) modified() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&fi.modTime))
+}
+
+// rlock read locks the entries of a directory.
+func (fi *fileInfo) rlock() {
+	fi.mu.RLock()
+}
+
+func (fi *fileInfo) runlock() {
+	fi.mu.RUnlock()
+}
+
+func (fi *fileInfo) lock() {
+	fi.mu.Lock()
+}
+
+func (fi *fileInfo) unlock() {
+	fi.mu.Unlock()
+}
+
+// access records that the file or directory was read at t.
+func (fi *fileInfo) access(t time.Time) {
+	atomic.StoreInt64(&fi.accessTime, t.UnixNano())
+}
+
+// accessed returns the time the file or directory was last read.
+func (fi *fileInfo) accessed() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&fi.accessTime))
 }
 
 // GenerateFileTree generates the file tree starting from the given root and returns it as a string.
@@ -57,25 +180,145 @@ func (d *fileInfo) walkTo(p string) (*fileInfo, error) {
 	parts := strings.Split(p, string(os.PathSeparator))
 	currentDir := d
 
-	for _, part := range parts {
+	for i := 0; i < len(parts); i++ {
+		part := parts[i]
 		if part == "" {
 			continue
 		}
-		found := false
-		for _, subDir := range currentDir.subEntries {
-			if subDir.name == part {
-				currentDir = subDir
-				found = true
-				break
+		// each directory is only locked while its entries are searched.
+		// The directories on the path cannot be removed in the meantime since
+		// that requires MetaDataServer.muDir to be held exclusively.
+		currentDir.rlock()
+		var next *fileInfo
+		if part == snapDir && i+1 < len(parts) {
+			// .snap/name leads to the root of a snapshot
+			i++
+			if snap, ok := currentDir.snaps[parts[i]]; ok {
+				next = snap.root
 			}
+		} else {
+			next = currentDir.lookup(part)
 		}
-		if !found {
+		currentDir.runlock()
+		if next == nil {
 			return nil, DirNonExistantError{part}
 		}
+		currentDir = next
 	}
 	return currentDir, nil
 }
 
+// lookup returns the entry of the directory with the given name or nil.
+func (d *fileInfo) lookup(name string) *fileInfo {
+	return d.index[name]
+}
+
+// insert adds fi to the end of the directory.
+func (d *fileInfo) insert(fi *fileInfo) error {
+	if _, ok := d.index[fi.name]; ok {
+		return EntryAlreadyExistsError{fi.name}
+	}
+	d.nextSeq++
+	fi.seq = d.nextSeq
+	d.index[fi.name] = fi
+	d.subEntries = append(d.subEntries, fi)
+	return nil
+}
+
+// remove removes the entry with the given name from the directory and returns it.
+func (d *fileInfo) remove(name string) *fileInfo {
+	fi, ok := d.index[name]
+	if !ok {
+		return nil
+	}
+	delete(d.index, name)
+	// subEntries is ordered by seq, entries restored from a snapshot taken
+	// before entries were numbered may share one though
+	i := sort.Search(len(d.subEntries), func(i int) bool {
+		return d.subEntries[i].seq >= fi.seq
+	})
+	if i == len(d.subEntries) || d.subEntries[i] != fi {
+		i = slices.Index(d.subEntries, fi)
+	}
+	d.subEntries = slices.Delete(d.subEntries, i, i+1)
+	return fi
+}
+
+// entriesAfter returns up to n entries of the directory that were added
+// after the entry with the given seq, leaving out pending files. It also
+// returns the seq of the last entry looked at and whether any are left.
+// The caller must hold the directory's lock.
+func (d *fileInfo) entriesAfter(seq uint64, n int) (entries []*fileInfo, last uint64, more bool) {
+	last = seq
+	i := sort.Search(len(d.subEntries), func(i int) bool {
+		return d.subEntries[i].seq > seq
+	})
+	for ; i < len(d.subEntries) && len(entries) < n; i++ {
+		e := d.subEntries[i]
+		last = e.seq
+		if !e.pending {
+			entries = append(entries, e)
+		}
+	}
+	return entries, last, i < len(d.subEntries)
+}
+
+// number of entries returned by ReadDirPlus when no batch size is asked for,
+// and at most
+const (
+	defaultReadDirBatch = 256
+	maxReadDirBatch     = 4096
+)
+
+// generation tells apart directories that were removed and created again,
+// whose entries are numbered from scratch. The root is never removed and
+// only snapshots keep its creation time.
+func (d *fileInfo) generation() int64 {
+	if d.fullPath == "" {
+		return 0
+	}
+	return d.createTime.UnixNano()
+}
+
+// dirCursor is where a listing of a directory continues: after the entry
+// with seq in the given generation of the directory.
+type dirCursor struct {
+	created int64
+	seq     uint64
+}
+
+func (c dirCursor) encode() []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(c.created))
+	return binary.BigEndian.AppendUint64(b, c.seq)
+}
+
+func decodeCursor(b []byte) (dirCursor, error) {
+	if len(b) != 16 {
+		return dirCursor{}, fmt.Errorf("invalid cursor of %d bytes", len(b))
+	}
+	return dirCursor{
+		created: int64(binary.BigEndian.Uint64(b)),
+		seq:     binary.BigEndian.Uint64(b[8:]),
+	}, nil
+}
+
+// replace puts fi in place of the entry with the same name, keeping its
+// position in the directory.
+func (d *fileInfo) replace(fi *fileInfo) {
+	old, ok := d.index[fi.name]
+	if !ok {
+		return
+	}
+	d.index[fi.name] = fi
+	i := sort.Search(len(d.subEntries), func(i int) bool {
+		return d.subEntries[i].seq >= old.seq
+	})
+	if i == len(d.subEntries) || d.subEntries[i] != old {
+		i = slices.Index(d.subEntries, old)
+	}
+	d.subEntries[i] = fi
+}
+
 type EndOfDirectoryError struct {
 }
 
@@ -93,11 +336,25 @@ func getFileInfoAtIndex(m *MetaDataServer, dirName string, index int) (*fileInfo
 		return nil, fmt.Errorf("negative index provided: %d", index)
 	}
 
-	if index >= len(parent.subEntries) {
-		return nil, EndOfDirectoryError{}
+	parent.rlock()
+	defer parent.runlock()
+	if parent.pendingEntries == 0 {
+		if index >= len(parent.subEntries) {
+			return nil, EndOfDirectoryError{}
+		}
+		return parent.subEntries[index], nil
 	}
-
-	return parent.subEntries[index], nil
+	// pending files are skipped, so the index has to be counted
+	for _, e := range parent.subEntries {
+		if e.pending {
+			continue
+		}
+		if index == 0 {
+			return e, nil
+		}
+		index--
+	}
+	return nil, EndOfDirectoryError{}
 }
 
 type EntryAlreadyExistsError struct {
@@ -116,16 +373,33 @@ func storeFileInfo(root *fileInfo, dirPath string, fi *fileInfo) error {
 		return err
 	}
 
-	// Check if an entry with the same name already exists
-	for _, entry := range parentDir.subEntries {
-		if entry.name == fi.name {
-			return EntryAlreadyExistsError{entry.name}
-		}
+	// fails if an entry with the same name already exists
+	return parentDir.insert(fi)
+}
+
+// removeFileInfo detaches the entry at the given path from its parent directory.
+func removeFileInfo(root *fileInfo, p string) (*fileInfo, error) {
+	parentDir, err := root.walkTo(path.Dir(p))
+	if err != nil {
+		return nil, err
 	}
+	entry := parentDir.remove(path.Base(p))
+	if entry == nil {
+		return nil, fmt.Errorf("the entry %s does not exist", p)
+	}
+	return entry, nil
+}
 
-	// Append the new fileInfo to the parent's subEntries
-	parentDir.subEntries = append(parentDir.subEntries, fi)
-	return nil
+// filesBelow returns all regular files in the tree rooted at f.
+func filesBelow(f *fileInfo) []*fileInfo {
+	if !f.isDir {
+		return []*fileInfo{f}
+	}
+	var res []*fileInfo
+	for _, e := range f.subEntries {
+		res = append(res, filesBelow(e)...)
+	}
+	return res
 }
 
 // entryAlreadyExists checks if a file or directory with the specified path already exists.
@@ -135,15 +409,9 @@ func entryAlreadyExists(root *fileInfo, p string) bool {
 	if err != nil {
 		return false
 	}
-	fileName := path.Base(p)
-
-	// Check if an entry with the same name already exists
-	for _, entry := range parentDir.subEntries {
-		if entry.name == fileName {
-			return true
-		}
-	}
-	return false
+	parentDir.rlock()
+	defer parentDir.runlock()
+	return parentDir.lookup(path.Base(p)) != nil
 }
 
 // getAllEntriesFromDir retrieves all entries from the specified directory.
@@ -159,7 +427,14 @@ func getAllEntriesFromDir(rootDir *fileInfo, dir string) ([]*fileInfo, error) {
 		return nil, fmt.Errorf("%s is not a directory", dir)
 	}
 
-	return directory.subEntries, nil
+	// the entries may change as soon as the lock is released
+	directory.rlock()
+	defer directory.runlock()
+	entries := slices.Clone(directory.subEntries)
+	if directory.pendingEntries > 0 {
+		entries = slices.DeleteFunc(entries, func(e *fileInfo) bool { return e.pending })
+	}
+	return entries, nil
 }
 
 type DirNonExistantError struct {
@@ -176,10 +451,11 @@ func isDir(root *fileInfo, name string) bool {
 	if root.isDir && root.name == name {
 		return true
 	}
-	if _, err := root.walkTo(name); err != nil {
+	f, err := root.walkTo(name)
+	if err != nil {
 		return false
 	}
-	return true
+	return f.isDir
 }
 
 // Name returns the base name of the file.
@@ -199,7 +475,7 @@ func (fi fileInfo) Mode() os.FileMode {
 
 // ModTime returns the modification time.
 func (fi fileInfo) ModTime() time.Time {
-	return fi.modTime
+	return fi.modified()
 }
 
 // IsDir returns true if the file is a directory.
@@ -3,33 +3,45 @@ package metadata
 import (
 	"errors"
 	"fmt"
+	"path"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func TestDirFuncs(t *testing.T) {
-	root := &fileInfo{
-		name:  ".",
-		isDir: true,
-		subEntries: []*fileInfo{
-			{
-				name:  "sub1",
-				isDir: true,
-				subEntries: []*fileInfo{
-					{name: "sub1_1", isDir: true},
-					{name: "file1.txt", isDir: false},
-					{name: "file2.txt", isDir: false},
-				},
-			},
-			{
-				name:  "sub2",
-				isDir: true,
-				subEntries: []*fileInfo{
-					{name: "file3.txt", isDir: false},
-				},
-			},
-			{name: "rootfile.txt", isDir: false},
-		},
+// testDir returns an empty directory called name.
+func testDir(name string) *fileInfo {
+	return &fileInfo{name: name, isDir: true, mu: new(sync.RWMutex), index: make(map[string]*fileInfo)}
+}
+
+// testTree returns a root directory holding the entries at paths in that
+// order. The paths of directories end with a slash.
+func testTree(t testing.TB, paths ...string) *fileInfo {
+	t.Helper()
+	root := newRootDir(time.Now())
+	for _, p := range paths {
+		fi := &fileInfo{name: path.Base(p)}
+		if strings.HasSuffix(p, "/") {
+			fi = testDir(path.Base(p))
+		}
+		if err := storeFileInfo(root, strings.TrimSuffix(p, "/"), fi); err != nil {
+			t.Fatal(err)
+		}
 	}
+	return root
+}
+
+func TestDirFuncs(t *testing.T) {
+	root := testTree(t,
+		"sub1/",
+		"sub1/sub1_1/",
+		"sub1/file1.txt",
+		"sub1/file2.txt",
+		"sub2/",
+		"sub2/file3.txt",
+		"rootfile.txt",
+	)
 
 	tests := []struct {
 		path        string
@@ -59,10 +71,7 @@ func TestDirFuncs(t *testing.T) {
 	}
 
 	newDirPath := "sub1/sub1_2"
-	err := storeFileInfo(root, "sub1", &fileInfo{
-		name:  "sub1_2",
-		isDir: true,
-	})
+	err := storeFileInfo(root, newDirPath, testDir("sub1_2"))
 	if err != nil {
 		t.Errorf("Failed to create new directory: %v", err)
 	} else {
@@ -77,7 +86,7 @@ func TestDirFuncs(t *testing.T) {
 	}
 }
 func TestGetFileInfoAtIndex(t *testing.T) {
-	root := createTestFileTree()
+	root := createTestFileTree(t)
 	m := &MetaDataServer{rootDir: root}
 
 	tests := []struct {
@@ -115,30 +124,60 @@ func TestGetFileInfoAtIndex(t *testing.T) {
 	t.Log(*d)
 }
 
-func createTestFileTree() *fileInfo {
+func createTestFileTree(t testing.TB) *fileInfo {
 	// Create a sample file tree
-	return &fileInfo{
-		name:  ".",
-		isDir: true,
-		subEntries: []*fileInfo{
-			{
-				name:  "file1.txt",
-				isDir: false,
-			},
-			{
-				name:  "dir1",
-				isDir: true,
-				subEntries: []*fileInfo{
-					{
-						name:  "file2.txt",
-						isDir: false,
-					},
-					{
-						name:  "file3.txt",
-						isDir: false,
-					},
-				},
-			},
-		},
+	return testTree(t, "file1.txt", "dir1/", "dir1/file2.txt", "dir1/file3.txt")
+}
+
+func TestDirIndex(t *testing.T) {
+	root := createTestFileTree(t)
+	if err := root.insert(&fileInfo{name: "file1.txt"}); err == nil {
+		t.Error("expected inserting a duplicate entry to fail")
+	}
+	for i := range 1000 {
+		if err := root.insert(&fileInfo{name: fmt.Sprintf("f-%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 1000; i += 2 {
+		if root.remove(fmt.Sprintf("f-%d", i)) == nil {
+			t.Fatalf("f-%d could not be removed", i)
+		}
+	}
+	if root.remove("dir1") == nil {
+		t.Fatal("dir1 could not be removed")
+	}
+	if root.lookup("f-2") != nil || root.lookup("dir1") != nil {
+		t.Error("removed entries can still be looked up")
+	}
+	if root.lookup("f-3") == nil {
+		t.Error("f-3 could not be looked up")
+	}
+	// listing order is the order the entries were added in
+	want := []string{"file1.txt"}
+	for i := 1; i < 1000; i += 2 {
+		want = append(want, fmt.Sprintf("f-%d", i))
+	}
+	if len(root.subEntries) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(root.subEntries))
+	}
+	for i, e := range root.subEntries {
+		if e.name != want[i] {
+			t.Fatalf("entry %d: expected %s, got %s", i, want[i], e.name)
+		}
+	}
+}
+
+func BenchmarkCreateInLargeDir(b *testing.B) {
+	root := testTree(b, "dir/")
+	b.ResetTimer()
+	for i := range b.N {
+		p := fmt.Sprintf("dir/file-%d", i)
+		if entryAlreadyExists(root, p) {
+			b.Fatalf("%s already exists", p)
+		}
+		if err := storeFileInfo(root, p, &fileInfo{name: path.Base(p)}); err != nil {
+			b.Fatal(err)
+		}
 	}
 }
@@ -0,0 +1,145 @@
+package metadata
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// findFilter holds the compiled predicates of a FindRequest.
+type findFilter struct {
+	req    *FindRequest
+	re     *regexp.Regexp
+	after  time.Time
+	before time.Time
+}
+
+func newFindFilter(req *FindRequest) (*findFilter, error) {
+	f := &findFilter{req: req}
+	if req.NamePattern != "" {
+		if _, err := path.Match(req.NamePattern, ""); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid name pattern %q: %v", req.NamePattern, err)
+		}
+	}
+	if req.PathRegex != "" {
+		re, err := regexp.Compile(req.PathRegex)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid path expression: %v", err)
+		}
+		f.re = re
+	}
+	if req.MaxSize > 0 && req.MaxSize < req.MinSize {
+		return nil, status.Errorf(codes.InvalidArgument, "size range %d-%d is empty", req.MinSize, req.MaxSize)
+	}
+	if req.ModifiedAfter != nil {
+		f.after = req.ModifiedAfter.AsTime()
+	}
+	if req.ModifiedBefore != nil {
+		f.before = req.ModifiedBefore.AsTime()
+	}
+	return f, nil
+}
+
+// match reports whether the entry e found at p matches.
+func (f *findFilter) match(e *fileInfo, p string) bool {
+	switch f.req.Type {
+	case FindType_FILE:
+		if e.isDir || e.isSymlink() {
+			return false
+		}
+	case FindType_DIRECTORY:
+		if !e.isDir {
+			return false
+		}
+	case FindType_SYMLINK:
+		if !e.isSymlink() {
+			return false
+		}
+	}
+	if e.size < f.req.MinSize || (f.req.MaxSize > 0 && e.size > f.req.MaxSize) {
+		return false
+	}
+	if mod := e.modified(); (!f.after.IsZero() && !mod.After(f.after)) || (!f.before.IsZero() && !mod.Before(f.before)) {
+		return false
+	}
+	if f.req.NamePattern != "" {
+		if ok, _ := path.Match(f.req.NamePattern, e.name); !ok {
+			return false
+		}
+	}
+	return f.re == nil || f.re.MatchString(p)
+}
+
+// Find streams the entries below a directory that match the predicates of
+// the request, the matches of a directory before the ones of its
+// subdirectories. Symbolic links are not followed below the directory. The
+// paths of the matches are the ones they were found at, which differ from
+// the live ones below a snapshot. Every
+// directory is read on its own, so the search does not hold up changes of
+// the namespace while the results are sent.
+func (s *MetaDataServer) Find(req *FindRequest, stream MetadataService_FindServer) error {
+	filter, err := newFindFilter(req)
+	if err != nil {
+		return err
+	}
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return err
+	}
+	if err := s.checkOwns(p); err != nil {
+		return err
+	}
+	s.muDir.RLock()
+	root, err := s.rootDir.walkTo(p)
+	s.muDir.RUnlock()
+	if err != nil || !root.isDir {
+		return fmt.Errorf("the directory %s doesn't exist", p)
+	}
+	return s.find(stream, filter, root, p, 1)
+}
+
+// find searches dir, which is found at dirPath.
+func (s *MetaDataServer) find(stream MetadataService_FindServer, filter *findFilter, dir *fileInfo, dirPath string, depth int) error {
+	if err := stream.Context().Err(); err != nil {
+		return err
+	}
+	var matches []*FileInfo
+	var subDirs []*fileInfo
+	s.muDir.RLock()
+	dir.rlock()
+	for _, e := range dir.subEntries {
+		if e.pending {
+			continue
+		}
+		p := path.Join(dirPath, e.name)
+		if filter.match(e, p) {
+			info := convert(e)
+			info.FullPath = p
+			matches = append(matches, info)
+		}
+		if e.isDir {
+			subDirs = append(subDirs, e)
+		}
+	}
+	dir.runlock()
+	s.muDir.RUnlock()
+
+	for _, m := range matches {
+		if err := stream.Send(m); err != nil {
+			return err
+		}
+	}
+	if filter.req.MaxDepth > 0 && depth >= int(filter.req.MaxDepth) {
+		return nil
+	}
+	for _, d := range subDirs {
+		if err := s.find(stream, filter, d, path.Join(dirPath, d.name), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package metadata
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// findStream collects the entries a search sends.
+type findStream struct {
+	grpc.ServerStream
+	found []string
+}
+
+func (f *findStream) Context() context.Context { return context.Background() }
+
+func (f *findStream) Send(info *FileInfo) error {
+	f.found = append(f.found, info.FullPath)
+	return nil
+}
+
+func TestFind(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir())
+	defer s.Stop()
+
+	for _, d := range []string{"logs", "logs/2024", "data"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []struct {
+		name string
+		size int64
+	}{
+		{"logs/a.log", 10},
+		{"logs/2024/b.log", 2000},
+		{"logs/2024/c.txt", 50},
+		{"data/d.log", 30},
+	} {
+		if _, err := createFile(ctx, s, &RecRequest{Name: f.name, FileSize: f.size}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now()
+	if _, err := createFile(ctx, s, &RecRequest{Name: "logs/new.log", FileSize: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "logs/pending.log"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Symlink(ctx, &SymlinkRequest{Name: "logs/data", Target: "../data"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		req  *FindRequest
+		want []string
+	}{
+		{&FindRequest{Name: "logs", NamePattern: "*.log"}, []string{"logs/a.log", "logs/new.log", "logs/2024/b.log"}},
+		{&FindRequest{Name: "logs", NamePattern: "*.log", MaxDepth: 1}, []string{"logs/a.log", "logs/new.log"}},
+		{&FindRequest{Name: ".", PathRegex: `^(data|logs/2024)/`, MinSize: 40}, []string{"logs/2024/b.log", "logs/2024/c.txt"}},
+		{&FindRequest{Name: ".", MinSize: 20, MaxSize: 100, Type: FindType_FILE}, []string{"logs/2024/c.txt", "data/d.log"}},
+		{&FindRequest{Name: ".", Type: FindType_DIRECTORY}, []string{"logs", "data", "logs/2024"}},
+		{&FindRequest{Name: "logs", Type: FindType_SYMLINK}, []string{"logs/data"}},
+		{&FindRequest{Name: ".", Type: FindType_FILE, ModifiedAfter: timestamppb.New(start)}, []string{"logs/new.log"}},
+	} {
+		stream := new(findStream)
+		if err := s.Find(tc.req, stream); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(stream.found, tc.want) {
+			t.Errorf("expected %v to find %v, got %v", tc.req, tc.want, stream.found)
+		}
+	}
+	if err := s.Find(&FindRequest{Name: ".", NamePattern: "[a"}, new(findStream)); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an invalid pattern to be rejected, got %v", err)
+	}
+}
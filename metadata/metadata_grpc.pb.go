@@ -23,11 +23,41 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetadataServiceClient interface {
 	RegisterFileCreation(ctx context.Context, in *RecRequest, opts ...grpc.CallOption) (*RecResponse, error)
+	CommitFile(ctx context.Context, in *CommitFileRequest, opts ...grpc.CallOption) (*CommitFileResponse, error)
 	GetLocation(ctx context.Context, in *LocRequest, opts ...grpc.CallOption) (*LocResponse, error)
 	OpenDir(ctx context.Context, in *OpenDirRequest, opts ...grpc.CallOption) (*OpenDirResponse, error)
 	ReadDir(ctx context.Context, in *ReadDirRequest, opts ...grpc.CallOption) (*FileInfo, error)
 	ReadDirAll(ctx context.Context, in *ReadDirRequest, opts ...grpc.CallOption) (*ReadDirAllResponse, error)
+	ReadDirPlus(ctx context.Context, in *ReadDirPlusRequest, opts ...grpc.CallOption) (*ReadDirPlusResponse, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (MetadataService_WatchClient, error)
+	Find(ctx context.Context, in *FindRequest, opts ...grpc.CallOption) (MetadataService_FindClient, error)
+	WalkTree(ctx context.Context, in *WalkTreeRequest, opts ...grpc.CallOption) (MetadataService_WalkTreeClient, error)
+	CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest, opts ...grpc.CallOption) (*CreateSnapshotResponse, error)
+	DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest, opts ...grpc.CallOption) (*DeleteSnapshotResponse, error)
+	ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (*ListSnapshotsResponse, error)
+	Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error)
+	Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc.CallOption) (*UnlockResponse, error)
+	RenewLease(ctx context.Context, in *RenewLeaseRequest, opts ...grpc.CallOption) (*RenewLeaseResponse, error)
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error)
 	MkDir(ctx context.Context, in *MkDirRequest, opts ...grpc.CallOption) (*MkDirResponse, error)
+	Unlink(ctx context.Context, in *UnlinkRequest, opts ...grpc.CallOption) (*UnlinkResponse, error)
+	RmDir(ctx context.Context, in *RmDirRequest, opts ...grpc.CallOption) (*RmDirResponse, error)
+	Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*RenameResponse, error)
+	Symlink(ctx context.Context, in *SymlinkRequest, opts ...grpc.CallOption) (*SymlinkResponse, error)
+	Readlink(ctx context.Context, in *ReadlinkRequest, opts ...grpc.CallOption) (*ReadlinkResponse, error)
+	Link(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*LinkResponse, error)
+	SetReplication(ctx context.Context, in *SetReplicationRequest, opts ...grpc.CallOption) (*SetReplicationResponse, error)
+	SetStorageClass(ctx context.Context, in *SetStorageClassRequest, opts ...grpc.CallOption) (*SetStorageClassResponse, error)
+	SetQuota(ctx context.Context, in *SetQuotaRequest, opts ...grpc.CallOption) (*SetQuotaResponse, error)
+	SetXattr(ctx context.Context, in *SetXattrRequest, opts ...grpc.CallOption) (*SetXattrResponse, error)
+	GetXattr(ctx context.Context, in *GetXattrRequest, opts ...grpc.CallOption) (*GetXattrResponse, error)
+	ListXattr(ctx context.Context, in *ListXattrRequest, opts ...grpc.CallOption) (*ListXattrResponse, error)
+	RemoveXattr(ctx context.Context, in *RemoveXattrRequest, opts ...grpc.CallOption) (*RemoveXattrResponse, error)
+	GetQuota(ctx context.Context, in *QuotaRequest, opts ...grpc.CallOption) (*QuotaResponse, error)
+	RegisterFileServer(ctx context.Context, in *RegisterFileServerRequest, opts ...grpc.CallOption) (*RegisterFileServerResponse, error)
+	DeregisterFileServer(ctx context.Context, in *DeregisterFileServerRequest, opts ...grpc.CallOption) (*DeregisterFileServerResponse, error)
+	ListFileServers(ctx context.Context, in *ListFileServersRequest, opts ...grpc.CallOption) (*ListFileServersResponse, error)
+	GetRepairStatus(ctx context.Context, in *RepairStatusRequest, opts ...grpc.CallOption) (*RepairStatusResponse, error)
 	DeleteAllData(ctx context.Context, in *DeleteAllDataRequest, opts ...grpc.CallOption) (*DeleteAllDataReponse, error)
 	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
 }
@@ -49,6 +79,15 @@ func (c *metadataServiceClient) RegisterFileCreation(ctx context.Context, in *Re
 	return out, nil
 }
 
+func (c *metadataServiceClient) CommitFile(ctx context.Context, in *CommitFileRequest, opts ...grpc.CallOption) (*CommitFileResponse, error) {
+	out := new(CommitFileResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/CommitFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *metadataServiceClient) GetLocation(ctx context.Context, in *LocRequest, opts ...grpc.CallOption) (*LocResponse, error) {
 	out := new(LocResponse)
 	err := c.cc.Invoke(ctx, "/metadata.MetadataService/GetLocation", in, out, opts...)
@@ -85,6 +124,174 @@ func (c *metadataServiceClient) ReadDirAll(ctx context.Context, in *ReadDirReque
 	return out, nil
 }
 
+func (c *metadataServiceClient) ReadDirPlus(ctx context.Context, in *ReadDirPlusRequest, opts ...grpc.CallOption) (*ReadDirPlusResponse, error) {
+	out := new(ReadDirPlusResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/ReadDirPlus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (MetadataService_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetadataService_ServiceDesc.Streams[0], "/metadata.MetadataService/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metadataServiceWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MetadataService_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type metadataServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *metadataServiceWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metadataServiceClient) Find(ctx context.Context, in *FindRequest, opts ...grpc.CallOption) (MetadataService_FindClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetadataService_ServiceDesc.Streams[1], "/metadata.MetadataService/Find", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metadataServiceFindClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MetadataService_FindClient interface {
+	Recv() (*FileInfo, error)
+	grpc.ClientStream
+}
+
+type metadataServiceFindClient struct {
+	grpc.ClientStream
+}
+
+func (x *metadataServiceFindClient) Recv() (*FileInfo, error) {
+	m := new(FileInfo)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metadataServiceClient) WalkTree(ctx context.Context, in *WalkTreeRequest, opts ...grpc.CallOption) (MetadataService_WalkTreeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetadataService_ServiceDesc.Streams[2], "/metadata.MetadataService/WalkTree", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metadataServiceWalkTreeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MetadataService_WalkTreeClient interface {
+	Recv() (*WalkTreeResponse, error)
+	grpc.ClientStream
+}
+
+type metadataServiceWalkTreeClient struct {
+	grpc.ClientStream
+}
+
+func (x *metadataServiceWalkTreeClient) Recv() (*WalkTreeResponse, error) {
+	m := new(WalkTreeResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metadataServiceClient) CreateSnapshot(ctx context.Context, in *CreateSnapshotRequest, opts ...grpc.CallOption) (*CreateSnapshotResponse, error) {
+	out := new(CreateSnapshotResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/CreateSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) DeleteSnapshot(ctx context.Context, in *DeleteSnapshotRequest, opts ...grpc.CallOption) (*DeleteSnapshotResponse, error) {
+	out := new(DeleteSnapshotResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/DeleteSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) ListSnapshots(ctx context.Context, in *ListSnapshotsRequest, opts ...grpc.CallOption) (*ListSnapshotsResponse, error) {
+	out := new(ListSnapshotsResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/ListSnapshots", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error) {
+	out := new(LockResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/Lock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc.CallOption) (*UnlockResponse, error) {
+	out := new(UnlockResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/Unlock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) RenewLease(ctx context.Context, in *RenewLeaseRequest, opts ...grpc.CallOption) (*RenewLeaseResponse, error) {
+	out := new(RenewLeaseResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/RenewLease", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error) {
+	out := new(FileInfo)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/Stat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *metadataServiceClient) MkDir(ctx context.Context, in *MkDirRequest, opts ...grpc.CallOption) (*MkDirResponse, error) {
 	out := new(MkDirResponse)
 	err := c.cc.Invoke(ctx, "/metadata.MetadataService/MkDir", in, out, opts...)
@@ -94,6 +301,168 @@ func (c *metadataServiceClient) MkDir(ctx context.Context, in *MkDirRequest, opt
 	return out, nil
 }
 
+func (c *metadataServiceClient) Unlink(ctx context.Context, in *UnlinkRequest, opts ...grpc.CallOption) (*UnlinkResponse, error) {
+	out := new(UnlinkResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/Unlink", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) RmDir(ctx context.Context, in *RmDirRequest, opts ...grpc.CallOption) (*RmDirResponse, error) {
+	out := new(RmDirResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/RmDir", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*RenameResponse, error) {
+	out := new(RenameResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/Rename", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) Symlink(ctx context.Context, in *SymlinkRequest, opts ...grpc.CallOption) (*SymlinkResponse, error) {
+	out := new(SymlinkResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/Symlink", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) Readlink(ctx context.Context, in *ReadlinkRequest, opts ...grpc.CallOption) (*ReadlinkResponse, error) {
+	out := new(ReadlinkResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/Readlink", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) Link(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*LinkResponse, error) {
+	out := new(LinkResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/Link", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) SetReplication(ctx context.Context, in *SetReplicationRequest, opts ...grpc.CallOption) (*SetReplicationResponse, error) {
+	out := new(SetReplicationResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/SetReplication", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) SetStorageClass(ctx context.Context, in *SetStorageClassRequest, opts ...grpc.CallOption) (*SetStorageClassResponse, error) {
+	out := new(SetStorageClassResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/SetStorageClass", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) SetQuota(ctx context.Context, in *SetQuotaRequest, opts ...grpc.CallOption) (*SetQuotaResponse, error) {
+	out := new(SetQuotaResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/SetQuota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) SetXattr(ctx context.Context, in *SetXattrRequest, opts ...grpc.CallOption) (*SetXattrResponse, error) {
+	out := new(SetXattrResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/SetXattr", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) GetXattr(ctx context.Context, in *GetXattrRequest, opts ...grpc.CallOption) (*GetXattrResponse, error) {
+	out := new(GetXattrResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/GetXattr", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) ListXattr(ctx context.Context, in *ListXattrRequest, opts ...grpc.CallOption) (*ListXattrResponse, error) {
+	out := new(ListXattrResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/ListXattr", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) RemoveXattr(ctx context.Context, in *RemoveXattrRequest, opts ...grpc.CallOption) (*RemoveXattrResponse, error) {
+	out := new(RemoveXattrResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/RemoveXattr", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) GetQuota(ctx context.Context, in *QuotaRequest, opts ...grpc.CallOption) (*QuotaResponse, error) {
+	out := new(QuotaResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/GetQuota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) RegisterFileServer(ctx context.Context, in *RegisterFileServerRequest, opts ...grpc.CallOption) (*RegisterFileServerResponse, error) {
+	out := new(RegisterFileServerResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/RegisterFileServer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) DeregisterFileServer(ctx context.Context, in *DeregisterFileServerRequest, opts ...grpc.CallOption) (*DeregisterFileServerResponse, error) {
+	out := new(DeregisterFileServerResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/DeregisterFileServer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) ListFileServers(ctx context.Context, in *ListFileServersRequest, opts ...grpc.CallOption) (*ListFileServersResponse, error) {
+	out := new(ListFileServersResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/ListFileServers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metadataServiceClient) GetRepairStatus(ctx context.Context, in *RepairStatusRequest, opts ...grpc.CallOption) (*RepairStatusResponse, error) {
+	out := new(RepairStatusResponse)
+	err := c.cc.Invoke(ctx, "/metadata.MetadataService/GetRepairStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *metadataServiceClient) DeleteAllData(ctx context.Context, in *DeleteAllDataRequest, opts ...grpc.CallOption) (*DeleteAllDataReponse, error) {
 	out := new(DeleteAllDataReponse)
 	err := c.cc.Invoke(ctx, "/metadata.MetadataService/DeleteAllData", in, out, opts...)
@@ -117,11 +486,41 @@ func (c *metadataServiceClient) Ping(ctx context.Context, in *PingRequest, opts
 // for forward compatibility
 type MetadataServiceServer interface {
 	RegisterFileCreation(context.Context, *RecRequest) (*RecResponse, error)
+	CommitFile(context.Context, *CommitFileRequest) (*CommitFileResponse, error)
 	GetLocation(context.Context, *LocRequest) (*LocResponse, error)
 	OpenDir(context.Context, *OpenDirRequest) (*OpenDirResponse, error)
 	ReadDir(context.Context, *ReadDirRequest) (*FileInfo, error)
 	ReadDirAll(context.Context, *ReadDirRequest) (*ReadDirAllResponse, error)
+	ReadDirPlus(context.Context, *ReadDirPlusRequest) (*ReadDirPlusResponse, error)
+	Watch(*WatchRequest, MetadataService_WatchServer) error
+	Find(*FindRequest, MetadataService_FindServer) error
+	WalkTree(*WalkTreeRequest, MetadataService_WalkTreeServer) error
+	CreateSnapshot(context.Context, *CreateSnapshotRequest) (*CreateSnapshotResponse, error)
+	DeleteSnapshot(context.Context, *DeleteSnapshotRequest) (*DeleteSnapshotResponse, error)
+	ListSnapshots(context.Context, *ListSnapshotsRequest) (*ListSnapshotsResponse, error)
+	Lock(context.Context, *LockRequest) (*LockResponse, error)
+	Unlock(context.Context, *UnlockRequest) (*UnlockResponse, error)
+	RenewLease(context.Context, *RenewLeaseRequest) (*RenewLeaseResponse, error)
+	Stat(context.Context, *StatRequest) (*FileInfo, error)
 	MkDir(context.Context, *MkDirRequest) (*MkDirResponse, error)
+	Unlink(context.Context, *UnlinkRequest) (*UnlinkResponse, error)
+	RmDir(context.Context, *RmDirRequest) (*RmDirResponse, error)
+	Rename(context.Context, *RenameRequest) (*RenameResponse, error)
+	Symlink(context.Context, *SymlinkRequest) (*SymlinkResponse, error)
+	Readlink(context.Context, *ReadlinkRequest) (*ReadlinkResponse, error)
+	Link(context.Context, *LinkRequest) (*LinkResponse, error)
+	SetReplication(context.Context, *SetReplicationRequest) (*SetReplicationResponse, error)
+	SetStorageClass(context.Context, *SetStorageClassRequest) (*SetStorageClassResponse, error)
+	SetQuota(context.Context, *SetQuotaRequest) (*SetQuotaResponse, error)
+	SetXattr(context.Context, *SetXattrRequest) (*SetXattrResponse, error)
+	GetXattr(context.Context, *GetXattrRequest) (*GetXattrResponse, error)
+	ListXattr(context.Context, *ListXattrRequest) (*ListXattrResponse, error)
+	RemoveXattr(context.Context, *RemoveXattrRequest) (*RemoveXattrResponse, error)
+	GetQuota(context.Context, *QuotaRequest) (*QuotaResponse, error)
+	RegisterFileServer(context.Context, *RegisterFileServerRequest) (*RegisterFileServerResponse, error)
+	DeregisterFileServer(context.Context, *DeregisterFileServerRequest) (*DeregisterFileServerResponse, error)
+	ListFileServers(context.Context, *ListFileServersRequest) (*ListFileServersResponse, error)
+	GetRepairStatus(context.Context, *RepairStatusRequest) (*RepairStatusResponse, error)
 	DeleteAllData(context.Context, *DeleteAllDataRequest) (*DeleteAllDataReponse, error)
 	Ping(context.Context, *PingRequest) (*PingResponse, error)
 	mustEmbedUnimplementedMetadataServiceServer()
@@ -134,6 +533,9 @@ type UnimplementedMetadataServiceServer struct {
 func (UnimplementedMetadataServiceServer) RegisterFileCreation(context.Context, *RecRequest) (*RecResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RegisterFileCreation not implemented")
 }
+func (UnimplementedMetadataServiceServer) CommitFile(context.Context, *CommitFileRequest) (*CommitFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommitFile not implemented")
+}
 func (UnimplementedMetadataServiceServer) GetLocation(context.Context, *LocRequest) (*LocResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetLocation not implemented")
 }
@@ -146,9 +548,96 @@ func (UnimplementedMetadataServiceServer) ReadDir(context.Context, *ReadDirReque
 func (UnimplementedMetadataServiceServer) ReadDirAll(context.Context, *ReadDirRequest) (*ReadDirAllResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReadDirAll not implemented")
 }
+func (UnimplementedMetadataServiceServer) ReadDirPlus(context.Context, *ReadDirPlusRequest) (*ReadDirPlusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadDirPlus not implemented")
+}
+func (UnimplementedMetadataServiceServer) Watch(*WatchRequest, MetadataService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedMetadataServiceServer) Find(*FindRequest, MetadataService_FindServer) error {
+	return status.Errorf(codes.Unimplemented, "method Find not implemented")
+}
+func (UnimplementedMetadataServiceServer) WalkTree(*WalkTreeRequest, MetadataService_WalkTreeServer) error {
+	return status.Errorf(codes.Unimplemented, "method WalkTree not implemented")
+}
+func (UnimplementedMetadataServiceServer) CreateSnapshot(context.Context, *CreateSnapshotRequest) (*CreateSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSnapshot not implemented")
+}
+func (UnimplementedMetadataServiceServer) DeleteSnapshot(context.Context, *DeleteSnapshotRequest) (*DeleteSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteSnapshot not implemented")
+}
+func (UnimplementedMetadataServiceServer) ListSnapshots(context.Context, *ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSnapshots not implemented")
+}
+func (UnimplementedMetadataServiceServer) Lock(context.Context, *LockRequest) (*LockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lock not implemented")
+}
+func (UnimplementedMetadataServiceServer) Unlock(context.Context, *UnlockRequest) (*UnlockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unlock not implemented")
+}
+func (UnimplementedMetadataServiceServer) RenewLease(context.Context, *RenewLeaseRequest) (*RenewLeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenewLease not implemented")
+}
+func (UnimplementedMetadataServiceServer) Stat(context.Context, *StatRequest) (*FileInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
 func (UnimplementedMetadataServiceServer) MkDir(context.Context, *MkDirRequest) (*MkDirResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method MkDir not implemented")
 }
+func (UnimplementedMetadataServiceServer) Unlink(context.Context, *UnlinkRequest) (*UnlinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unlink not implemented")
+}
+func (UnimplementedMetadataServiceServer) RmDir(context.Context, *RmDirRequest) (*RmDirResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RmDir not implemented")
+}
+func (UnimplementedMetadataServiceServer) Rename(context.Context, *RenameRequest) (*RenameResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rename not implemented")
+}
+func (UnimplementedMetadataServiceServer) Symlink(context.Context, *SymlinkRequest) (*SymlinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Symlink not implemented")
+}
+func (UnimplementedMetadataServiceServer) Readlink(context.Context, *ReadlinkRequest) (*ReadlinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Readlink not implemented")
+}
+func (UnimplementedMetadataServiceServer) Link(context.Context, *LinkRequest) (*LinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Link not implemented")
+}
+func (UnimplementedMetadataServiceServer) SetReplication(context.Context, *SetReplicationRequest) (*SetReplicationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetReplication not implemented")
+}
+func (UnimplementedMetadataServiceServer) SetStorageClass(context.Context, *SetStorageClassRequest) (*SetStorageClassResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetStorageClass not implemented")
+}
+func (UnimplementedMetadataServiceServer) SetQuota(context.Context, *SetQuotaRequest) (*SetQuotaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetQuota not implemented")
+}
+func (UnimplementedMetadataServiceServer) SetXattr(context.Context, *SetXattrRequest) (*SetXattrResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetXattr not implemented")
+}
+func (UnimplementedMetadataServiceServer) GetXattr(context.Context, *GetXattrRequest) (*GetXattrResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetXattr not implemented")
+}
+func (UnimplementedMetadataServiceServer) ListXattr(context.Context, *ListXattrRequest) (*ListXattrResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListXattr not implemented")
+}
+func (UnimplementedMetadataServiceServer) RemoveXattr(context.Context, *RemoveXattrRequest) (*RemoveXattrResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveXattr not implemented")
+}
+func (UnimplementedMetadataServiceServer) GetQuota(context.Context, *QuotaRequest) (*QuotaResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQuota not implemented")
+}
+func (UnimplementedMetadataServiceServer) RegisterFileServer(context.Context, *RegisterFileServerRequest) (*RegisterFileServerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterFileServer not implemented")
+}
+func (UnimplementedMetadataServiceServer) DeregisterFileServer(context.Context, *DeregisterFileServerRequest) (*DeregisterFileServerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeregisterFileServer not implemented")
+}
+func (UnimplementedMetadataServiceServer) ListFileServers(context.Context, *ListFileServersRequest) (*ListFileServersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFileServers not implemented")
+}
+func (UnimplementedMetadataServiceServer) GetRepairStatus(context.Context, *RepairStatusRequest) (*RepairStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRepairStatus not implemented")
+}
 func (UnimplementedMetadataServiceServer) DeleteAllData(context.Context, *DeleteAllDataRequest) (*DeleteAllDataReponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteAllData not implemented")
 }
@@ -186,92 +675,641 @@ func _MetadataService_RegisterFileCreation_Handler(srv interface{}, ctx context.
 	return interceptor(ctx, in, info, handler)
 }
 
-func _MetadataService_GetLocation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(LocRequest)
+func _MetadataService_CommitFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitFileRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(MetadataServiceServer).GetLocation(ctx, in)
+		return srv.(MetadataServiceServer).CommitFile(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/metadata.MetadataService/GetLocation",
+		FullMethod: "/metadata.MetadataService/CommitFile",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(MetadataServiceServer).GetLocation(ctx, req.(*LocRequest))
+		return srv.(MetadataServiceServer).CommitFile(ctx, req.(*CommitFileRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _MetadataService_OpenDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(OpenDirRequest)
+func _MetadataService_GetLocation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LocRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(MetadataServiceServer).OpenDir(ctx, in)
+		return srv.(MetadataServiceServer).GetLocation(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/metadata.MetadataService/OpenDir",
+		FullMethod: "/metadata.MetadataService/GetLocation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).GetLocation(ctx, req.(*LocRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_OpenDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OpenDirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).OpenDir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/OpenDir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).OpenDir(ctx, req.(*OpenDirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_ReadDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadDirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).ReadDir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/ReadDir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).ReadDir(ctx, req.(*ReadDirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_ReadDirAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadDirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).ReadDirAll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/ReadDirAll",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).ReadDirAll(ctx, req.(*ReadDirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_ReadDirPlus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadDirPlusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).ReadDirPlus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/ReadDirPlus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).ReadDirPlus(ctx, req.(*ReadDirPlusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetadataServiceServer).Watch(m, &metadataServiceWatchServer{stream})
+}
+
+type MetadataService_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type metadataServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *metadataServiceWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MetadataService_Find_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FindRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetadataServiceServer).Find(m, &metadataServiceFindServer{stream})
+}
+
+type MetadataService_FindServer interface {
+	Send(*FileInfo) error
+	grpc.ServerStream
+}
+
+type metadataServiceFindServer struct {
+	grpc.ServerStream
+}
+
+func (x *metadataServiceFindServer) Send(m *FileInfo) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MetadataService_WalkTree_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WalkTreeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetadataServiceServer).WalkTree(m, &metadataServiceWalkTreeServer{stream})
+}
+
+type MetadataService_WalkTreeServer interface {
+	Send(*WalkTreeResponse) error
+	grpc.ServerStream
+}
+
+type metadataServiceWalkTreeServer struct {
+	grpc.ServerStream
+}
+
+func (x *metadataServiceWalkTreeServer) Send(m *WalkTreeResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MetadataService_CreateSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).CreateSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/CreateSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).CreateSnapshot(ctx, req.(*CreateSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_DeleteSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).DeleteSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/DeleteSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).DeleteSnapshot(ctx, req.(*DeleteSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_ListSnapshots_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSnapshotsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).ListSnapshots(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/ListSnapshots",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).ListSnapshots(ctx, req.(*ListSnapshotsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Lock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Lock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/Lock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Lock(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Unlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Unlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/Unlock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Unlock(ctx, req.(*UnlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_RenewLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewLeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).RenewLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/RenewLease",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).RenewLease(ctx, req.(*RenewLeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/Stat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_MkDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MkDirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).MkDir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/MkDir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).MkDir(ctx, req.(*MkDirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Unlink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Unlink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/Unlink",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Unlink(ctx, req.(*UnlinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_RmDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RmDirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).RmDir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/RmDir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).RmDir(ctx, req.(*RmDirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Rename_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Rename(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/Rename",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Rename(ctx, req.(*RenameRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Symlink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SymlinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Symlink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/Symlink",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Symlink(ctx, req.(*SymlinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Readlink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadlinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Readlink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/Readlink",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Readlink(ctx, req.(*ReadlinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_Link_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).Link(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/Link",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).Link(ctx, req.(*LinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_SetReplication_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetReplicationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).SetReplication(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/SetReplication",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).SetReplication(ctx, req.(*SetReplicationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_SetStorageClass_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetStorageClassRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).SetStorageClass(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/SetStorageClass",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).SetStorageClass(ctx, req.(*SetStorageClassRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_SetQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetQuotaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).SetQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/SetQuota",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).SetQuota(ctx, req.(*SetQuotaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_SetXattr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetXattrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).SetXattr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/SetXattr",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).SetXattr(ctx, req.(*SetXattrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_GetXattr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetXattrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).GetXattr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/GetXattr",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).GetXattr(ctx, req.(*GetXattrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_ListXattr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListXattrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).ListXattr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/ListXattr",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).ListXattr(ctx, req.(*ListXattrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_RemoveXattr_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveXattrRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).RemoveXattr(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/RemoveXattr",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).RemoveXattr(ctx, req.(*RemoveXattrRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_GetQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QuotaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).GetQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/GetQuota",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(MetadataServiceServer).OpenDir(ctx, req.(*OpenDirRequest))
+		return srv.(MetadataServiceServer).GetQuota(ctx, req.(*QuotaRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _MetadataService_ReadDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ReadDirRequest)
+func _MetadataService_RegisterFileServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterFileServerRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(MetadataServiceServer).ReadDir(ctx, in)
+		return srv.(MetadataServiceServer).RegisterFileServer(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/metadata.MetadataService/ReadDir",
+		FullMethod: "/metadata.MetadataService/RegisterFileServer",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(MetadataServiceServer).ReadDir(ctx, req.(*ReadDirRequest))
+		return srv.(MetadataServiceServer).RegisterFileServer(ctx, req.(*RegisterFileServerRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _MetadataService_ReadDirAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ReadDirRequest)
+func _MetadataService_DeregisterFileServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeregisterFileServerRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(MetadataServiceServer).ReadDirAll(ctx, in)
+		return srv.(MetadataServiceServer).DeregisterFileServer(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/metadata.MetadataService/ReadDirAll",
+		FullMethod: "/metadata.MetadataService/DeregisterFileServer",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(MetadataServiceServer).ReadDirAll(ctx, req.(*ReadDirRequest))
+		return srv.(MetadataServiceServer).DeregisterFileServer(ctx, req.(*DeregisterFileServerRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _MetadataService_MkDir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(MkDirRequest)
+func _MetadataService_ListFileServers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFileServersRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(MetadataServiceServer).MkDir(ctx, in)
+		return srv.(MetadataServiceServer).ListFileServers(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/metadata.MetadataService/MkDir",
+		FullMethod: "/metadata.MetadataService/ListFileServers",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(MetadataServiceServer).MkDir(ctx, req.(*MkDirRequest))
+		return srv.(MetadataServiceServer).ListFileServers(ctx, req.(*ListFileServersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetadataService_GetRepairStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RepairStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServiceServer).GetRepairStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.MetadataService/GetRepairStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServiceServer).GetRepairStatus(ctx, req.(*RepairStatusRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -323,6 +1361,10 @@ var MetadataService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RegisterFileCreation",
 			Handler:    _MetadataService_RegisterFileCreation_Handler,
 		},
+		{
+			MethodName: "CommitFile",
+			Handler:    _MetadataService_CommitFile_Handler,
+		},
 		{
 			MethodName: "GetLocation",
 			Handler:    _MetadataService_GetLocation_Handler,
@@ -339,10 +1381,114 @@ var MetadataService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ReadDirAll",
 			Handler:    _MetadataService_ReadDirAll_Handler,
 		},
+		{
+			MethodName: "ReadDirPlus",
+			Handler:    _MetadataService_ReadDirPlus_Handler,
+		},
+		{
+			MethodName: "CreateSnapshot",
+			Handler:    _MetadataService_CreateSnapshot_Handler,
+		},
+		{
+			MethodName: "DeleteSnapshot",
+			Handler:    _MetadataService_DeleteSnapshot_Handler,
+		},
+		{
+			MethodName: "ListSnapshots",
+			Handler:    _MetadataService_ListSnapshots_Handler,
+		},
+		{
+			MethodName: "Lock",
+			Handler:    _MetadataService_Lock_Handler,
+		},
+		{
+			MethodName: "Unlock",
+			Handler:    _MetadataService_Unlock_Handler,
+		},
+		{
+			MethodName: "RenewLease",
+			Handler:    _MetadataService_RenewLease_Handler,
+		},
+		{
+			MethodName: "Stat",
+			Handler:    _MetadataService_Stat_Handler,
+		},
 		{
 			MethodName: "MkDir",
 			Handler:    _MetadataService_MkDir_Handler,
 		},
+		{
+			MethodName: "Unlink",
+			Handler:    _MetadataService_Unlink_Handler,
+		},
+		{
+			MethodName: "RmDir",
+			Handler:    _MetadataService_RmDir_Handler,
+		},
+		{
+			MethodName: "Rename",
+			Handler:    _MetadataService_Rename_Handler,
+		},
+		{
+			MethodName: "Symlink",
+			Handler:    _MetadataService_Symlink_Handler,
+		},
+		{
+			MethodName: "Readlink",
+			Handler:    _MetadataService_Readlink_Handler,
+		},
+		{
+			MethodName: "Link",
+			Handler:    _MetadataService_Link_Handler,
+		},
+		{
+			MethodName: "SetReplication",
+			Handler:    _MetadataService_SetReplication_Handler,
+		},
+		{
+			MethodName: "SetStorageClass",
+			Handler:    _MetadataService_SetStorageClass_Handler,
+		},
+		{
+			MethodName: "SetQuota",
+			Handler:    _MetadataService_SetQuota_Handler,
+		},
+		{
+			MethodName: "SetXattr",
+			Handler:    _MetadataService_SetXattr_Handler,
+		},
+		{
+			MethodName: "GetXattr",
+			Handler:    _MetadataService_GetXattr_Handler,
+		},
+		{
+			MethodName: "ListXattr",
+			Handler:    _MetadataService_ListXattr_Handler,
+		},
+		{
+			MethodName: "RemoveXattr",
+			Handler:    _MetadataService_RemoveXattr_Handler,
+		},
+		{
+			MethodName: "GetQuota",
+			Handler:    _MetadataService_GetQuota_Handler,
+		},
+		{
+			MethodName: "RegisterFileServer",
+			Handler:    _MetadataService_RegisterFileServer_Handler,
+		},
+		{
+			MethodName: "DeregisterFileServer",
+			Handler:    _MetadataService_DeregisterFileServer_Handler,
+		},
+		{
+			MethodName: "ListFileServers",
+			Handler:    _MetadataService_ListFileServers_Handler,
+		},
+		{
+			MethodName: "GetRepairStatus",
+			Handler:    _MetadataService_GetRepairStatus_Handler,
+		},
 		{
 			MethodName: "DeleteAllData",
 			Handler:    _MetadataService_DeleteAllData_Handler,
@@ -352,6 +1498,302 @@ var MetadataService_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _MetadataService_Ping_Handler,
 		},
 	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _MetadataService_Watch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Find",
+			Handler:       _MetadataService_Find_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WalkTree",
+			Handler:       _MetadataService_WalkTree_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "metadata.proto",
+}
+
+// RaftServiceClient is the client API for RaftService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RaftServiceClient interface {
+	RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendResponse, error)
+	InstallSnapshot(ctx context.Context, in *InstallSnapshotRequest, opts ...grpc.CallOption) (*InstallSnapshotResponse, error)
+}
+
+type raftServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRaftServiceClient(cc grpc.ClientConnInterface) RaftServiceClient {
+	return &raftServiceClient{cc}
+}
+
+func (c *raftServiceClient) RequestVote(ctx context.Context, in *VoteRequest, opts ...grpc.CallOption) (*VoteResponse, error) {
+	out := new(VoteResponse)
+	err := c.cc.Invoke(ctx, "/metadata.RaftService/RequestVote", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftServiceClient) AppendEntries(ctx context.Context, in *AppendRequest, opts ...grpc.CallOption) (*AppendResponse, error) {
+	out := new(AppendResponse)
+	err := c.cc.Invoke(ctx, "/metadata.RaftService/AppendEntries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftServiceClient) InstallSnapshot(ctx context.Context, in *InstallSnapshotRequest, opts ...grpc.CallOption) (*InstallSnapshotResponse, error) {
+	out := new(InstallSnapshotResponse)
+	err := c.cc.Invoke(ctx, "/metadata.RaftService/InstallSnapshot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RaftServiceServer is the server API for RaftService service.
+// All implementations must embed UnimplementedRaftServiceServer
+// for forward compatibility
+type RaftServiceServer interface {
+	RequestVote(context.Context, *VoteRequest) (*VoteResponse, error)
+	AppendEntries(context.Context, *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(context.Context, *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+	mustEmbedUnimplementedRaftServiceServer()
+}
+
+// UnimplementedRaftServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedRaftServiceServer struct {
+}
+
+func (UnimplementedRaftServiceServer) RequestVote(context.Context, *VoteRequest) (*VoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestVote not implemented")
+}
+func (UnimplementedRaftServiceServer) AppendEntries(context.Context, *AppendRequest) (*AppendResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendEntries not implemented")
+}
+func (UnimplementedRaftServiceServer) InstallSnapshot(context.Context, *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InstallSnapshot not implemented")
+}
+func (UnimplementedRaftServiceServer) mustEmbedUnimplementedRaftServiceServer() {}
+
+// UnsafeRaftServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RaftServiceServer will
+// result in compilation errors.
+type UnsafeRaftServiceServer interface {
+	mustEmbedUnimplementedRaftServiceServer()
+}
+
+func RegisterRaftServiceServer(s grpc.ServiceRegistrar, srv RaftServiceServer) {
+	s.RegisterService(&RaftService_ServiceDesc, srv)
+}
+
+func _RaftService_RequestVote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).RequestVote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.RaftService/RequestVote",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).RequestVote(ctx, req.(*VoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftService_AppendEntries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).AppendEntries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.RaftService/AppendEntries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).AppendEntries(ctx, req.(*AppendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftService_InstallSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InstallSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServiceServer).InstallSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.RaftService/InstallSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServiceServer).InstallSnapshot(ctx, req.(*InstallSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RaftService_ServiceDesc is the grpc.ServiceDesc for RaftService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RaftService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "metadata.RaftService",
+	HandlerType: (*RaftServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RequestVote",
+			Handler:    _RaftService_RequestVote_Handler,
+		},
+		{
+			MethodName: "AppendEntries",
+			Handler:    _RaftService_AppendEntries_Handler,
+		},
+		{
+			MethodName: "InstallSnapshot",
+			Handler:    _RaftService_InstallSnapshot_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "metadata.proto",
+}
+
+// ShardServiceClient is the client API for ShardService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ShardServiceClient interface {
+	MoveIn(ctx context.Context, in *MoveInRequest, opts ...grpc.CallOption) (*MoveInResponse, error)
+	ForgetMove(ctx context.Context, in *ForgetMoveRequest, opts ...grpc.CallOption) (*ForgetMoveResponse, error)
+}
+
+type shardServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShardServiceClient(cc grpc.ClientConnInterface) ShardServiceClient {
+	return &shardServiceClient{cc}
+}
+
+func (c *shardServiceClient) MoveIn(ctx context.Context, in *MoveInRequest, opts ...grpc.CallOption) (*MoveInResponse, error) {
+	out := new(MoveInResponse)
+	err := c.cc.Invoke(ctx, "/metadata.ShardService/MoveIn", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardServiceClient) ForgetMove(ctx context.Context, in *ForgetMoveRequest, opts ...grpc.CallOption) (*ForgetMoveResponse, error) {
+	out := new(ForgetMoveResponse)
+	err := c.cc.Invoke(ctx, "/metadata.ShardService/ForgetMove", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ShardServiceServer is the server API for ShardService service.
+// All implementations must embed UnimplementedShardServiceServer
+// for forward compatibility
+type ShardServiceServer interface {
+	MoveIn(context.Context, *MoveInRequest) (*MoveInResponse, error)
+	ForgetMove(context.Context, *ForgetMoveRequest) (*ForgetMoveResponse, error)
+	mustEmbedUnimplementedShardServiceServer()
+}
+
+// UnimplementedShardServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedShardServiceServer struct {
+}
+
+func (UnimplementedShardServiceServer) MoveIn(context.Context, *MoveInRequest) (*MoveInResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveIn not implemented")
+}
+func (UnimplementedShardServiceServer) ForgetMove(context.Context, *ForgetMoveRequest) (*ForgetMoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ForgetMove not implemented")
+}
+func (UnimplementedShardServiceServer) mustEmbedUnimplementedShardServiceServer() {}
+
+// UnsafeShardServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShardServiceServer will
+// result in compilation errors.
+type UnsafeShardServiceServer interface {
+	mustEmbedUnimplementedShardServiceServer()
+}
+
+func RegisterShardServiceServer(s grpc.ServiceRegistrar, srv ShardServiceServer) {
+	s.RegisterService(&ShardService_ServiceDesc, srv)
+}
+
+func _ShardService_MoveIn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveInRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardServiceServer).MoveIn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.ShardService/MoveIn",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardServiceServer).MoveIn(ctx, req.(*MoveInRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShardService_ForgetMove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ForgetMoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardServiceServer).ForgetMove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metadata.ShardService/ForgetMove",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardServiceServer).ForgetMove(ctx, req.(*ForgetMoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ShardService_ServiceDesc is the grpc.ServiceDesc for ShardService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ShardService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "metadata.ShardService",
+	HandlerType: (*ShardServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "MoveIn",
+			Handler:    _ShardService_MoveIn_Handler,
+		},
+		{
+			MethodName: "ForgetMove",
+			Handler:    _ShardService_ForgetMove_Handler,
+		},
+	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "metadata.proto",
 }
@@ -0,0 +1,125 @@
+package metadata
+
+import (
+	context "context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/tevintchuinkam/dfs/files"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ensures that MetaDataServer receives the heartbeats of the file servers
+var _ files.HeartbeatServiceServer = (*MetaDataServer)(nil)
+
+// Heartbeat records that a file server is alive. Servers that have not
+// registered are rejected with NotFound, which tells them to register again.
+func (s *MetaDataServer) Heartbeat(ctx context.Context, req *files.HeartbeatRequest) (*files.HeartbeatResponse, error) {
+	if req.Port <= 0 {
+		return nil, fmt.Errorf("invalid file server port %d", req.Port)
+	}
+	srv := s.registeredServer(int(req.Port))
+	if srv == nil {
+		return nil, status.Errorf(codes.NotFound, "file server %d is not registered", req.Port)
+	}
+	res := new(files.HeartbeatResponse)
+	for _, r := range s.registeredServers() {
+		res.Servers = append(res.Servers, int32(r.port))
+	}
+	srv.muLoad.Lock()
+	defer srv.muLoad.Unlock()
+	srv.lastHeartbeat = time.Now()
+	if req.Capacity > 0 {
+		srv.capacity = req.Capacity
+	}
+	srv.used = req.Used
+	s.markState(srv, FileServerState_ALIVE)
+	return res, nil
+}
+
+// stateOf returns the state of srv derived from its last heartbeat. Servers
+// that have never been heard from, e.g. in tests, are considered alive.
+// srv.muLoad must be held.
+func (s *MetaDataServer) stateOf(srv *fileServer, now time.Time) FileServerState {
+	if srv.lastHeartbeat.IsZero() {
+		return FileServerState_ALIVE
+	}
+	silent := now.Sub(srv.lastHeartbeat)
+	switch {
+	case silent > s.opts.deadAfter:
+		return FileServerState_DEAD
+	case silent > s.opts.suspectAfter:
+		return FileServerState_SUSPECT
+	default:
+		return FileServerState_ALIVE
+	}
+}
+
+// markState records the state of srv and logs when it changes.
+// srv.muLoad must be held.
+func (s *MetaDataServer) markState(srv *fileServer, state FileServerState) {
+	if srv.state == state {
+		return
+	}
+	switch state {
+	case FileServerState_ALIVE:
+		slog.Info("file server is alive", "port", srv.port, "was", srv.state)
+	default:
+		slog.Warn("file server stopped sending heartbeats", "port", srv.port, "state", state, "last_heartbeat", srv.lastHeartbeat)
+	}
+	srv.state = state
+	if state == FileServerState_DEAD {
+		s.triggerRepair()
+	}
+}
+
+// monitorFileServers periodically updates the state of the file servers so
+// that failures are noticed even while no files are created.
+func (s *MetaDataServer) monitorFileServers(stop <-chan struct{}) {
+	ticker := time.NewTicker(max(s.opts.suspectAfter/2, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.muFile.Lock()
+			servers := slices.Clone(s.fileServers)
+			s.muFile.Unlock()
+			for _, srv := range servers {
+				srv.muLoad.Lock()
+				s.markState(srv, s.stateOf(srv, now))
+				srv.muLoad.Unlock()
+			}
+		}
+	}
+}
+
+// ListFileServers returns the registered file servers and their state.
+func (s *MetaDataServer) ListFileServers(ctx context.Context, req *ListFileServersRequest) (*ListFileServersResponse, error) {
+	s.muFile.Lock()
+	servers := slices.Clone(s.fileServers)
+	s.muFile.Unlock()
+	now := time.Now()
+	res := new(ListFileServersResponse)
+	for _, srv := range servers {
+		srv.muLoad.Lock()
+		st := &FileServerStatus{
+			Port:     int32(srv.port),
+			State:    s.stateOf(srv, now),
+			Capacity: srv.capacity,
+			Used:     srv.used,
+			Load:     int64(srv.load),
+		}
+		if !srv.lastHeartbeat.IsZero() {
+			st.LastHeartbeat = timestamppb.New(srv.lastHeartbeat)
+		}
+		srv.muLoad.Unlock()
+		res.Servers = append(res.Servers, st)
+	}
+	return res, nil
+}
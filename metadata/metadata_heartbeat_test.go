@@ -0,0 +1,144 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tevintchuinkam/dfs/files"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFailureDetection(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir(), WithFailureDetection(100*time.Millisecond, 300*time.Millisecond))
+	defer s.Stop()
+	s.serverByPort(2).client = s.serverByPort(1).client
+
+	beat := func(port int32) {
+		t.Helper()
+		res, err := s.Heartbeat(ctx, &files.HeartbeatRequest{Port: port, Capacity: 1000, Used: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the file servers learn which servers they may copy files from
+		if got := fmt.Sprint(res.Servers); got != "[1 2]" {
+			t.Errorf("expected the heartbeat to list servers [1 2], got %s", got)
+		}
+	}
+	states := func() map[int32]FileServerState {
+		res, err := s.ListFileServers(ctx, &ListFileServersRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		states := make(map[int32]FileServerState)
+		for _, st := range res.Servers {
+			states[st.Port] = st.State
+			if st.Capacity != 1000 {
+				t.Errorf("expected server %d to report a capacity of 1000, got %d", st.Port, st.Capacity)
+			}
+		}
+		return states
+	}
+
+	beat(1)
+	beat(2)
+	// servers that did not register are rejected and not listed
+	if _, err := s.Heartbeat(ctx, &files.HeartbeatRequest{Port: 3, Capacity: 1000}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected the heartbeat of an unknown server to be rejected, got %v", err)
+	}
+	if got := fmt.Sprint(states()); got != "map[1:ALIVE 2:ALIVE]" {
+		t.Errorf("expected both servers to be alive, got %s", got)
+	}
+	// only server 1 keeps sending heartbeats
+	time.Sleep(150 * time.Millisecond)
+	beat(1)
+	if got := fmt.Sprint(states()); got != "map[1:ALIVE 2:SUSPECT]" {
+		t.Errorf("expected server 2 to be suspected, got %s", got)
+	}
+	time.Sleep(200 * time.Millisecond)
+	beat(1)
+	if got := fmt.Sprint(states()); got != "map[1:ALIVE 2:DEAD]" {
+		t.Errorf("expected server 2 to be dead, got %s", got)
+	}
+	for i := range 5 {
+		res, err := s.RegisterFileCreation(ctx, &RecRequest{Name: fmt.Sprintf("%d.txt", i), FileSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Port != 1 {
+			t.Errorf("file placed on dead server %d", res.Port)
+		}
+	}
+
+	// a dead server that comes back is used again
+	beat(2)
+	if got := fmt.Sprint(states()); got != "map[1:ALIVE 2:ALIVE]" {
+		t.Errorf("expected server 2 to be alive again, got %s", got)
+	}
+}
+
+func TestFailureDetectionDefaults(t *testing.T) {
+	o := defaultOptions(0)
+	WithFailureDetection(0, -time.Second)(&o)
+	if o.suspectAfter != defaultSuspectAfter || o.deadAfter != defaultDeadAfter {
+		t.Errorf("expected durations that are not positive to keep the defaults, got %v and %v", o.suspectAfter, o.deadAfter)
+	}
+}
+
+func TestFileServerRegistration(t *testing.T) {
+	ctx := context.Background()
+	ports := freePorts(t, 2)
+	dir := t.TempDir()
+	s := New(ports[0], WithDataDir(dir), WithSyncWrites(false))
+	s.Start(0)
+	defer func() { s.Stop() }()
+	fs := files.New(ports[1], files.WithRootDir(t.TempDir()))
+	go fs.Start(0)
+	defer fs.Stop()
+
+	r, err := NewRegistrar(ports[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	eventually(t, "the file server did not join", func() bool {
+		return fs.Join(ctx, r) == nil
+	})
+	if s.clientFor(ports[1]) == nil {
+		t.Fatal("the file server was not registered")
+	}
+
+	// a new capacity sent with a registration survives a restart
+	if err := r.Register(ctx, ports[1], 12345); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	s = New(ports[0], WithDataDir(dir), WithSyncWrites(false))
+	s.Start(0)
+	if srv := s.registeredServer(ports[1]); srv == nil || srv.capacity != 12345 {
+		t.Errorf("expected the file server to be registered with its new capacity, got %v", srv)
+	}
+
+	// a server the metadata service forgot registers again with its heartbeats
+	if _, err := s.DeregisterFileServer(ctx, &DeregisterFileServerRequest{Port: int32(ports[1])}); err != nil {
+		t.Fatal(err)
+	}
+	if s.clientFor(ports[1]) != nil {
+		t.Fatal("the file server was not deregistered")
+	}
+	if err := fs.StartHeartbeats(20*time.Millisecond, ports[0]); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the file server did not register again", func() bool {
+		return s.clientFor(ports[1]) != nil
+	})
+
+	// stopping the file server deregisters it
+	fs.Stop()
+	if s.clientFor(ports[1]) != nil {
+		t.Error("the stopped file server is still registered")
+	}
+}
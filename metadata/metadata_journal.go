@@ -0,0 +1,661 @@
+package metadata
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type opType string
+
+const (
+	opMkDir      opType = "mkdir"
+	opCreateFile opType = "create"
+	opUnlink     opType = "unlink"
+	opRmDir      opType = "rmdir"
+	opRename     opType = "rename"
+	opReset      opType = "reset"
+	opRegister   opType = "register"
+	opDeregister opType = "deregister"
+	opSetRepl    opType = "setreplication"
+	// replaces the file servers holding the data of a file after it was repaired
+	opReplicas   opType = "replicas"
+	opSetStorage opType = "setstorageclass"
+	// marks a file whose data has been written as complete
+	opCommit   opType = "commit"
+	opSetQuota opType = "setquota"
+	opSymlink  opType = "symlink"
+	// adds a hard link at NewPath to the file at Path
+	opLink        opType = "link"
+	opSetXattr    opType = "setxattr"
+	opRemoveXattr opType = "removexattr"
+	// freezes the subtree of the directory at Path as the snapshot Snapshot
+	opCreateSnapshot opType = "createsnapshot"
+	opDeleteSnapshot opType = "deletesnapshot"
+	// appended by a new leader, it does not change the namespace
+	opNoop opType = "noop"
+	// move the entry at Path to NewPath on another shard, see moveOut. The
+	// source journals the intent MoveID, the destination adds the entry
+	// from Record and the source removes it once that is done or drops the
+	// intent if the destination refused it.
+	opMoveOut   opType = "moveout"
+	opMoveIn    opType = "movein"
+	opMoveDone  opType = "movedone"
+	opMoveAbort opType = "moveabort"
+	// drops the id of a move received from another shard
+	opMoveForget opType = "moveforget"
+)
+
+// xattrFlag makes setting an extended attribute fail if it already exists
+// or if it does not.
+type xattrFlag int
+
+const (
+	xattrCreate xattrFlag = iota + 1
+	xattrReplace
+)
+
+// logEntry is a single mutation of the namespace. Every choice that is not
+// deterministic (e.g. the file server picked for a new file) is resolved
+// before the entry is written so that replaying it yields the same state.
+type logEntry struct {
+	Seq  uint64 `json:"seq"`
+	Op   opType `json:"op"`
+	Path string `json:"path,omitempty"`
+	Size int64  `json:"size,omitempty"`
+	Port int    `json:"port,omitempty"`
+	// bytes a registering file server can store
+	Capacity int64 `json:"capacity,omitempty"`
+	// all file servers holding the data of a new file, the first one is Port
+	Replicas []int `json:"replicas,omitempty"`
+	// replication factor set on a directory or the copies to keep of a new file
+	Replication int `json:"replication,omitempty"`
+	// storage class, erasure coding and striping set on a directory or used
+	// for a new file, whose fragments are on Fragments
+	StorageClass StorageClass `json:"storageClass,omitempty"`
+	DataShards   int          `json:"dataShards,omitempty"`
+	ParityShards int          `json:"parityShards,omitempty"`
+	StripeSize   int64        `json:"stripeSize,omitempty"`
+	StripeCount  int          `json:"stripeCount,omitempty"`
+	Fragments    []int        `json:"fragments,omitempty"`
+	// a new file waits for its commit, an unlink only removes the file if it
+	// is still waiting
+	Pending bool `json:"pending,omitempty"`
+	// checksum of the data of a committed file
+	Checksum string `json:"checksum,omitempty"`
+	// quota set on a directory
+	QuotaBytes   int64 `json:"quotaBytes,omitempty"`
+	QuotaEntries int64 `json:"quotaEntries,omitempty"`
+	// remove the whole subtree of a directory
+	Recursive bool `json:"recursive,omitempty"`
+	// target of a rename or new name of a hard link
+	NewPath string `json:"newPath,omitempty"`
+	// target of a symbolic link
+	Target string `json:"target,omitempty"`
+	// extended attribute set or removed
+	XattrKey   string    `json:"xattrKey,omitempty"`
+	XattrValue []byte    `json:"xattrValue,omitempty"`
+	XattrFlag  xattrFlag `json:"xattrFlag,omitempty"`
+	// name of a snapshot created or deleted
+	Snapshot string `json:"snapshot,omitempty"`
+	// move between shards, the shard an entry is moved to and the entry
+	// moved in from another shard
+	MoveID   string      `json:"moveId,omitempty"`
+	Shard    int         `json:"shard,omitempty"`
+	Record   *fileRecord `json:"record,omitempty"`
+	ObjectID string      `json:"objectId,omitempty"`
+	Mode     uint32      `json:"mode,omitempty"`
+	Owner    string      `json:"owner,omitempty"`
+	Group    string      `json:"group,omitempty"`
+	// unix nanoseconds at which the mutation happened
+	Time int64 `json:"time,omitempty"`
+
+	// files removed from the namespace by the entry and, out of them, the
+	// ones whose data is not used by another name anymore. They are only set
+	// when the entry is applied and not persisted.
+	removed  []*fileInfo
+	orphaned []*fileInfo
+}
+
+// time returns when the mutation described by the entry happened.
+func (e *logEntry) time() time.Time {
+	return time.Unix(0, e.Time)
+}
+
+// fileRecord is the on-disk representation of a fileInfo in a snapshot.
+type fileRecord struct {
+	Name     string `json:"name"`
+	IsDir    bool   `json:"isDir,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Replicas []int  `json:"replicas,omitempty"`
+	ObjectID string `json:"objectId,omitempty"`
+	Mode     uint32 `json:"mode,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Group    string `json:"group,omitempty"`
+	// replication factor of a directory or the copies to keep of a file
+	Replication  int          `json:"replication,omitempty"`
+	StorageClass StorageClass `json:"storageClass,omitempty"`
+	DataShards   int          `json:"dataShards,omitempty"`
+	ParityShards int          `json:"parityShards,omitempty"`
+	StripeSize   int64        `json:"stripeSize,omitempty"`
+	StripeCount  int          `json:"stripeCount,omitempty"`
+	Fragments    []int        `json:"fragments,omitempty"`
+	Pending      bool         `json:"pending,omitempty"`
+	Checksum     string       `json:"checksum,omitempty"`
+	QuotaBytes   int64        `json:"quotaBytes,omitempty"`
+	QuotaEntries int64        `json:"quotaEntries,omitempty"`
+	// target of a symbolic link, the names of a file with hard links are
+	// stored separately with the same object id
+	Target string            `json:"target,omitempty"`
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+	// position of the entry in its directory and the one given to the next
+	// entry of a directory, kept so that listings continue where they were
+	Seq     uint64 `json:"seq,omitempty"`
+	NextSeq uint64 `json:"nextSeq,omitempty"`
+	// unix nanoseconds
+	CreateTime int64         `json:"createTime,omitempty"`
+	ModifyTime int64         `json:"modifyTime,omitempty"`
+	AccessTime int64         `json:"accessTime,omitempty"`
+	Entries    []*fileRecord `json:"entries,omitempty"`
+	// snapshots of a directory, ordered by name
+	Snapshots []*snapshotRecord `json:"snapshots,omitempty"`
+	// nodes shared by several trees are stored in full once with an ID,
+	// wherever else they appear only their Ref, Name and Seq are
+	ID  int `json:"id,omitempty"`
+	Ref int `json:"ref,omitempty"`
+}
+
+// snapshotRecord is the on-disk representation of a dirSnapshot.
+type snapshotRecord struct {
+	Name    string      `json:"name"`
+	Created int64       `json:"created"`
+	Root    *fileRecord `json:"root"`
+}
+
+type snapshot struct {
+	// Seq is the sequence number of the last entry contained in the snapshot
+	Seq  uint64      `json:"seq"`
+	Root *fileRecord `json:"root"`
+	// ports of the registered file servers
+	Servers []int `json:"servers,omitempty"`
+	// moves to other shards that are not finished and the moves received
+	// from other shards whose source did not finish them yet
+	Moves   []*move  `json:"moves,omitempty"`
+	MovedIn []string `json:"movedIn,omitempty"`
+}
+
+const (
+	snapshotFile = "snapshot.json"
+	walPrefix    = "wal-"
+	walSuffix    = ".log"
+)
+
+// journal is a write-ahead log of namespace mutations. The log is split into
+// segments, a new one being started every time a snapshot is taken so that
+// the segments covered by the snapshot can be removed afterwards. In a
+// cluster the raft log holds the entries instead, and the journal only keeps
+// the snapshots and the sequence number of the last entry applied.
+type journal struct {
+	dir  string
+	sync bool
+
+	mu      sync.Mutex
+	seq     uint64
+	segment *os.File
+	w       *bufio.Writer
+	// number of entries appended since the last snapshot
+	sinceSnapshot int
+}
+
+func openJournal(dir string, sync bool) (*journal, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &journal{
+		dir:  dir,
+		sync: sync,
+	}, nil
+}
+
+func walName(startSeq uint64) string {
+	return fmt.Sprintf("%s%020d%s", walPrefix, startSeq, walSuffix)
+}
+
+// segments returns the wal segments in the order they were written.
+func (j *journal) segments() ([]string, error) {
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), walPrefix) && strings.HasSuffix(e.Name(), walSuffix) {
+			segs = append(segs, e.Name())
+		}
+	}
+	// the zero padded sequence number makes lexical order equal log order
+	sort.Strings(segs)
+	return segs, nil
+}
+
+// loadSnapshot returns the latest snapshot or nil if none has been taken yet.
+func (j *journal) loadSnapshot() (*snapshot, error) {
+	b, err := os.ReadFile(filepath.Join(j.dir, snapshotFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snap := new(snapshot)
+	if err := json.Unmarshal(b, snap); err != nil {
+		return nil, fmt.Errorf("corrupt snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+// replay calls f for every journaled entry with a sequence number greater
+// than after and leaves the journal ready for appending.
+func (j *journal) replay(after uint64, f func(*logEntry) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq = after
+	segs, err := j.segments()
+	if err != nil {
+		return err
+	}
+	for i, name := range segs {
+		last := i == len(segs)-1
+		if err := j.replaySegment(name, last, f); err != nil {
+			return err
+		}
+	}
+	return j.startSegment()
+}
+
+func (j *journal) replaySegment(name string, last bool, f func(*logEntry) error) error {
+	p := filepath.Join(j.dir, name)
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		e := new(logEntry)
+		if err == io.EOF || json.Unmarshal(line, e) != nil {
+			// a torn write can only happen at the very end of the log
+			if !last {
+				return fmt.Errorf("corrupt journal segment %s at offset %d", name, offset)
+			}
+			slog.Warn("truncating torn journal entry", "segment", name, "offset", offset)
+			return os.Truncate(p, offset)
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		if e.Seq <= j.seq {
+			continue
+		}
+		j.seq = e.Seq
+		if err := f(e); err != nil {
+			// the entry failed when it was first applied as well
+			slog.Debug("journal entry failed on replay", "seq", e.Seq, "op", e.Op, "err", err)
+		}
+	}
+}
+
+// startSegment closes the current segment and starts a new one.
+// j.mu must be held.
+func (j *journal) startSegment() error {
+	if err := j.closeSegment(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(j.dir, walName(j.seq+1)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	j.segment = f
+	j.w = bufio.NewWriter(f)
+	return nil
+}
+
+func (j *journal) closeSegment() error {
+	if j.segment == nil {
+		return nil
+	}
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	if err := j.segment.Close(); err != nil {
+		return err
+	}
+	j.segment = nil
+	j.w = nil
+	return nil
+}
+
+// append assigns the next sequence number to e and writes it to the log.
+// It returns once the entry is durable.
+func (j *journal) append(e *logEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.segment == nil {
+		return errors.New("journal is closed")
+	}
+	if e.Seq == 0 {
+		e.Seq = j.seq + 1
+	}
+	if e.Seq <= j.seq {
+		return fmt.Errorf("journal entry %d is older than the last one (%d)", e.Seq, j.seq)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if _, err := j.w.Write(b); err != nil {
+		return err
+	}
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	if j.sync {
+		if err := j.segment.Sync(); err != nil {
+			return err
+		}
+	}
+	j.seq = e.Seq
+	j.sinceSnapshot++
+	return nil
+}
+
+// advance records that e was applied without writing it, for entries that
+// are durable in the raft log, which assigned their sequence number.
+func (j *journal) advance(e *logEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if e.Seq <= j.seq {
+		return fmt.Errorf("journal entry %d is older than the last one (%d)", e.Seq, j.seq)
+	}
+	j.seq = e.Seq
+	j.sinceSnapshot++
+	return nil
+}
+
+func (j *journal) pending() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.sinceSnapshot
+}
+
+// rotate starts a new segment and returns the sequence number of the last
+// entry in the previous ones. The caller must make sure no entries are
+// appended concurrently so that the returned sequence number matches the
+// state that is about to be snapshotted.
+func (j *journal) rotate() (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.segment == nil {
+		return 0, errors.New("journal is closed")
+	}
+	if err := j.startSegment(); err != nil {
+		return 0, err
+	}
+	j.sinceSnapshot = 0
+	return j.seq, nil
+}
+
+// writeSnapshot atomically replaces the snapshot on disk and removes the
+// segments that only contain entries covered by it.
+func (j *journal) writeSnapshot(snap *snapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(j.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(j.dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	segs, err := j.segments()
+	if err != nil {
+		return err
+	}
+	for _, name := range segs {
+		if name >= walName(snap.Seq+1) {
+			break
+		}
+		if err := os.Remove(filepath.Join(j.dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSnapshot returns the encoded snapshot or nil if none has been taken yet.
+func (j *journal) readSnapshot() ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(j.dir, snapshotFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// install replaces the whole journal with snap, which was taken by another
+// metadata server.
+func (j *journal) install(snap *snapshot) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.segment == nil {
+		return errors.New("journal is closed")
+	}
+	if err := j.closeSegment(); err != nil {
+		return err
+	}
+	// removes all segments, they only hold entries up to snap.Seq
+	if err := j.writeSnapshot(snap); err != nil {
+		return err
+	}
+	j.seq = snap.Seq
+	j.sinceSnapshot = 0
+	return j.startSegment()
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.closeSegment()
+}
+
+// toRecord converts the tree rooted at f into its snapshot representation.
+func toRecord(f *fileInfo) *fileRecord {
+	return recordTree(f, make(map[*fileInfo]int))
+}
+
+// recordTree returns the record of the tree rooted at f. ids holds the
+// shared nodes that were already recorded.
+func recordTree(f *fileInfo, ids map[*fileInfo]int) *fileRecord {
+	if id, ok := ids[f]; ok {
+		return &fileRecord{Name: f.name, Seq: f.seq, Ref: id}
+	}
+	r := &fileRecord{
+		Name:         f.name,
+		IsDir:        f.isDir,
+		Size:         f.size,
+		Port:         f.port,
+		Replicas:     f.replicas,
+		Mode:         uint32(f.mode),
+		Owner:        f.owner,
+		Group:        f.group,
+		Replication:  f.replication,
+		StorageClass: f.storageClass,
+		DataShards:   f.dataShards,
+		ParityShards: f.parityShards,
+		StripeSize:   f.stripeSize,
+		StripeCount:  f.stripeCount,
+		Fragments:    f.fragments,
+		Pending:      f.pending,
+		Checksum:     f.checksum,
+		QuotaBytes:   f.quotaBytes,
+		QuotaEntries: f.quotaEntries,
+		Target:       f.target,
+		Xattrs:       f.xattrs.get(),
+		Seq:          f.seq,
+		NextSeq:      f.nextSeq,
+		CreateTime:   f.createTime.UnixNano(),
+		ModifyTime:   f.modified().UnixNano(),
+		AccessTime:   f.accessed().UnixNano(),
+	}
+	if !f.isDir {
+		r.ObjectID = f.objectID
+	}
+	if f.shared > 0 {
+		// before the entries, a snapshot below may lead back to f
+		r.ID = len(ids) + 1
+		ids[f] = r.ID
+	}
+	for _, e := range f.subEntries {
+		r.Entries = append(r.Entries, recordTree(e, ids))
+	}
+	names := make([]string, 0, len(f.snaps))
+	for name := range f.snaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		snap := f.snaps[name]
+		r.Snapshots = append(r.Snapshots, &snapshotRecord{
+			Name:    name,
+			Created: snap.created.UnixNano(),
+			Root:    recordTree(snap.root, ids),
+		})
+	}
+	return r
+}
+
+// fromRecord rebuilds the tree stored in r.
+func fromRecord(r *fileRecord, fullPath string) *fileInfo {
+	return loadTree(r, fullPath, make(map[int]*fileInfo))
+}
+
+// loadTree rebuilds the tree stored in r. nodes holds the shared nodes
+// rebuilt so far by their ID. It returns nil for a reference to a node that
+// is not known.
+func loadTree(r *fileRecord, fullPath string, nodes map[int]*fileInfo) *fileInfo {
+	if r.Ref > 0 {
+		f, ok := nodes[r.Ref]
+		if !ok {
+			slog.Error("unknown shared entry in snapshot", "path", fullPath, "ref", r.Ref)
+			return nil
+		}
+		f.shared++
+		return f
+	}
+	f := &fileInfo{
+		name:         r.Name,
+		isDir:        r.IsDir,
+		size:         r.Size,
+		port:         r.Port,
+		replicas:     r.Replicas,
+		replication:  r.Replication,
+		storageClass: r.StorageClass,
+		dataShards:   r.DataShards,
+		parityShards: r.ParityShards,
+		stripeSize:   r.StripeSize,
+		stripeCount:  r.StripeCount,
+		fragments:    r.Fragments,
+		pending:      r.Pending,
+		checksum:     r.Checksum,
+		quotaBytes:   r.QuotaBytes,
+		quotaEntries: r.QuotaEntries,
+		target:       r.Target,
+		xattrs:       newXattrSet(r.Xattrs),
+		fullPath:     fullPath,
+		objectID:     r.ObjectID,
+		mode:         fs.FileMode(r.Mode),
+		owner:        r.Owner,
+		group:        r.Group,
+		createTime:   time.Unix(0, r.CreateTime),
+		modTime:      r.ModifyTime,
+		accessTime:   r.AccessTime,
+	}
+	if !f.isDir && !f.isSymlink() && f.objectID == "" {
+		// files created before object ids existed are stored under their path
+		f.objectID = fullPath
+	}
+	if f.isDir {
+		f.mu = new(sync.RWMutex)
+		f.index = make(map[string]*fileInfo, len(r.Entries))
+	}
+	if r.ID > 0 {
+		nodes[r.ID] = f
+	}
+	for _, e := range r.Entries {
+		child := loadTree(e, path.Join(fullPath, e.Name), nodes)
+		if child == nil {
+			continue
+		}
+		if err := f.insert(child); err != nil {
+			slog.Error("duplicate entry in snapshot", "dir", fullPath, "err", err)
+			continue
+		}
+		if e.Seq > 0 {
+			child.seq = e.Seq
+			f.nextSeq = max(f.nextSeq, e.Seq)
+		}
+		if child.pending {
+			f.pendingEntries++
+		}
+		// the usage of directories is not stored, it is added up again
+		bytes, entries := child.usage()
+		f.usedBytes += bytes
+		f.usedEntries += entries
+	}
+	f.nextSeq = max(f.nextSeq, r.NextSeq)
+	for _, sr := range r.Snapshots {
+		root := loadTree(sr.Root, path.Join(fullPath, snapDir, sr.Name), nodes)
+		if root == nil {
+			continue
+		}
+		if f.snaps == nil {
+			f.snaps = make(map[string]*dirSnapshot, len(r.Snapshots))
+		}
+		f.snaps[sr.Name] = &dirSnapshot{root: root, created: time.Unix(0, sr.Created)}
+	}
+	return f
+}
@@ -0,0 +1,60 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	s := newTestServer(t, dir, WithSnapshotInterval(5))
+	for i := range 4 {
+		d := fmt.Sprintf("dir-%d", i)
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := createFile(ctx, s, &RecRequest{Name: d + "/file.txt", FileSize: 10}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// one snapshot in the middle of the log, the rest has to be replayed
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "dir-0/sub"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+
+	s = newTestServer(t, dir)
+	for i := range 4 {
+		p := fmt.Sprintf("dir-%d/file.txt", i)
+		loc, err := s.GetLocation(ctx, &LocRequest{Name: p})
+		if err != nil {
+			t.Fatalf("%s was not restored: %v", p, err)
+		}
+		if loc.Port != 1 {
+			t.Errorf("expected %s on port 1, got %d", p, loc.Port)
+		}
+	}
+	if !isDir(s.rootDir, "dir-0/sub") {
+		t.Error("dir-0/sub was not restored")
+	}
+	if load := s.serverByPort(1).load; load != 40 {
+		t.Errorf("expected a load of 40 bytes, got %d", load)
+	}
+
+	if _, err := s.DeleteAllData(ctx, &DeleteAllDataRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	if entryAlreadyExists(s.rootDir, "dir-0") {
+		t.Error("namespace was restored after all data was deleted")
+	}
+}
@@ -0,0 +1,158 @@
+package metadata
+
+import (
+	context "context"
+	"fmt"
+	"log/slog"
+	"path"
+	"time"
+)
+
+// maximum number of fragments a file is split into, the limit of Reed-Solomon
+// codes over GF(2^8)
+const maxFragments = 256
+
+// FragmentName returns the name fragment i of the erasure coded or striped
+// data stored under objectID has on its file server.
+func FragmentName(objectID string, i int) string {
+	return fmt.Sprintf("%s.%d", objectID, i)
+}
+
+// fragmentSize returns the size of each fragment when size bytes are split
+// into n fragments.
+func fragmentSize(size int64, n int) int64 {
+	return (size + int64(n) - 1) / int64(n)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// fragmented reports whether the data of f is split into fragments, either
+// because it is erasure coded or striped.
+func (f *fileInfo) fragmented() bool {
+	return len(f.fragments) > 0
+}
+
+// objects returns the file servers holding the data of f and the name the
+// data has on each of them.
+func (f *fileInfo) objects() (ports []int, names []string) {
+	if f.fragmented() {
+		for i, port := range f.fragments {
+			ports = append(ports, port)
+			names = append(names, FragmentName(f.objectID, i))
+		}
+		return ports, names
+	}
+	for _, port := range f.servers() {
+		ports = append(ports, port)
+		names = append(names, f.objectID)
+	}
+	return ports, names
+}
+
+// layout is how the data of new files below a directory is stored.
+type layout struct {
+	class        StorageClass
+	dataShards   int
+	parityShards int
+	stripeSize   int64
+	stripeCount  int
+}
+
+// SetStorageClass sets how the data of files created below a directory is
+// stored. Files that already exist are not converted.
+func (s *MetaDataServer) SetStorageClass(ctx context.Context, req *SetStorageClassRequest) (*SetStorageClassResponse, error) {
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	e := &logEntry{
+		Op:           opSetStorage,
+		Path:         p,
+		StorageClass: req.StorageClass,
+		Time:         time.Now().UnixNano(),
+	}
+	switch req.StorageClass {
+	case StorageClass_INHERIT, StorageClass_REPLICATED:
+	case StorageClass_ERASURE_CODED:
+		if req.DataShards <= 0 || req.ParityShards < 0 || req.DataShards+req.ParityShards > maxFragments {
+			return nil, fmt.Errorf("invalid erasure coding with %d data and %d parity fragments", req.DataShards, req.ParityShards)
+		}
+		e.DataShards = int(req.DataShards)
+		e.ParityShards = int(req.ParityShards)
+	case StorageClass_STRIPED:
+		if req.StripeSize <= 0 || req.StripeCount == 0 || req.StripeCount < -1 {
+			return nil, fmt.Errorf("invalid striping over %d servers in chunks of %d bytes", req.StripeCount, req.StripeSize)
+		}
+		e.StripeSize = req.StripeSize
+		e.StripeCount = int(req.StripeCount)
+	default:
+		return nil, fmt.Errorf("unknown storage class %v", req.StorageClass)
+	}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to set storage class", "dir", e.Path, "error", err)
+		return nil, err
+	}
+	return &SetStorageClassResponse{}, nil
+}
+
+func (s *MetaDataServer) applySetStorageClass(e *logEntry, persist func() error) error {
+	d, err := s.own(e.Path)
+	if err != nil || !d.isDir {
+		return fmt.Errorf("the directory %s doesn't exist", e.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	d.storageClass = e.StorageClass
+	d.dataShards = e.DataShards
+	d.parityShards = e.ParityShards
+	d.stripeSize = e.StripeSize
+	d.stripeCount = e.StripeCount
+	return nil
+}
+
+// layoutFor returns how the data of a new file at p is stored. The closest
+// directory with a storage class decides, files are replicated by default.
+func (s *MetaDataServer) layoutFor(p string) layout {
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		if d, err := s.rootDir.walkTo(dir); err == nil && d.storageClass != StorageClass_INHERIT {
+			return layout{
+				class:        d.storageClass,
+				dataShards:   d.dataShards,
+				parityShards: d.parityShards,
+				stripeSize:   d.stripeSize,
+				stripeCount:  d.stripeCount,
+			}
+		}
+		if dir == "." || dir == "/" {
+			return layout{class: StorageClass_REPLICATED}
+		}
+	}
+}
+
+// placeFragments picks a distinct file server for each of the fragments of a
+// new erasure coded file.
+func (s *MetaDataServer) placeFragments(p string, size int64, dataShards, parityShards int, candidates []Server) ([]int, error) {
+	n := dataShards + parityShards
+	if n > len(candidates) {
+		return nil, fmt.Errorf("the %d fragments of %s need as many file servers but only %d are alive", n, p, len(candidates))
+	}
+	return s.placeReplicas(p, fragmentSize(size, dataShards), n, candidates)
+}
+
+// placeStripes picks the file servers the stripe objects of a new file are
+// stored on. If fewer servers are alive than stripes are wanted, the file is
+// striped over all of them.
+func (s *MetaDataServer) placeStripes(p string, size int64, count int, candidates []Server) ([]int, error) {
+	if count < 0 || count > len(candidates) {
+		count = len(candidates)
+	}
+	return s.placeReplicas(p, fragmentSize(size, count), count, candidates)
+}
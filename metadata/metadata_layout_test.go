@@ -0,0 +1,115 @@
+package metadata
+
+import (
+	"context"
+	"testing"
+)
+
+func TestErasureCoding(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir())
+	defer s.Stop()
+	fakes := addFileServers(s, 6)
+	for _, d := range []string{"cold", "cold/hot"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.SetStorageClass(ctx, &SetStorageClassRequest{Name: "cold", StorageClass: StorageClass_ERASURE_CODED, DataShards: 4, ParityShards: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetStorageClass(ctx, &SetStorageClassRequest{Name: "cold/hot", StorageClass: StorageClass_REPLICATED}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := createFile(ctx, s, &RecRequest{Name: "cold/f", FileSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.DataShards != 4 || rec.ParityShards != 2 || len(rec.Fragments) != 6 || len(rec.Replicas) != 0 {
+		t.Fatalf("expected 4+2 fragments, got %v", rec)
+	}
+	seen := make(map[int32]bool)
+	for _, port := range rec.Fragments {
+		if seen[port] {
+			t.Errorf("two fragments placed on server %d", port)
+		}
+		seen[port] = true
+		if load := s.serverByPort(int(port)).load; load != 25 {
+			t.Errorf("expected server %d to hold 25 bytes, got %d", port, load)
+		}
+	}
+	loc, err := s.GetLocation(ctx, &LocRequest{Name: "cold/f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Size != 100 || len(loc.Fragments) != 6 || loc.DataShards != 4 {
+		t.Errorf("unexpected location %v", loc)
+	}
+
+	// directories below can switch back to replication
+	rec, err = createFile(ctx, s, &RecRequest{Name: "cold/hot/g", FileSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.DataShards != 0 || len(rec.Replicas) != 2 {
+		t.Errorf("expected cold/hot/g to be replicated, got %v", rec)
+	}
+
+	// every fragment is deleted with the file
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "cold/f"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, port := range loc.Fragments {
+		fake := fakes[int(port)]
+		if len(fake.deleted) != 1 || fake.deleted[0] != FragmentName(loc.ObjectId, i) {
+			t.Errorf("expected fragment %d to be deleted from %d, got %v", i, port, fake.deleted)
+		}
+	}
+
+	// fragments need distinct servers
+	s.serverByPort(6).client = nil
+	if _, err := createFile(ctx, s, &RecRequest{Name: "cold/h", FileSize: 1}); err == nil {
+		t.Error("placed 6 fragments on 5 file servers")
+	}
+}
+
+func TestStriping(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir())
+	defer s.Stop()
+	for port := 2; port <= 3; port++ {
+		s.serverByPort(port).client = s.serverByPort(1).client
+	}
+	for _, d := range []string{"a", "b", "c"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.SetStorageClass(ctx, &SetStorageClassRequest{Name: "a", StorageClass: StorageClass_STRIPED, StripeSize: 0, StripeCount: 2}); err == nil {
+		t.Error("accepted stripes of 0 bytes")
+	}
+	for dir, count := range map[string]int32{"a": 2, "b": -1, "c": 5} {
+		if _, err := s.SetStorageClass(ctx, &SetStorageClassRequest{Name: dir, StorageClass: StorageClass_STRIPED, StripeSize: 1024, StripeCount: count}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// more stripes than servers are cut down to the servers there are
+	for dir, want := range map[string]int{"a": 2, "b": 3, "c": 3} {
+		rec, err := createFile(ctx, s, &RecRequest{Name: dir + "/f", FileSize: 4096})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(rec.StripeCount) != want || len(rec.Fragments) != want || rec.StripeSize != 1024 {
+			t.Errorf("expected %s/f to be striped over %d servers, got %v", dir, want, rec)
+		}
+		loc, err := s.GetLocation(ctx, &LocRequest{Name: dir + "/f"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loc.StripeCount != rec.StripeCount || len(loc.Fragments) != want || loc.Size != 4096 {
+			t.Errorf("unexpected stripe map for %s/f: %v", dir, loc)
+		}
+	}
+}
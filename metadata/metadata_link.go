@@ -0,0 +1,338 @@
+package metadata
+
+import (
+	context "context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxSymlinks is the number of symbolic links followed while resolving a
+// path before it is considered a loop, like ELOOP.
+const maxSymlinks = 40
+
+// linkGroup is shared by the entries holding the same file data: the names
+// of a file with hard links and the copies of them kept by snapshots.
+type linkGroup struct {
+	// number of names in the live tree, accessed atomically
+	count int32
+	// all entries holding the data, which is deleted once the last of them
+	// is gone. Guarded by MetaDataServer.muLocation.
+	holders []*fileInfo
+}
+
+// isSymlink reports whether f is a symbolic link.
+func (f *fileInfo) isSymlink() bool {
+	return f.mode&fs.ModeSymlink != 0
+}
+
+// linkCount returns the number of names of f. A file only kept by snapshots
+// still has its own name there.
+func (f *fileInfo) linkCount() int {
+	if f.links == nil {
+		return 1
+	}
+	return max(int(atomic.LoadInt32(&f.links.count)), 1)
+}
+
+// follow returns p with the symbolic links in its directories replaced by
+// their targets, and the one in its last component too if last is set.
+// Entries that do not exist are left as they are so that new entries can be
+// created below linked directories. Links that lead into another shard
+// fail with a ForeignPathError.
+func (s *MetaDataServer) follow(p string, last bool) (string, error) {
+	resolved, err := s.followAcross(p, last)
+	if err != nil {
+		return "", err
+	}
+	return resolved, s.local(p, resolved)
+}
+
+// followAcross is follow for names that may be owned by another shard, like
+// the new name of a rename.
+func (s *MetaDataServer) followAcross(p string, last bool) (string, error) {
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	return followLinks(s.rootDir, p, last)
+}
+
+func followLinks(root *fileInfo, p string, last bool) (string, error) {
+	hops := 0
+	parts := strings.Split(path.Clean(p), "/")
+	resolved := "."
+	dir := root
+	for i := 0; i < len(parts); i++ {
+		part := parts[i]
+		if part == "" || part == "." {
+			continue
+		}
+		rest := path.Join(parts[i+1:]...)
+		if dir == nil {
+			// below an entry that does not exist or is not a directory
+			return path.Join(resolved, part, rest), nil
+		}
+		dir.rlock()
+		next := dir.lookup(part)
+		dir.runlock()
+		if next != nil && next.isSymlink() && (rest != "" || last) {
+			hops++
+			if hops > maxSymlinks {
+				return "", fmt.Errorf("too many levels of symbolic links in %s", p)
+			}
+			base := resolved
+			if strings.HasPrefix(next.target, "/") {
+				base = "."
+			}
+			// the target is resolved again from the root
+			parts = strings.Split(path.Join(base, next.target, rest), "/")
+			i = -1
+			resolved = "."
+			dir = root
+			continue
+		}
+		resolved = path.Join(resolved, part)
+		dir = nil
+		if next != nil && next.isDir {
+			dir = next
+		}
+	}
+	return resolved, nil
+}
+
+// lookupFollow returns the entry at p, following symbolic links.
+func (s *MetaDataServer) lookupFollow(p string) (*fileInfo, error) {
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	resolved, err := followLinks(s.rootDir, p, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.local(p, resolved); err != nil {
+		return nil, err
+	}
+	return s.rootDir.walkTo(resolved)
+}
+
+// Symlink creates a symbolic link. The target is stored as it is and only
+// resolved when the link is followed.
+func (s *MetaDataServer) Symlink(ctx context.Context, req *SymlinkRequest) (*SymlinkResponse, error) {
+	if req.Target == "" {
+		return nil, errors.New("empty symbolic link target")
+	}
+	p, err := s.follow(req.Name, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkQuota(path.Dir(p), int64(len(req.Target)), 1); err != nil {
+		slog.Error("could not create symbolic link", "link", p, "error", err)
+		return nil, err
+	}
+	e := &logEntry{
+		Op:     opSymlink,
+		Path:   p,
+		Target: req.Target,
+		Owner:  req.Owner,
+		Group:  req.Group,
+		Time:   time.Now().UnixNano(),
+	}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to create symbolic link", "link", p, "error", err)
+		return nil, err
+	}
+	return &SymlinkResponse{}, nil
+}
+
+func (s *MetaDataServer) applySymlink(e *logEntry, persist func() error) error {
+	parent, err := s.lockParent(e.Path)
+	if err != nil {
+		return err
+	}
+	defer parent.unlock()
+	if parent.lookup(path.Base(e.Path)) != nil {
+		return EntryAlreadyExistsError{e.Path}
+	}
+	size := int64(len(e.Target))
+	if err := s.reserveQuota(path.Dir(e.Path), size, 1); err != nil {
+		return err
+	}
+	if err := persist(); err != nil {
+		s.addUsage(path.Dir(e.Path), -size, -1)
+		return err
+	}
+	t := e.time()
+	f := &fileInfo{
+		name:       path.Base(e.Path),
+		fullPath:   e.Path,
+		size:       size,
+		mode:       fs.ModeSymlink | 0o777,
+		target:     e.Target,
+		modTime:    e.Time,
+		createTime: t,
+		accessTime: e.Time,
+		owner:      e.Owner,
+		group:      e.Group,
+	}
+	if err := parent.insert(f); err != nil {
+		return err
+	}
+	parent.modify(t)
+	return nil
+}
+
+// Readlink returns the target of a symbolic link.
+func (s *MetaDataServer) Readlink(ctx context.Context, req *ReadlinkRequest) (*ReadlinkResponse, error) {
+	p, err := s.follow(req.Name, false)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.resolve(p)
+	if err != nil {
+		return nil, fmt.Errorf("the entry %s doesn't exist", p)
+	}
+	if !f.isSymlink() {
+		return nil, fmt.Errorf("%s is not a symbolic link", p)
+	}
+	return &ReadlinkResponse{Target: f.target}, nil
+}
+
+// Link adds another name to a file. Both names share the data, which is
+// only deleted once the last of them is removed. The names have to be on
+// the same shard, which is the one that counts them.
+func (s *MetaDataServer) Link(ctx context.Context, req *LinkRequest) (*LinkResponse, error) {
+	oldName, err := s.follow(req.OldName, true)
+	if err != nil {
+		return nil, err
+	}
+	newName, err := s.followAcross(req.NewName, false)
+	if err != nil {
+		return nil, err
+	}
+	if !s.owns(newName) {
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot link %s to %s, hard links cannot span metadata shards", newName, oldName)
+	}
+	src, err := s.resolve(oldName)
+	if err != nil || src.pending {
+		return nil, fmt.Errorf("the file %s doesn't exist", oldName)
+	}
+	if src.isDir {
+		return nil, fmt.Errorf("%s is a directory", oldName)
+	}
+	if err := s.checkQuota(path.Dir(newName), src.size, 1); err != nil {
+		slog.Error("could not create link", "file", oldName, "link", newName, "error", err)
+		return nil, err
+	}
+	e := &logEntry{
+		Op:       opLink,
+		Path:     oldName,
+		NewPath:  newName,
+		ObjectID: src.objectID,
+		Time:     time.Now().UnixNano(),
+	}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to create link", "file", oldName, "link", newName, "error", err)
+		return nil, err
+	}
+	return &LinkResponse{}, nil
+}
+
+// applyLink runs with muDir held exclusively since it changes the existing
+// name of the file as well.
+func (s *MetaDataServer) applyLink(e *logEntry, persist func() error) error {
+	if err := writable(e.NewPath); err != nil {
+		return err
+	}
+	src, err := s.own(e.Path)
+	if err != nil || src.isDir || src.isSymlink() || src.pending || src.objectID != e.ObjectID {
+		return fmt.Errorf("the file %s was removed or replaced before it was linked", e.Path)
+	}
+	parent, err := s.own(path.Dir(e.NewPath))
+	if err != nil || !parent.isDir {
+		return fmt.Errorf("directory %s does not exist", path.Dir(e.NewPath))
+	}
+	if parent.lookup(path.Base(e.NewPath)) != nil {
+		return EntryAlreadyExistsError{e.NewPath}
+	}
+	if err := s.reserveQuota(path.Dir(e.NewPath), src.size, 1); err != nil {
+		return err
+	}
+	if err := persist(); err != nil {
+		s.addUsage(path.Dir(e.NewPath), -src.size, -1)
+		return err
+	}
+	src.shareXattrs()
+	f := src.clone()
+	f.name = path.Base(e.NewPath)
+	f.fullPath = e.NewPath
+	if src.links == nil {
+		src.links = &linkGroup{count: 1, holders: []*fileInfo{src}}
+	}
+	f.links = src.links
+	if err := parent.insert(f); err != nil {
+		return err
+	}
+	s.muLocation.Lock()
+	f.links.holders = append(f.links.holders, f)
+	atomic.AddInt32(&f.links.count, 1)
+	s.muLocation.Unlock()
+	parent.modify(e.time())
+	s.setLocation(e.NewPath, f)
+	return nil
+}
+
+// unlinkName drops the name f of a file from the live tree. Its data may
+// still be held by a snapshot, see dropHolder.
+func (f *fileInfo) unlinkName() {
+	if f.links != nil {
+		atomic.AddInt32(&f.links.count, -1)
+	}
+}
+
+// dropHolder forgets the entry f, which neither the live tree nor a snapshot
+// holds anymore, and returns how many entries still hold its data.
+func (s *MetaDataServer) dropHolder(f *fileInfo) int {
+	if f.links == nil {
+		return 0
+	}
+	s.muLocation.Lock()
+	defer s.muLocation.Unlock()
+	f.links.holders = slices.DeleteFunc(f.links.holders, func(l *fileInfo) bool { return l == f })
+	return len(f.links.holders)
+}
+
+// linkNames joins the entries that share their data, which are stored
+// separately in snapshots. Only the ones in the live tree, which is indexed
+// first, are names of the file and share its extended attributes. It
+// returns whether f holds the data of a file that was already seen.
+func linkNames(seen map[string]*linkGroup, f *fileInfo, live bool) bool {
+	g, ok := seen[f.objectID]
+	if !ok {
+		g = &linkGroup{}
+		seen[f.objectID] = g
+	}
+	g.holders = append(g.holders, f)
+	if live {
+		g.count++
+		if g.count > 1 {
+			f.xattrs = g.holders[0].shareXattrs()
+		}
+	}
+	switch len(g.holders) {
+	case 1:
+		// a file with a single entry has no group
+	case 2:
+		g.holders[0].links = g
+		fallthrough
+	default:
+		f.links = g
+	}
+	return ok
+}
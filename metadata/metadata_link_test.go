@@ -0,0 +1,147 @@
+package metadata
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSymlink(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	for _, d := range []string{"a", "a/b"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec, err := createFile(ctx, s, &RecRequest{Name: "a/b/f", FileSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []struct{ name, target string }{
+		{"l", "a/b"},
+		{"a/up", "../a/b"},
+		{"abs", "/a/b/f"},
+		{"x", "y"},
+		{"y", "x"},
+		{"dangling", "nowhere"},
+	} {
+		if _, err := s.Symlink(ctx, &SymlinkRequest{Name: l.name, Target: l.target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// links are followed in the directories of a path and at its end
+	for _, name := range []string{"l/f", "a/up/f", "abs"} {
+		loc, err := s.GetLocation(ctx, &LocRequest{Name: name})
+		if err != nil || loc.ObjectId != rec.ObjectId {
+			t.Errorf("expected %s to lead to a/b/f, got %v, %v", name, loc, err)
+		}
+		if info, err := s.Stat(ctx, &StatRequest{Name: name}); err != nil || info.FullPath != "a/b/f" {
+			t.Errorf("expected to stat a/b/f through %s, got %v, %v", name, info, err)
+		}
+	}
+	if all, err := s.ReadDirAll(ctx, &ReadDirRequest{Name: "l"}); err != nil || len(all.Entries) != 1 {
+		t.Errorf("expected to list a/b through l, got %v, %v", all, err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "l/g", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(ctx, &StatRequest{Name: "a/b/g"}); err != nil {
+		t.Errorf("expected l/g to be created in a/b, got %v", err)
+	}
+	if _, err := s.Stat(ctx, &StatRequest{Name: "x"}); err == nil || !strings.Contains(err.Error(), "too many levels") {
+		t.Errorf("expected the loop to be detected, got %v", err)
+	}
+	if _, err := s.Stat(ctx, &StatRequest{Name: "dangling"}); err == nil {
+		t.Error("expected a dangling link not to resolve")
+	}
+
+	// links survive a restart and are removed without touching their target
+	s.Stop()
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	if res, err := s.Readlink(ctx, &ReadlinkRequest{Name: "a/up"}); err != nil || res.Target != "../a/b" {
+		t.Errorf("expected a/up to point to ../a/b, got %v, %v", res, err)
+	}
+	if _, err := s.Readlink(ctx, &ReadlinkRequest{Name: "a"}); err == nil {
+		t.Error("expected reading a directory as a link to fail")
+	}
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "l"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(ctx, &StatRequest{Name: "a/b/f"}); err != nil {
+		t.Errorf("expected the target to be kept, got %v", err)
+	}
+	fake := (*s.serverByPort(1).client).(*fakeFileServer)
+	if len(fake.deleted) != 0 {
+		t.Errorf("expected no data to be deleted with a link, got %v", fake.deleted)
+	}
+}
+
+func TestHardLink(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := createFile(ctx, s, &RecRequest{Name: "f", FileSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Link(ctx, &LinkRequest{OldName: "f", NewName: "d/g"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Link(ctx, &LinkRequest{OldName: "d", NewName: "e"}); err == nil {
+		t.Error("expected linking a directory to fail")
+	}
+	check := func(name string, links int32, load int) {
+		t.Helper()
+		info, err := s.Stat(ctx, &StatRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ObjectId != rec.ObjectId || info.Links != links {
+			t.Errorf("expected %s to be one of %d names of %s, got %d names of %s", name, links, rec.ObjectId, info.Links, info.ObjectId)
+		}
+		if got := s.serverByPort(1).load; got != load {
+			t.Errorf("expected the data to be counted once, got a load of %d", got)
+		}
+	}
+	check("d/g", 2, 10)
+
+	// the names are joined again from the journal and from snapshots
+	s.Stop()
+	s = newTestServer(t, dir)
+	check("f", 2, 10)
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	check("f", 2, 10)
+
+	// the data is deleted with the last name
+	fake := (*s.serverByPort(1).client).(*fakeFileServer)
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "f"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.deleted) != 0 {
+		t.Errorf("expected the data to be kept for d/g, got %v", fake.deleted)
+	}
+	check("d/g", 1, 10)
+	if _, err := s.RmDir(ctx, &RmDirRequest{Name: "d", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != rec.ObjectId {
+		t.Errorf("expected the data to be deleted with the last name, got %v", fake.deleted)
+	}
+	if load := s.serverByPort(1).load; load != 0 {
+		t.Errorf("expected the load to be released, got %d", load)
+	}
+}
@@ -0,0 +1,308 @@
+package metadata
+
+import (
+	context "context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxLockLeases is how many default leases the lease of a lock may be long.
+const maxLockLeases = 10
+
+// lockTable holds the advisory locks handed out to clients. A lock covers
+// its path and everything below it, so it conflicts with the locks on the
+// path, its directories and the entries below it unless all of them are
+// shared. Every lock has a lease that expires unless it is renewed, which
+// releases the locks of crashed clients.
+//
+// Locks are not journaled. They only live on the server, the leader of a
+// cluster, and are lost when it stops or loses the leadership, which their
+// holders learn from the next renewal. A new leader does not know the locks
+// granted by the previous one, so it grants none until they expired, see
+// lockEpoch.
+type lockTable struct {
+	mu     sync.Mutex
+	leases map[string]*lease
+	// closed and replaced whenever a lock is released, see wake
+	released chan struct{}
+	// returns the term the locks are granted in and from when on, see
+	// MetaDataServer.lockEpoch
+	epoch func() (term uint64, from time.Time, err error)
+	// term the leases were granted in
+	term uint64
+	// returns the current time, replaced by tests
+	now func() time.Time
+}
+
+type lease struct {
+	id        string
+	path      string
+	owner     string
+	exclusive bool
+	expires   time.Time
+}
+
+func newLockTable(epoch func() (uint64, time.Time, error)) *lockTable {
+	return &lockTable{
+		leases:   make(map[string]*lease),
+		released: make(chan struct{}),
+		epoch:    epoch,
+		now:      time.Now,
+	}
+}
+
+// sync drops the leases granted in an earlier term and returns from when on
+// locks can be granted. It fails with NotLeaderError if the server is not
+// the leader anymore. The caller must hold mu.
+func (t *lockTable) sync() (time.Time, error) {
+	term, from, err := t.epoch()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if term != t.term {
+		clear(t.leases)
+		t.term = term
+		t.wake()
+	}
+	return from, nil
+}
+
+// wake lets the waiting requests look for their lock again. The caller
+// must hold mu.
+func (t *lockTable) wake() {
+	close(t.released)
+	t.released = make(chan struct{})
+}
+
+// lockOverlaps reports whether locks on p and q cover a common path.
+func lockOverlaps(p, q string) bool {
+	return p == q || p == "." || q == "." || strings.HasPrefix(p, q+"/") || strings.HasPrefix(q, p+"/")
+}
+
+// conflict returns a lease that keeps a lock on p from being granted, nil
+// if there is none. Expired leases are dropped on the way. The caller must
+// hold mu.
+func (t *lockTable) conflict(p string, exclusive bool, now time.Time) *lease {
+	var c *lease
+	for id, l := range t.leases {
+		if !now.Before(l.expires) {
+			delete(t.leases, id)
+			t.wake()
+			continue
+		}
+		if c == nil && (exclusive || l.exclusive) && lockOverlaps(l.path, p) {
+			c = l
+		}
+	}
+	return c
+}
+
+// acquire grants a lock on p for ttl. If wait is set it waits for the
+// conflicting locks to be released or to expire until ctx is done. Waiting
+// requests are not queued, the first one to find the lock free gets it.
+func (t *lockTable) acquire(ctx context.Context, p string, exclusive bool, owner string, ttl time.Duration, wait bool) (*lease, error) {
+	for {
+		t.mu.Lock()
+		now := t.now()
+		// checked before every grant since a waiting request may outlive
+		// the leadership
+		from, err := t.sync()
+		if err != nil {
+			t.mu.Unlock()
+			return nil, err
+		}
+		var until time.Time
+		if now.Before(from) {
+			until = from
+			err = status.Errorf(codes.Unavailable, "no locks are granted until %s, when the ones granted by the previous leader expired", from.Format(time.RFC3339))
+		} else if c := t.conflict(p, exclusive, now); c != nil {
+			until = c.expires
+			err = status.Errorf(codes.Aborted, "%s is locked by %q on %s until %s", p, c.owner, c.path, until.Format(time.RFC3339))
+		} else {
+			l := &lease{id: newLeaseID(), path: p, owner: owner, exclusive: exclusive, expires: now.Add(ttl)}
+			t.leases[l.id] = l
+			granted := *l
+			t.mu.Unlock()
+			return &granted, nil
+		}
+		released := t.released
+		t.mu.Unlock()
+		if !wait {
+			return nil, err
+		}
+		timer := time.NewTimer(until.Sub(t.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// renew extends the lease id by ttl and returns when it expires now.
+func (t *lockTable) renew(id string, ttl time.Duration) (time.Time, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.sync(); err != nil {
+		return time.Time{}, err
+	}
+	now := t.now()
+	l, err := t.live(id, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	l.expires = now.Add(ttl)
+	return l.expires, nil
+}
+
+// release drops the lock of the lease id.
+func (t *lockTable) release(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.sync(); err != nil {
+		return err
+	}
+	if _, err := t.live(id, t.now()); err != nil {
+		return err
+	}
+	delete(t.leases, id)
+	t.wake()
+	return nil
+}
+
+// live returns the lease id unless it expired. The caller must hold mu.
+func (t *lockTable) live(id string, now time.Time) (*lease, error) {
+	l, ok := t.leases[id]
+	if ok && !now.Before(l.expires) {
+		delete(t.leases, id)
+		t.wake()
+		ok = false
+	}
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "lease %s expired or is unknown", id)
+	}
+	return l, nil
+}
+
+// newLeaseID returns a random id for a new lease.
+func newLeaseID() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// lockEpoch returns the term in which the server grants locks and from when
+// on. A new leader waits for the longest lease to pass, by when the locks
+// granted by the previous leader expired. A server that is not part of a
+// cluster grants locks right away, its locks are lost when it stops.
+func (s *MetaDataServer) lockEpoch() (uint64, time.Time, error) {
+	if s.raft == nil {
+		return 0, time.Time{}, nil
+	}
+	leader, term, since, ok := s.raft.leading()
+	if !ok {
+		return 0, time.Time{}, NotLeaderError{Leader: leader}
+	}
+	return term, since.Add(s.maxLockLease()), nil
+}
+
+// leaseFor returns the lease a request asks for, the default one if it asks
+// for none. Longer leases than maxLockLease are cut to it.
+func (s *MetaDataServer) leaseFor(millis int64) (time.Duration, error) {
+	if millis < 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "negative lease of %dms", millis)
+	}
+	if millis == 0 {
+		return s.opts.lockLease, nil
+	}
+	if limit := s.maxLockLease(); millis > limit.Milliseconds() {
+		return limit, nil
+	}
+	return time.Duration(millis) * time.Millisecond, nil
+}
+
+// maxLockLease returns the longest lease a lock is given, which a new leader
+// waits for before it grants locks.
+func (s *MetaDataServer) maxLockLease() time.Duration {
+	return maxLockLeases * s.opts.lockLease
+}
+
+// Lock takes an advisory lock on a path, which does not need to exist. A
+// shared lock only conflicts with exclusive locks on the path, its
+// directories or the entries below it, an exclusive lock with all of them.
+// The lock is held until it is released with Unlock or its lease expires.
+func (s *MetaDataServer) Lock(ctx context.Context, req *LockRequest) (*LockResponse, error) {
+	ttl, err := s.leaseFor(req.LeaseMillis)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkOwns(p); err != nil {
+		return nil, err
+	}
+	if p == "." && s.opts.shards > 1 {
+		return nil, status.Error(codes.FailedPrecondition, "the root of a sharded namespace cannot be locked")
+	}
+	l, err := s.locks.acquire(ctx, p, req.Mode == LockMode_EXCLUSIVE, req.Owner, ttl, req.Wait)
+	if err != nil {
+		slog.Debug("could not lock", "path", p, "owner", req.Owner, "err", err)
+		return nil, err
+	}
+	return &LockResponse{LeaseId: l.id, Expires: timestamppb.New(l.expires)}, nil
+}
+
+// Unlock releases the lock of a lease. The name only routes the request to
+// the metadata shard holding the lock.
+func (s *MetaDataServer) Unlock(ctx context.Context, req *UnlockRequest) (*UnlockResponse, error) {
+	if err := s.checkLeaseShard(req.Name); err != nil {
+		return nil, err
+	}
+	if err := s.locks.release(req.LeaseId); err != nil {
+		return nil, err
+	}
+	return &UnlockResponse{}, nil
+}
+
+// RenewLease extends the lease of a lock. It fails with NotFound once the
+// lease expired, when the lock may have been given to someone else.
+func (s *MetaDataServer) RenewLease(ctx context.Context, req *RenewLeaseRequest) (*RenewLeaseResponse, error) {
+	if err := s.checkLeaseShard(req.Name); err != nil {
+		return nil, err
+	}
+	ttl, err := s.leaseFor(req.LeaseMillis)
+	if err != nil {
+		return nil, err
+	}
+	expires, err := s.locks.renew(req.LeaseId, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &RenewLeaseResponse{Expires: timestamppb.New(expires)}, nil
+}
+
+// checkLeaseShard rejects the requests for a lease on name that were routed
+// to a shard that does not own it and so cannot hold the lock.
+func (s *MetaDataServer) checkLeaseShard(name string) error {
+	p, err := s.follow(name, true)
+	if err != nil {
+		return err
+	}
+	return s.checkOwns(p)
+}
@@ -0,0 +1,267 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClock is a clock for the lock table that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) time() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// advance moves the clock of locks forward by d and lets the waiting
+// requests look at their locks again.
+func (c *fakeClock) advance(locks *lockTable, d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+	locks.mu.Lock()
+	locks.wake()
+	locks.mu.Unlock()
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir())
+	defer s.Stop()
+	clock := &fakeClock{now: time.Now()}
+	s.locks.now = clock.time
+
+	lock := func(name string, mode LockMode, leaseMillis int64) (*LockResponse, error) {
+		return s.Lock(ctx, &LockRequest{Name: name, Mode: mode, Owner: "test", LeaseMillis: leaseMillis})
+	}
+	held, err := lock("a/f", LockMode_EXCLUSIVE, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a lock covers the entries below its path
+	leases := make(map[string]string)
+	for _, tc := range []struct {
+		name string
+		mode LockMode
+		code codes.Code
+	}{
+		{"a/f", LockMode_SHARED, codes.Aborted},
+		{"a", LockMode_SHARED, codes.Aborted},
+		{"a/g", LockMode_EXCLUSIVE, codes.OK},
+		{"b", LockMode_SHARED, codes.OK},
+		{"b/c", LockMode_SHARED, codes.OK},
+		{"b/c", LockMode_EXCLUSIVE, codes.Aborted},
+	} {
+		res, err := lock(tc.name, tc.mode, 0)
+		if status.Code(err) != tc.code {
+			t.Errorf("expected a %v lock on %s to end with %v, got %v", tc.mode, tc.name, tc.code, err)
+		}
+		if err == nil {
+			leases[tc.name] = res.LeaseId
+		}
+	}
+
+	// a waiting lock is granted once all conflicting ones are released
+	granted := make(chan error, 1)
+	waiting, stopWaiting := context.WithCancel(ctx)
+	defer stopWaiting()
+	go func() {
+		_, err := s.Lock(waiting, &LockRequest{Name: "a", Mode: LockMode_SHARED, Wait: true})
+		granted <- err
+	}()
+	select {
+	case err := <-granted:
+		t.Fatalf("expected the lock to wait, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := s.Unlock(ctx, &UnlockRequest{Name: "a/f", LeaseId: held.LeaseId}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Unlock(ctx, &UnlockRequest{Name: "a/f", LeaseId: held.LeaseId}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected a released lease to be unknown, got %v", err)
+	}
+	// a/g is still locked exclusively
+	select {
+	case err := <-granted:
+		t.Fatalf("expected the lock to wait for a/g, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.Lock(waitCtx, &LockRequest{Name: "a/g", Wait: true}); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected waiting to end with the context, got %v", err)
+	}
+	if _, err := s.Unlock(ctx, &UnlockRequest{Name: "a/g", LeaseId: leases["a/g"]}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-granted:
+		if err != nil {
+			t.Errorf("expected the waiting lock to be granted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the waiting lock to be granted")
+	}
+
+	// leases expire unless they are renewed
+	short, err := lock("x", LockMode_EXCLUSIVE, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(s.locks, 150*time.Millisecond)
+	if _, err := s.RenewLease(ctx, &RenewLeaseRequest{Name: "x", LeaseId: short.LeaseId, LeaseMillis: 200}); err != nil {
+		t.Errorf("expected the lease to be renewed, got %v", err)
+	}
+	clock.advance(s.locks, 150*time.Millisecond)
+	if _, err := lock("x", LockMode_SHARED, 0); status.Code(err) != codes.Aborted {
+		t.Errorf("expected the renewed lock to be held, got %v", err)
+	}
+	granted = make(chan error, 1)
+	go func() {
+		_, err := s.Lock(ctx, &LockRequest{Name: "x", Wait: true})
+		granted <- err
+	}()
+	select {
+	case err := <-granted:
+		t.Fatalf("expected the lock to wait for the lease to expire, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.advance(s.locks, 50*time.Millisecond)
+	select {
+	case err := <-granted:
+		if err != nil {
+			t.Errorf("expected the lock to be granted once the lease expired, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the waiting lock to be granted when the lease expired")
+	}
+	if _, err := s.RenewLease(ctx, &RenewLeaseRequest{Name: "x", LeaseId: short.LeaseId}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected an expired lease not to be renewed, got %v", err)
+	}
+}
+
+func TestLockEpoch(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	term, from, leading := uint64(1), time.Now().Add(time.Hour), true
+	locks := newLockTable(func() (uint64, time.Time, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !leading {
+			return 0, time.Time{}, NotLeaderError{Leader: 2}
+		}
+		return term, from, nil
+	})
+	// lets the waiting requests look at the changed epoch
+	change := func(f func()) {
+		mu.Lock()
+		f()
+		mu.Unlock()
+		locks.mu.Lock()
+		locks.wake()
+		locks.mu.Unlock()
+	}
+	acquire := func(name string, wait bool) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := locks.acquire(ctx, name, true, "test", time.Hour, wait)
+			done <- err
+		}()
+		return done
+	}
+
+	// a new leader grants no locks until the ones of the previous leader
+	// expired
+	if err := <-acquire("a", false); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the new leader to grant no locks, got %v", err)
+	}
+	granted := acquire("a", true)
+	change(func() { from = time.Now() })
+	if err := <-granted; err != nil {
+		t.Errorf("expected the lock to be granted once the previous leases expired, got %v", err)
+	}
+
+	// a waiting request is not granted once the server lost the leadership
+	held, err := locks.acquire(ctx, "b", true, "test", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiting := acquire("b", true)
+	change(func() { leading = false })
+	var notLeader NotLeaderError
+	if err := <-waiting; !errors.As(err, &notLeader) || notLeader.Leader != 2 {
+		t.Errorf("expected the waiting request to be redirected, got %v", err)
+	}
+
+	// the leases of an earlier leadership are gone
+	change(func() { leading, term = true, 2 })
+	if _, err := locks.renew(held.id, time.Hour); status.Code(err) != codes.NotFound {
+		t.Errorf("expected the lease of the earlier term to be gone, got %v", err)
+	}
+}
+
+func TestLeaseLimit(t *testing.T) {
+	s := newTestServer(t, t.TempDir(), WithLockLease(time.Second))
+	defer s.Stop()
+	for _, tc := range []struct {
+		millis int64
+		want   time.Duration
+	}{
+		{0, time.Second},
+		{500, 500 * time.Millisecond},
+		{10_000, 10 * time.Second},
+		{10_001, 10 * time.Second},
+		{math.MaxInt64, 10 * time.Second},
+	} {
+		if got, err := s.leaseFor(tc.millis); err != nil || got != tc.want {
+			t.Errorf("expected a lease of %dms to last %v, got %v, %v", tc.millis, tc.want, got, err)
+		}
+	}
+	if _, err := s.leaseFor(-1); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected a negative lease to be rejected, got %v", err)
+	}
+}
+
+func TestLockShard(t *testing.T) {
+	ctx := context.Background()
+	const n = 2
+	var mine, other string
+	for i := 0; mine == "" || other == ""; i++ {
+		p := fmt.Sprintf("dir-%d", i)
+		if ShardOf(p, n) == 1 {
+			mine = p
+		} else {
+			other = p
+		}
+	}
+	s := newTestServer(t, t.TempDir(), WithShard(1, n))
+	defer s.Stop()
+	held, err := s.Lock(ctx, &LockRequest{Name: mine, Mode: LockMode_EXCLUSIVE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lock(ctx, &LockRequest{Name: other, Mode: LockMode_EXCLUSIVE}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected a lock on another shard to be rejected, got %v", err)
+	}
+	if _, err := s.RenewLease(ctx, &RenewLeaseRequest{Name: other, LeaseId: held.LeaseId}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected a renewal routed to the wrong shard to be rejected, got %v", err)
+	}
+	if _, err := s.Unlock(ctx, &UnlockRequest{Name: other, LeaseId: held.LeaseId}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an unlock routed to the wrong shard to be rejected, got %v", err)
+	}
+	if _, err := s.Unlock(ctx, &UnlockRequest{Name: mine, LeaseId: held.LeaseId}); err != nil {
+		t.Errorf("expected the lock to be released, got %v", err)
+	}
+}
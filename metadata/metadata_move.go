@@ -0,0 +1,366 @@
+package metadata
+
+import (
+	context "context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// how often the moves to other shards that were interrupted are retried
+const moveRetry = 2 * time.Second
+
+// move is an entry being moved to another shard, see moveOut.
+type move struct {
+	ID      string `json:"id"`
+	Path    string `json:"path"`
+	NewPath string `json:"newPath"`
+	Shard   int    `json:"shard"`
+}
+
+// errMovedIn is returned when a move that was already received from another
+// shard is received again.
+var errMovedIn = errors.New("the move was already received")
+
+func newMoveID() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// moveOut renames the entry at p to newPath, which is owned by another
+// shard. The move is journaled as an intent first, which keeps the entry and
+// everything below it from changing, then the destination adds the entry and
+// finally it is removed here. If the destination refuses the entry the
+// intent is dropped again. A move whose outcome is not known, e.g. because
+// the destination cannot be reached, is finished later by moveLoop, by
+// another leader if needed. Until then the entry can be seen under both
+// names.
+//
+// Files whose data is shared, by hard links or snapshots, and files that
+// are not committed yet cannot be moved, since every shard counts the names
+// of the data it holds on its own.
+func (s *MetaDataServer) moveOut(ctx context.Context, p, newPath string) error {
+	shard := ShardOf(newPath, s.opts.shards)
+	if shard >= len(s.opts.shardPorts) {
+		return status.Errorf(codes.FailedPrecondition, "cannot move %s to %s, the port of metadata shard %d is not known", p, newPath, shard)
+	}
+	m := &move{ID: newMoveID(), Path: p, NewPath: newPath, Shard: shard}
+	// keeps moveLoop from finishing the move at the same time
+	s.moving.Store(m.ID, true)
+	defer s.moving.Delete(m.ID)
+	e := &logEntry{Op: opMoveOut, Path: p, NewPath: newPath, MoveID: m.ID, Shard: shard, Time: time.Now().UnixNano()}
+	if err := s.commit(ctx, e); err != nil {
+		return err
+	}
+	return s.finishMove(ctx, m)
+}
+
+// finishMove hands the entry of m to the destination shard and journals the
+// outcome. It fails with Unavailable if the outcome is not known yet.
+func (s *MetaDataServer) finishMove(ctx context.Context, m *move) error {
+	s.muDir.RLock()
+	f, err := s.rootDir.walkTo(m.Path)
+	var record []byte
+	if err == nil {
+		record, err = json.Marshal(toRecord(f))
+	}
+	s.muDir.RUnlock()
+	if err != nil {
+		return fmt.Errorf("the entry %s being moved is gone: %w", m.Path, err)
+	}
+	conn, err := grpc.NewClient(fmt.Sprintf(":%d", s.opts.shardPorts[m.Shard]),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(FollowLeader),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dst := NewShardServiceClient(conn)
+	_, err = dst.MoveIn(ctx, &MoveInRequest{Id: m.ID, Name: m.NewPath, Record: record})
+	if err != nil && !refused(err) {
+		slog.Warn("move to another shard is retried", "path", m.Path, "new", m.NewPath, "shard", m.Shard, "err", err)
+		return status.Errorf(codes.Unavailable, "the move of %s to %s is finished later: %v", m.Path, m.NewPath, err)
+	}
+	if err != nil {
+		abort := &logEntry{Op: opMoveAbort, Path: m.Path, NewPath: m.NewPath, MoveID: m.ID, Time: time.Now().UnixNano()}
+		if err := s.commit(ctx, abort); err != nil {
+			slog.Error("could not drop refused move", "path", m.Path, "new", m.NewPath, "err", err)
+		}
+		return err
+	}
+	done := &logEntry{Op: opMoveDone, Path: m.Path, NewPath: m.NewPath, MoveID: m.ID, Time: time.Now().UnixNano()}
+	if err := s.commit(ctx, done); err != nil {
+		return err
+	}
+	// the destination only keeps the id to recognize the move if it is sent again
+	if _, err := dst.ForgetMove(ctx, &ForgetMoveRequest{Id: m.ID}); err != nil {
+		slog.Warn("could not tell the destination shard that a move is done", "id", m.ID, "shard", m.Shard, "err", err)
+	}
+	return nil
+}
+
+// refused reports whether err is the destination of a move refusing the
+// entry, as opposed to not knowing whether it was added.
+func refused(err error) bool {
+	switch status.Code(err) {
+	case codes.Aborted, codes.AlreadyExists, codes.InvalidArgument, codes.FailedPrecondition,
+		codes.NotFound, codes.PermissionDenied, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// moveLoop finishes the moves to other shards that were interrupted, by a
+// destination that could not be reached, a restart or a new leader.
+func (s *MetaDataServer) moveLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(moveRetry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if s.raft != nil {
+			if _, ok := s.raft.isLeader(); !ok {
+				continue
+			}
+		}
+		s.muDir.RLock()
+		var moves []*move
+		for _, m := range s.moves {
+			moves = append(moves, m)
+		}
+		s.muDir.RUnlock()
+		for _, m := range moves {
+			if _, busy := s.moving.LoadOrStore(m.ID, true); busy {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), moveRetry)
+			if err := s.finishMove(ctx, m); err != nil {
+				slog.Warn("could not finish move to another shard", "path", m.Path, "new", m.NewPath, "err", err)
+			}
+			cancel()
+			s.moving.Delete(m.ID)
+		}
+	}
+}
+
+// MoveIn adds an entry moved from another shard, see moveOut.
+func (s *MetaDataServer) MoveIn(ctx context.Context, req *MoveInRequest) (*MoveInResponse, error) {
+	p, err := s.follow(req.Name, false)
+	if err != nil {
+		return nil, err
+	}
+	r := new(fileRecord)
+	if err := json.Unmarshal(req.Record, r); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid record of the entry moved to %s: %v", p, err)
+	}
+	e := &logEntry{Op: opMoveIn, NewPath: p, MoveID: req.Id, Record: r, Time: time.Now().UnixNano()}
+	err = s.commit(ctx, e)
+	var notLeader NotLeaderError
+	switch {
+	case err == nil, errors.Is(err, errMovedIn):
+	case errors.As(err, &notLeader):
+		return nil, err
+	default:
+		if _, ok := status.FromError(err); !ok {
+			// the entry is not valid here, the source drops the move
+			err = status.Error(codes.Aborted, err.Error())
+		}
+		slog.Error("could not add moved entry", "path", p, "err", err)
+		return nil, err
+	}
+	s.deleteData(e.orphaned)
+	return &MoveInResponse{}, nil
+}
+
+// ForgetMove drops the id of a move received from another shard once the
+// source has finished it.
+func (s *MetaDataServer) ForgetMove(ctx context.Context, req *ForgetMoveRequest) (*ForgetMoveResponse, error) {
+	s.muDir.RLock()
+	known := s.movedIn[req.Id]
+	s.muDir.RUnlock()
+	if !known {
+		return &ForgetMoveResponse{}, nil
+	}
+	if err := s.commit(ctx, &logEntry{Op: opMoveForget, MoveID: req.Id, Time: time.Now().UnixNano()}); err != nil {
+		return nil, err
+	}
+	return &ForgetMoveResponse{}, nil
+}
+
+// checkMoving fails if e changes an entry that is being moved to another
+// shard, or a directory above it. The caller must hold muDir.
+func (s *MetaDataServer) checkMoving(e *logEntry) error {
+	for _, m := range s.moves {
+		for _, p := range []string{e.Path, e.NewPath} {
+			if p != "" && lockOverlaps(path.Clean(p), m.Path) {
+				return status.Errorf(codes.FailedPrecondition, "%s is being moved to %s", m.Path, m.NewPath)
+			}
+		}
+	}
+	return nil
+}
+
+// movable fails if the entry f at p cannot be moved to another shard. The
+// caller must hold muDir.
+func (s *MetaDataServer) movable(p string, f *fileInfo) error {
+	if s.sharedPath(p) || hasSnapshots(f) {
+		return status.Errorf(codes.FailedPrecondition, "%s shares entries with snapshots and cannot be moved to another shard", p)
+	}
+	var check func(f *fileInfo) error
+	check = func(f *fileInfo) error {
+		switch {
+		case f.pending:
+			return status.Errorf(codes.FailedPrecondition, "the file %s is not committed yet", f.fullPath)
+		case f.links != nil:
+			return status.Errorf(codes.FailedPrecondition, "the file %s has hard links and cannot be moved to another shard", f.fullPath)
+		}
+		for _, e := range f.subEntries {
+			if err := check(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return check(f)
+}
+
+func (s *MetaDataServer) applyMoveOut(e *logEntry, persist func() error) error {
+	if e.Path == "." || e.NewPath == "." {
+		return errors.New("the root directory cannot be renamed")
+	}
+	for _, p := range []string{e.Path, e.NewPath} {
+		if err := writable(p); err != nil {
+			return err
+		}
+	}
+	f, err := s.rootDir.walkTo(e.Path)
+	if err != nil {
+		return fmt.Errorf("the entry %s doesn't exist", e.Path)
+	}
+	if err := s.movable(e.Path, f); err != nil {
+		return err
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	s.moves[e.MoveID] = &move{ID: e.MoveID, Path: e.Path, NewPath: e.NewPath, Shard: e.Shard}
+	return nil
+}
+
+func (s *MetaDataServer) applyMoveIn(e *logEntry, persist func() error) error {
+	if s.movedIn[e.MoveID] {
+		return errMovedIn
+	}
+	if e.NewPath == "." || e.Record == nil {
+		return errors.New("the root directory cannot be replaced")
+	}
+	parent, err := s.lockParent(e.NewPath)
+	if err != nil {
+		return err
+	}
+	defer parent.unlock()
+	f := fromRecord(e.Record, e.NewPath)
+	f.name = path.Base(e.NewPath)
+	bytes, entries := f.usage()
+	dst := parent.lookup(f.name)
+	if dst != nil {
+		if err := replaceable("the entry moved from another shard", f.isDir, e.NewPath, dst); err != nil {
+			return err
+		}
+		b, n := dst.usage()
+		bytes, entries = bytes-b, entries-n
+	}
+	if err := s.reserveQuota(path.Dir(e.NewPath), bytes, entries); err != nil {
+		return err
+	}
+	if err := persist(); err != nil {
+		s.addUsage(path.Dir(e.NewPath), -bytes, -entries)
+		return err
+	}
+	if dst != nil {
+		parent.remove(dst.name)
+		e.removed = filesBelow(dst)
+		e.orphaned = s.forgetFiles(e.removed, release(dst))
+	}
+	if err := parent.insert(f); err != nil {
+		return err
+	}
+	for _, file := range filesBelow(f) {
+		if !file.isSymlink() {
+			s.setLocation(file.fullPath, file)
+			s.addLoad(file, int(file.size))
+		}
+	}
+	parent.modify(e.time())
+	s.movedIn[e.MoveID] = true
+	return nil
+}
+
+func (s *MetaDataServer) applyMoveDone(e *logEntry, persist func() error) error {
+	m, ok := s.moves[e.MoveID]
+	if !ok {
+		return fmt.Errorf("unknown move %s", e.MoveID)
+	}
+	f, err := s.rootDir.walkTo(m.Path)
+	if err != nil {
+		return fmt.Errorf("the entry %s doesn't exist", m.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	delete(s.moves, e.MoveID)
+	if _, err := removeFileInfo(s.rootDir, m.Path); err != nil {
+		return err
+	}
+	bytes, entries := f.usage()
+	s.addUsage(path.Dir(m.Path), -bytes, -entries)
+	// the data belongs to the destination now and is not deleted
+	for _, file := range filesBelow(f) {
+		if !file.isSymlink() {
+			s.deleteLocation(file.fullPath)
+			s.addLoad(file, -int(file.size))
+		}
+	}
+	s.touch(path.Dir(m.Path), e.time())
+	return nil
+}
+
+func (s *MetaDataServer) applyMoveAbort(e *logEntry, persist func() error) error {
+	if _, ok := s.moves[e.MoveID]; !ok {
+		return fmt.Errorf("unknown move %s", e.MoveID)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	delete(s.moves, e.MoveID)
+	return nil
+}
+
+func (s *MetaDataServer) applyMoveForget(e *logEntry, persist func() error) error {
+	if !s.movedIn[e.MoveID] {
+		return fmt.Errorf("unknown move %s", e.MoveID)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	delete(s.movedIn, e.MoveID)
+	return nil
+}
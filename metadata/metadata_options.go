@@ -0,0 +1,202 @@
+package metadata
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+const (
+	defaultSnapshotInterval = 10000
+	defaultElectionTimeout  = time.Second
+	defaultSuspectAfter     = 3 * time.Second
+	defaultDeadAfter        = 10 * time.Second
+	defaultReplication      = 2
+	defaultRepairInterval   = 30 * time.Second
+	defaultRepairWorkers    = 2
+	defaultRepairRate       = 50 << 20
+	defaultPendingTimeout   = 10 * time.Minute
+	defaultLockLease        = 30 * time.Second
+)
+
+type options struct {
+	// directory holding the journal and the snapshots of the namespace
+	dataDir string
+	// fsync every journal entry before acknowledging the mutation
+	syncWrites bool
+	// number of journal entries after which the namespace is compacted into a snapshot
+	snapshotInterval int
+	// ports of the other metadata servers of the cluster
+	peers []int
+	// how long a follower waits for the leader before it starts an election
+	electionTimeout time.Duration
+	// part of the namespace held by the server, see ShardOf
+	shard  int
+	shards int
+	// port of a metadata server of every shard, in the order of their index
+	shardPorts []int
+	// decides which file server new files are stored on
+	placement PlacementPolicy
+	// how long a file server may miss heartbeats before it is suspected
+	// or considered dead
+	suspectAfter time.Duration
+	deadAfter    time.Duration
+	// number of file servers the data of a file is stored on unless the
+	// file or its directory ask for another factor
+	replication int
+	// how often under-replicated files are looked for, how many copies are
+	// made at once and how many bytes per second they may copy together
+	repairInterval time.Duration
+	repairWorkers  int
+	repairRate     int64
+	// how long a new file may wait for its commit before it is removed
+	pendingTimeout time.Duration
+	// how long a lock is held unless its lease is renewed
+	lockLease time.Duration
+}
+
+func defaultOptions(port int) options {
+	return options{
+		dataDir:          path.Join("./", "dfs-data", fmt.Sprintf("mds-%d", port)),
+		syncWrites:       true,
+		snapshotInterval: defaultSnapshotInterval,
+		electionTimeout:  defaultElectionTimeout,
+		placement:        LeastLoaded(),
+		suspectAfter:     defaultSuspectAfter,
+		deadAfter:        defaultDeadAfter,
+		replication:      defaultReplication,
+		repairInterval:   defaultRepairInterval,
+		repairWorkers:    defaultRepairWorkers,
+		repairRate:       defaultRepairRate,
+		pendingTimeout:   defaultPendingTimeout,
+		lockLease:        defaultLockLease,
+	}
+}
+
+// Option configures a MetaDataServer created with New.
+type Option func(*options)
+
+// WithDataDir sets the directory the namespace is persisted in.
+// It defaults to dfs-data/mds-<port>.
+func WithDataDir(dir string) Option {
+	return func(o *options) {
+		o.dataDir = dir
+	}
+}
+
+// WithSyncWrites controls whether every journal entry is fsynced before the
+// mutation is acknowledged. Disabling it still survives process restarts but
+// not machine crashes.
+func WithSyncWrites(sync bool) Option {
+	return func(o *options) {
+		o.syncWrites = sync
+	}
+}
+
+// WithSnapshotInterval sets the number of journaled mutations after which the
+// namespace is compacted into a snapshot.
+func WithSnapshotInterval(n int) Option {
+	return func(o *options) {
+		o.snapshotInterval = n
+	}
+}
+
+// WithPeers makes the server a member of a replicated metadata service
+// together with the metadata servers listening on the given ports. The
+// members elect a leader that handles all requests, the followers redirect
+// clients to it. Without peers the server runs standalone.
+func WithPeers(ports ...int) Option {
+	return func(o *options) {
+		o.peers = ports
+	}
+}
+
+// WithElectionTimeout sets how long a follower waits to hear from the leader
+// before it tries to become the leader itself. It defaults to a second.
+func WithElectionTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.electionTimeout = d
+	}
+}
+
+// WithShard makes the server hold one of n parts of the namespace. Requests
+// for paths owned by another shard are rejected, clients route them with
+// ShardOf. Each shard can be replicated with WithPeers on its own.
+func WithShard(index, n int) Option {
+	return func(o *options) {
+		o.shard = index
+		o.shards = n
+	}
+}
+
+// WithShardPorts tells a sharded server the port of a metadata server of
+// every shard, in the order of their index. Clients whose symbolic links lead
+// into another shard are then sent there instead of being rejected, and
+// entries can be renamed into another shard.
+func WithShardPorts(ports ...int) Option {
+	return func(o *options) {
+		o.shardPorts = ports
+	}
+}
+
+// WithPlacement sets the policy that decides which file server new files are
+// stored on. It defaults to LeastLoaded.
+func WithPlacement(p PlacementPolicy) Option {
+	return func(o *options) {
+		o.placement = p
+	}
+}
+
+// WithFailureDetection sets how long a file server may go without sending a
+// heartbeat before it is suspected and before it is considered dead. No new
+// files are placed on dead servers. It defaults to 3 and 10 seconds, which
+// durations that are not positive keep.
+func WithFailureDetection(suspectAfter, deadAfter time.Duration) Option {
+	return func(o *options) {
+		if suspectAfter > 0 {
+			o.suspectAfter = suspectAfter
+		}
+		if deadAfter > 0 {
+			o.deadAfter = deadAfter
+		}
+	}
+}
+
+// WithReplication sets the number of file servers the data of a file is
+// stored on if neither the file nor one of its directories sets a factor.
+// It defaults to 2.
+func WithReplication(n int) Option {
+	return func(o *options) {
+		o.replication = max(n, 1)
+	}
+}
+
+// WithRepair sets how often files with fewer copies on live file servers than
+// they should have are looked for, how many of them are copied at once and
+// how many bytes per second the copies may take together, 0 for no limit.
+// Servers are looked at right away when one is declared dead. It defaults to
+// every 30 seconds, 2 copies at once and 50 MiB/s.
+func WithRepair(interval time.Duration, workers int, bytesPerSecond int64) Option {
+	return func(o *options) {
+		o.repairInterval = interval
+		o.repairWorkers = max(workers, 1)
+		o.repairRate = bytesPerSecond
+	}
+}
+
+// WithPendingTimeout sets how long a file registered with RegisterFileCreation
+// may go without being committed before it is removed together with whatever
+// data was written for it. It defaults to 10 minutes.
+func WithPendingTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.pendingTimeout = d
+	}
+}
+
+// WithLockLease sets how long a lock is held without its lease being renewed
+// if the request asks for no particular length. It defaults to 30 seconds.
+func WithLockLease(d time.Duration) Option {
+	return func(o *options) {
+		o.lockLease = d
+	}
+}
@@ -0,0 +1,178 @@
+package metadata
+
+import (
+	"cmp"
+	"hash/fnv"
+	"math/rand"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Server describes a registered file server to a PlacementPolicy.
+type Server struct {
+	Port int
+	// bytes stored on the server
+	Load int64
+	// bytes the server can store as reported with its heartbeats, 0 if unknown
+	Capacity int64
+}
+
+// PlacementPolicy decides which file server the data of a new file is stored
+// on. Implementations must be safe for concurrent use.
+type PlacementPolicy interface {
+	// Place returns the index in servers of the server that the file at path
+	// with the given size is stored on. servers is never empty.
+	Place(path string, size int64, servers []Server) int
+}
+
+// LeastLoaded places files on the server that stores the fewest bytes.
+func LeastLoaded() PlacementPolicy {
+	return leastLoaded{}
+}
+
+type leastLoaded struct{}
+
+func (leastLoaded) Place(path string, size int64, servers []Server) int {
+	min := 0
+	for i, s := range servers {
+		if s.Load < servers[min].Load {
+			min = i
+		}
+	}
+	return min
+}
+
+// RoundRobin places files on the servers in turn, regardless of their load.
+func RoundRobin() PlacementPolicy {
+	return new(roundRobin)
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+func (r *roundRobin) Place(path string, size int64, servers []Server) int {
+	return int((r.next.Add(1) - 1) % uint64(len(servers)))
+}
+
+// CapacityWeighted places files on the server that is filled the least
+// relative to its capacity in bytes. The capacity of servers missing from
+// capacity is taken from their heartbeats. Servers without a capacity are
+// only used if none of the servers has one.
+func CapacityWeighted(capacity map[int]int64) PlacementPolicy {
+	return capacityWeighted(capacity)
+}
+
+type capacityWeighted map[int]int64
+
+func (c capacityWeighted) Place(path string, size int64, servers []Server) int {
+	best := -1
+	var bestFill float64
+	for i, s := range servers {
+		capacity, ok := c[s.Port]
+		if !ok {
+			capacity = s.Capacity
+		}
+		if capacity <= 0 {
+			continue
+		}
+		fill := float64(s.Load+size) / float64(capacity)
+		if best < 0 || fill < bestFill {
+			best, bestFill = i, fill
+		}
+	}
+	if best < 0 {
+		return leastLoaded{}.Place(path, size, servers)
+	}
+	return best
+}
+
+// ConsistentHash places files by hashing their path onto a ring of the
+// servers, so that adding or removing a server only moves the files of its
+// neighbours on the ring. Every server is placed on the ring replicas times.
+func ConsistentHash(replicas int) PlacementPolicy {
+	return &consistentHash{replicas: max(replicas, 1)}
+}
+
+type consistentHash struct {
+	replicas int
+
+	mu sync.Mutex
+	// ring built for the servers in ports
+	ports []int
+	ring  []ringPoint
+}
+
+type ringPoint struct {
+	hash uint32
+	port int
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func (c *consistentHash) Place(path string, size int64, servers []Server) int {
+	ports := make([]int, len(servers))
+	for i, s := range servers {
+		ports[i] = s.Port
+	}
+	ring := c.ringFor(ports)
+	h := hashString(path)
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	if i == len(ring) {
+		i = 0
+	}
+	return slices.Index(ports, ring[i].port)
+}
+
+// ringFor returns the ring of the given servers, rebuilding it if they changed.
+func (c *consistentHash) ringFor(ports []int) []ringPoint {
+	sorted := slices.Clone(ports)
+	slices.Sort(sorted)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.Equal(sorted, c.ports) {
+		return c.ring
+	}
+	ring := make([]ringPoint, 0, len(sorted)*c.replicas)
+	for _, port := range sorted {
+		for r := range c.replicas {
+			ring = append(ring, ringPoint{hash: hashString(strconv.Itoa(port) + "-" + strconv.Itoa(r)), port: port})
+		}
+	}
+	slices.SortFunc(ring, func(a, b ringPoint) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.port, b.port))
+	})
+	c.ports, c.ring = sorted, ring
+	return ring
+}
+
+// TwoChoices places files on the less loaded of two randomly picked servers.
+// It balances almost as well as LeastLoaded while spreading concurrent
+// creations instead of sending them all to the same server.
+func TwoChoices() PlacementPolicy {
+	return twoChoices{}
+}
+
+type twoChoices struct{}
+
+func (twoChoices) Place(path string, size int64, servers []Server) int {
+	if len(servers) == 1 {
+		return 0
+	}
+	// two distinct servers
+	a, b := rand.Intn(len(servers)), rand.Intn(len(servers)-1)
+	if b >= a {
+		b++
+	}
+	if servers[b].Load < servers[a].Load {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,74 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestPlacementPolicies(t *testing.T) {
+	servers := []Server{{Port: 1, Load: 300}, {Port: 2, Load: 100}, {Port: 3, Load: 200}}
+
+	if i := LeastLoaded().Place("f", 10, servers); i != 1 {
+		t.Errorf("least loaded picked %d, expected 1", i)
+	}
+
+	rr := RoundRobin()
+	for i := range 6 {
+		if got := rr.Place("f", 10, servers); got != i%3 {
+			t.Errorf("round robin picked %d in turn %d", got, i)
+		}
+	}
+
+	// server 1 is the biggest, so it is the least full despite its load
+	cw := CapacityWeighted(map[int]int64{1: 3000, 2: 200, 3: 400})
+	if i := cw.Place("f", 10, servers); i != 0 {
+		t.Errorf("capacity weighted picked %d, expected 0", i)
+	}
+
+	ch := ConsistentHash(16)
+	placed := make(map[string]int)
+	for i := range 100 {
+		f := fmt.Sprintf("dir/%d", i)
+		placed[f] = servers[ch.Place(f, 10, servers)].Port
+	}
+	// only the files of the removed server move
+	moved := 0
+	for f, port := range placed {
+		got := servers[:2][ch.Place(f, 10, servers[:2])].Port
+		if port != 3 && got != port {
+			moved++
+		}
+	}
+	if moved > 0 {
+		t.Errorf("%d files moved between servers that were not removed", moved)
+	}
+
+	tc := TwoChoices()
+	for range 100 {
+		if i := tc.Place("f", 10, servers); i == 0 {
+			t.Fatal("two choices picked the most loaded server")
+		}
+	}
+}
+
+func TestRegisterFileCreationPlacement(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir(), WithPlacement(RoundRobin()), WithReplication(1))
+	defer s.Stop()
+	// the first file server is registered by newTestServer
+	var c = s.serverByPort(1).client
+	s.serverByPort(2).client = c
+
+	var ports []int32
+	for i := range 4 {
+		res, err := s.RegisterFileCreation(ctx, &RecRequest{Name: fmt.Sprintf("%d.txt", i), FileSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports = append(ports, res.Port)
+	}
+	if fmt.Sprint(ports) != "[1 2 1 2]" {
+		t.Errorf("expected the files to alternate between the servers, got %v", ports)
+	}
+}
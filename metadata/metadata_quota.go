@@ -0,0 +1,217 @@
+package metadata
+
+import (
+	context "context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SetQuota limits the bytes of file data and the number of entries below a
+// directory. A limit of 0 removes it. Usage above a lowered limit is kept,
+// only new files and directories are refused.
+func (s *MetaDataServer) SetQuota(ctx context.Context, req *SetQuotaRequest) (*SetQuotaResponse, error) {
+	if req.MaxBytes < 0 || req.MaxEntries < 0 {
+		return nil, fmt.Errorf("negative quota provided: %d bytes, %d entries", req.MaxBytes, req.MaxEntries)
+	}
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	e := &logEntry{
+		Op:           opSetQuota,
+		Path:         p,
+		QuotaBytes:   req.MaxBytes,
+		QuotaEntries: req.MaxEntries,
+		Time:         time.Now().UnixNano(),
+	}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to set quota", "dir", e.Path, "error", err)
+		return nil, err
+	}
+	return &SetQuotaResponse{}, nil
+}
+
+func (s *MetaDataServer) applySetQuota(e *logEntry, persist func() error) error {
+	d, err := s.own(e.Path)
+	if err != nil || !d.isDir {
+		return fmt.Errorf("the directory %s doesn't exist", e.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	d.quotaBytes = e.QuotaBytes
+	d.quotaEntries = e.QuotaEntries
+	return nil
+}
+
+// GetQuota returns the quota of a directory together with the bytes and
+// entries currently below it. Directories without a quota report their
+// usage with limits of 0.
+func (s *MetaDataServer) GetQuota(ctx context.Context, req *QuotaRequest) (*QuotaResponse, error) {
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	d, err := s.rootDir.walkTo(p)
+	if err != nil || !d.isDir {
+		return nil, fmt.Errorf("the directory %s doesn't exist", p)
+	}
+	bytes, entries := d.used()
+	return &QuotaResponse{
+		MaxBytes:    d.quotaBytes,
+		MaxEntries:  d.quotaEntries,
+		UsedBytes:   bytes,
+		UsedEntries: entries,
+	}, nil
+}
+
+// checkQuota fails with ResourceExhausted if adding bytes and entries below
+// dir would exceed the quota of dir or of one of the directories above it.
+// It only refuses mutations early, before they are committed, the quota is
+// enforced when they are applied, see reserveQuota.
+func (s *MetaDataServer) checkQuota(dir string, bytes, entries int64) error {
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	for _, d := range s.dirsOn(dir) {
+		if err := d.checkQuota(bytes, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMoveQuota is checkQuota for moving the entry at oldName to newName.
+func (s *MetaDataServer) checkMoveQuota(oldName, newName string) error {
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	return s.moveQuota(oldName, newName)
+}
+
+// moveQuota fails with ResourceExhausted if moving the entry at oldName to
+// newName would exceed a quota. The move only adds to the directories above
+// newName that are not above oldName, and an entry replaced at newName frees
+// its share. The caller must hold muDir.
+func (s *MetaDataServer) moveQuota(oldName, newName string) error {
+	src, err := s.rootDir.walkTo(oldName)
+	if err != nil {
+		// reported when the rename is applied
+		return nil
+	}
+	bytes, entries := src.usage()
+	if dst, err := s.rootDir.walkTo(newName); err == nil {
+		b, n := dst.usage()
+		bytes, entries = bytes-b, entries-n
+	}
+	above := make(map[*fileInfo]bool)
+	for _, d := range s.dirsOn(path.Dir(oldName)) {
+		above[d] = true
+	}
+	for _, d := range s.dirsOn(path.Dir(newName)) {
+		if above[d] {
+			continue
+		}
+		if err := d.checkQuota(bytes, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkQuota fails with ResourceExhausted if adding bytes and entries below
+// the directory d would exceed its quota. Only the limits of what is added
+// are checked, so that e.g. a commit adding no entry is not refused by a
+// lowered entry limit.
+func (d *fileInfo) checkQuota(bytes, entries int64) error {
+	name := d.fullPath
+	if name == "" {
+		name = "."
+	}
+	usedBytes, usedEntries := d.used()
+	if d.quotaBytes > 0 && bytes > 0 && usedBytes+bytes > d.quotaBytes {
+		return status.Errorf(codes.ResourceExhausted, "quota of %s exceeded: %d of %d bytes used, %d more requested", name, usedBytes, d.quotaBytes, bytes)
+	}
+	if d.quotaEntries > 0 && entries > 0 && usedEntries+entries > d.quotaEntries {
+		return status.Errorf(codes.ResourceExhausted, "quota of %s exceeded: %d of %d entries used", name, usedEntries, d.quotaEntries)
+	}
+	return nil
+}
+
+// dirsOn returns the directories from the root down to dir, or as far as
+// they exist. The caller must hold muDir, dir itself is not locked so that
+// it may already be write locked by the caller.
+func (s *MetaDataServer) dirsOn(dir string) []*fileInfo {
+	d := s.rootDir
+	dirs := []*fileInfo{d}
+	for _, part := range strings.Split(path.Clean(dir), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		d.rlock()
+		next := d.lookup(part)
+		d.runlock()
+		if next == nil || !next.isDir {
+			break
+		}
+		d = next
+		dirs = append(dirs, d)
+	}
+	return dirs
+}
+
+// reserveQuota adds bytes and entries to the usage of dir and of the
+// directories above it, or fails with ResourceExhausted if that would exceed
+// one of their quotas. Mutations reserve what they add while they are
+// applied, before they are persisted, so that concurrent ones cannot exceed
+// a quota together. The caller must hold muDir and give the reservation
+// back with addUsage if the mutation fails after all.
+func (s *MetaDataServer) reserveQuota(dir string, bytes, entries int64) error {
+	// looked up before muQuota is taken, other mutations wait for it while
+	// holding the lock of their directory
+	dirs := s.dirsOn(dir)
+	s.muQuota.Lock()
+	defer s.muQuota.Unlock()
+	for _, d := range dirs {
+		if err := d.checkQuota(bytes, entries); err != nil {
+			return err
+		}
+	}
+	for _, d := range dirs {
+		atomic.AddInt64(&d.usedBytes, bytes)
+		atomic.AddInt64(&d.usedEntries, entries)
+	}
+	return nil
+}
+
+// addUsage accounts bytes and entries added below dir in dir and all the
+// directories above it.
+func (s *MetaDataServer) addUsage(dir string, bytes, entries int64) {
+	for _, d := range s.dirsOn(dir) {
+		atomic.AddInt64(&d.usedBytes, bytes)
+		atomic.AddInt64(&d.usedEntries, entries)
+	}
+}
+
+// used returns the bytes of file data and the number of entries below the
+// directory d.
+func (d *fileInfo) used() (bytes, entries int64) {
+	return atomic.LoadInt64(&d.usedBytes), atomic.LoadInt64(&d.usedEntries)
+}
+
+// usage returns what the entry f takes of the quotas of the directories
+// above it, including the entry itself.
+func (f *fileInfo) usage() (bytes, entries int64) {
+	if !f.isDir {
+		return f.size, 1
+	}
+	bytes, entries = f.used()
+	return bytes, entries + 1
+}
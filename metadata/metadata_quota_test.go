@@ -0,0 +1,193 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuota(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetQuota(ctx, &SetQuotaRequest{Name: "a", MaxBytes: 100, MaxEntries: 3}); err != nil {
+		t.Fatal(err)
+	}
+	exhausted := func(err error) {
+		t.Helper()
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("expected the quota to be exceeded, got %v", err)
+		}
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "a/f", FileSize: 60}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := createFile(ctx, s, &RecRequest{Name: "a/g", FileSize: 50})
+	exhausted(err)
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "a/b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "a/b/h", FileSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.MkDir(ctx, &MkDirRequest{Name: "a/b/c"})
+	exhausted(err)
+
+	usage := func(name string, bytes, entries int64) {
+		t.Helper()
+		q, err := s.GetQuota(ctx, &QuotaRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.UsedBytes != bytes || q.UsedEntries != entries {
+			t.Errorf("expected %s to use %d bytes and %d entries, got %d and %d", name, bytes, entries, q.UsedBytes, q.UsedEntries)
+		}
+	}
+	usage("a", 70, 3)
+	usage(".", 70, 4)
+
+	// usage is rebuilt from the journal and from snapshots
+	s.Stop()
+	s = newTestServer(t, dir)
+	usage("a", 70, 3)
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	usage("a", 70, 3)
+	if q, err := s.GetQuota(ctx, &QuotaRequest{Name: "a"}); err != nil || q.MaxBytes != 100 || q.MaxEntries != 3 {
+		t.Errorf("expected the quota to survive a restart, got %v, %v", q, err)
+	}
+
+	// removing and moving entries frees their share of the quota
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "a/f"}); err != nil {
+		t.Fatal(err)
+	}
+	usage("a", 10, 2)
+	if _, err := createFile(ctx, s, &RecRequest{Name: "a/g", FileSize: 50}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "a/b", NewName: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	usage("a", 50, 1)
+	usage("b", 10, 1)
+	usage(".", 60, 4)
+	if _, err := s.RmDir(ctx, &RmDirRequest{Name: "b", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	usage(".", 50, 2)
+
+	// quotas cannot be bypassed with negative sizes, by writing more than
+	// registered or by moving entries in
+	if _, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "a/neg", FileSize: -100}); err == nil {
+		t.Error("expected a negative size to be rejected")
+	}
+	rec, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "a/grow", FileSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.CommitFile(ctx, &CommitFileRequest{Name: "a/grow", ObjectId: rec.ObjectId, Size: 1000})
+	exhausted(err)
+	if _, err := s.CommitFile(ctx, &CommitFileRequest{Name: "a/grow", ObjectId: rec.ObjectId, Size: 40}); err != nil {
+		t.Errorf("expected data within the quota to be committed, got %v", err)
+	}
+	usage("a", 90, 2)
+	if _, err := createFile(ctx, s, &RecRequest{Name: "big", FileSize: 20}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Rename(ctx, &RenameRequest{OldName: "big", NewName: "a/big"})
+	exhausted(err)
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "a/grow", NewName: "a/g"}); err != nil {
+		t.Errorf("expected replacing an entry within the directory to keep to the quota, got %v", err)
+	}
+	usage("a", 40, 1)
+}
+
+func TestQuotaApplied(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir())
+	defer s.Stop()
+	for _, d := range []string{"a", "a/x", "a/y"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.SetQuota(ctx, &SetQuotaRequest{Name: "a", MaxBytes: 100, MaxEntries: 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries that passed the check of their request are checked again when
+	// they are applied
+	for _, e := range []*logEntry{
+		{Op: opCreateFile, Path: "a/x/big", ObjectID: "big", Size: 101},
+		{Op: opMkDir, Path: "a/x/d1"},
+		{Op: opMkDir, Path: "a/x/d2"},
+		{Op: opMkDir, Path: "a/x/d3"},
+		{Op: opMkDir, Path: "a/x/d4"},
+		{Op: opMkDir, Path: "a/x/d5"},
+		{Op: opMkDir, Path: "a/x/d6"},
+		{Op: opMkDir, Path: "a/x/d7"},
+	} {
+		e.Time = time.Now().UnixNano()
+		err := s.commit(ctx, e)
+		if e.Path == "a/x/big" || e.Path == "a/x/d7" {
+			if status.Code(err) != codes.ResourceExhausted {
+				t.Errorf("expected %s to exceed the quota, got %v", e.Path, err)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Stat(ctx, &StatRequest{Name: "a/x/big"}); err == nil {
+		t.Error("expected the file exceeding the quota not to be created")
+	}
+	if _, err := s.RmDir(ctx, &RmDirRequest{Name: "a/x", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent creations in different directories do not exceed the
+	// quota together
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("a/y/f%d", i)
+			if i%2 == 1 {
+				name = fmt.Sprintf("a/f%d", i)
+			}
+			if _, err := createFile(ctx, s, &RecRequest{Name: name, FileSize: 10}); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			} else if status.Code(err) != codes.ResourceExhausted {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	q, err := s.GetQuota(ctx, &QuotaRequest{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.UsedBytes > q.MaxBytes || q.UsedEntries > q.MaxEntries {
+		t.Errorf("expected the usage to stay within the quota, got %d bytes and %d entries", q.UsedBytes, q.UsedEntries)
+	}
+	if int64(created) != q.UsedEntries-1 {
+		t.Errorf("expected %d files to be accounted, got %d entries", created, q.UsedEntries-1)
+	}
+}
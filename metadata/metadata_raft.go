@@ -0,0 +1,986 @@
+package metadata
+
+import (
+	context "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// LeaderTrailer is the trailer in which a follower tells clients the port of
+// the leader they have to retry a rejected request on.
+const LeaderTrailer = "mds-leader"
+
+// maximum number of entries sent with a single AppendEntries request
+const maxAppendEntries = 512
+
+// NotLeaderError is returned for requests that reach a follower of a
+// replicated metadata service. Leader is 0 while no leader is known.
+type NotLeaderError struct {
+	Leader int
+}
+
+func (e NotLeaderError) Error() string {
+	if e.Leader == 0 {
+		return "not the leader, no leader has been elected yet"
+	}
+	return fmt.Sprintf("not the leader, the leader listens on port %d", e.Leader)
+}
+
+type raftRole int
+
+const (
+	follower raftRole = iota
+	candidate
+	leader
+)
+
+// proposal is a journal entry proposed by the leader that is waiting to be applied.
+type proposal struct {
+	term uint64
+	done chan error
+}
+
+// raftNode replicates the journal between the metadata servers of a cluster
+// using the Raft consensus algorithm. Only the leader accepts mutations: it
+// appends them to its log and applies them once a majority of the servers has
+// stored them. The followers apply the same entries in the same order, so
+// every server ends up with the same namespace.
+type raftNode struct {
+	UnimplementedRaftServiceServer
+	s *MetaDataServer
+	// the port of a server identifies it within the cluster
+	id              int
+	peers           []int
+	electionTimeout time.Duration
+
+	mu          sync.Mutex
+	role        raftRole
+	leader      int
+	log         *raftLog
+	commitIndex uint64
+	lastApplied uint64
+	// when the leader was last heard from or a vote was granted
+	lastContact time.Time
+	// when the server last became the leader
+	leaderSince time.Time
+	// index of the first entry of the leader's term
+	termStart  uint64
+	nextIndex  map[int]uint64
+	matchIndex map[int]uint64
+	// when the last request a peer answered in the current term was sent,
+	// see hasLease
+	acked   map[int]time.Time
+	waiting map[uint64]*proposal
+	// signalled when commitIndex advances or the node is stopped
+	applyCond *sync.Cond
+	stopped   bool
+
+	// serializes applying entries and installing snapshots
+	muApply sync.Mutex
+	// wakes the replicator of a peer up when there are entries to send
+	notify  map[int]chan struct{}
+	clients map[int]RaftServiceClient
+	conns   []*grpc.ClientConn
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+// newRaftNode returns the raft member of s. Entries up to applied have been
+// restored from the journal already.
+func newRaftNode(s *MetaDataServer, applied uint64) (*raftNode, error) {
+	l, err := openRaftLog(s.opts.dataDir, s.opts.syncWrites)
+	if err != nil {
+		return nil, err
+	}
+	if l.lastIndex() < applied {
+		// the journal was written before the server was part of a cluster
+		if err := l.compact(applied, 0); err != nil {
+			return nil, err
+		}
+	}
+	r := &raftNode{
+		s:               s,
+		id:              s.port,
+		peers:           s.opts.peers,
+		electionTimeout: s.opts.electionTimeout,
+		log:             l,
+		commitIndex:     applied,
+		lastApplied:     applied,
+		nextIndex:       make(map[int]uint64),
+		matchIndex:      make(map[int]uint64),
+		acked:           make(map[int]time.Time),
+		waiting:         make(map[uint64]*proposal),
+		notify:          make(map[int]chan struct{}),
+		clients:         make(map[int]RaftServiceClient),
+		stop:            make(chan struct{}),
+	}
+	r.applyCond = sync.NewCond(&r.mu)
+	// a restarted peer has to be reconnected to before it times out
+	b := backoff.DefaultConfig
+	b.MaxDelay = r.electionTimeout / 2
+	for _, p := range r.peers {
+		conn, err := grpc.NewClient(fmt.Sprintf(":%d", p),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithConnectParams(grpc.ConnectParams{Backoff: b, MinConnectTimeout: r.electionTimeout}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.conns = append(r.conns, conn)
+		r.clients[p] = NewRaftServiceClient(conn)
+		r.notify[p] = make(chan struct{}, 1)
+	}
+	return r, nil
+}
+
+// start starts the election timer and applies committed entries. The raft
+// service has to be served before.
+func (r *raftNode) start() {
+	r.mu.Lock()
+	r.lastContact = time.Now()
+	r.mu.Unlock()
+	r.done.Add(2)
+	go r.run()
+	go r.applyLoop()
+}
+
+func (r *raftNode) close() error {
+	r.mu.Lock()
+	r.stopped = true
+	r.failProposals()
+	r.applyCond.Broadcast()
+	r.mu.Unlock()
+	close(r.stop)
+	r.done.Wait()
+	for _, c := range r.conns {
+		c.Close()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.log.close()
+}
+
+func (r *raftNode) term() uint64 {
+	return r.log.state.Term
+}
+
+// isLeader reports whether the server is the leader and otherwise returns
+// the leader if it is known. A new leader only counts once it applied the
+// entries committed before its term, which a restarted server replays from
+// its log, so that it does not serve reads from an outdated namespace. A
+// leader that lost its lease may have been replaced already, so it does not
+// count either. Until then no leader is known.
+func (r *raftNode) isLeader() (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role == leader && (r.lastApplied < r.termStart || !r.hasLease()) {
+		return 0, false
+	}
+	return r.leader, r.role == leader
+}
+
+// leading returns the term in which the server leads the cluster and when
+// it was elected, or the leader if it is not the leader. Like isLeader it
+// requires the leader's lease.
+func (r *raftNode) leading() (leaderPort int, term uint64, since time.Time, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.role == leader && !r.hasLease() {
+		return 0, r.term(), r.leaderSince, false
+	}
+	return r.leader, r.term(), r.leaderSince, r.role == leader
+}
+
+// hasLease reports whether a majority of the servers, the leader included,
+// answered a request of the leader sent within the last election timeout.
+// None of them votes for another candidate until the timeout passed since
+// it heard from the leader, see RequestVote, so no other leader can have
+// been elected in the meantime. r.mu must be held.
+func (r *raftNode) hasLease() bool {
+	acked := []time.Time{time.Now()}
+	for _, p := range r.peers {
+		acked = append(acked, r.acked[p])
+	}
+	// the majority is the leader and the peers that answered most recently
+	slices.SortFunc(acked, func(a, b time.Time) int { return b.Compare(a) })
+	return time.Since(acked[len(acked)/2]) < r.electionTimeout
+}
+
+// heardFromLeader reports whether the server is the leader or heard from it
+// within the election timeout, when it does not help to elect another one.
+// r.mu must be held.
+func (r *raftNode) heardFromLeader() bool {
+	switch r.role {
+	case leader:
+		return r.hasLease()
+	case follower:
+		return r.leader != 0 && time.Since(r.lastContact) < r.electionTimeout
+	}
+	return false
+}
+
+// run starts an election whenever the leader has not been heard from for
+// longer than the election timeout. A leader that has not heard from a
+// majority of the servers for as long steps down, it may be cut off from
+// them.
+func (r *raftNode) run() {
+	defer r.done.Done()
+	ticker := time.NewTicker(r.electionTimeout / 10)
+	defer ticker.Stop()
+	timeout := r.randomTimeout()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		if r.role == leader && !r.hasLease() {
+			slog.Warn("lost contact to the majority of the cluster", "port", r.id, "term", r.term())
+			// the proposals fail without sending their clients back here
+			r.leader = 0
+			r.becomeFollower(r.term(), 0)
+			r.lastContact = time.Now()
+		}
+		expired := r.role != leader && time.Since(r.lastContact) > timeout
+		r.mu.Unlock()
+		if expired {
+			r.campaign()
+			timeout = r.randomTimeout()
+		}
+	}
+}
+
+// randomTimeout spreads the election timeouts of the servers so that one of
+// them usually wins before the others start competing elections.
+func (r *raftNode) randomTimeout() time.Duration {
+	return r.electionTimeout + time.Duration(rand.Int63n(int64(r.electionTimeout)))
+}
+
+func (r *raftNode) campaign() {
+	r.mu.Lock()
+	term := r.term() + 1
+	if err := r.log.setState(term, r.id); err != nil {
+		r.mu.Unlock()
+		slog.Error("could not start election", "port", r.id, "err", err)
+		return
+	}
+	r.role = candidate
+	r.leader = 0
+	r.lastContact = time.Now()
+	// a granted vote answers the request, the voter follows no other leader
+	// for an election timeout
+	clear(r.acked)
+	sent := r.lastContact
+	req := &VoteRequest{
+		Term:         term,
+		Candidate:    int32(r.id),
+		LastLogIndex: r.log.lastIndex(),
+		LastLogTerm:  r.log.lastTerm(),
+	}
+	r.mu.Unlock()
+	slog.Info("starting election", "port", r.id, "term", term)
+
+	votes := 1
+	for _, p := range r.peers {
+		r.done.Add(1)
+		go func() {
+			defer r.done.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), r.electionTimeout)
+			defer cancel()
+			res, err := r.clients[p].RequestVote(ctx, req)
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if res.Term > r.term() {
+				r.becomeFollower(res.Term, 0)
+				return
+			}
+			if r.role != candidate || r.term() != term || !res.Granted {
+				return
+			}
+			r.acked[p] = sent
+			votes++
+			if votes > (len(r.peers)+1)/2 {
+				r.becomeLeader()
+			}
+		}()
+	}
+}
+
+// becomeFollower makes the server follow the leader listening on port in
+// term. r.mu must be held.
+func (r *raftNode) becomeFollower(term uint64, port int) {
+	if term > r.term() {
+		if err := r.log.setState(term, 0); err != nil {
+			slog.Error("could not persist term", "port", r.id, "err", err)
+		}
+		r.leader = 0
+	}
+	if r.role == leader {
+		slog.Info("stepping down as leader", "port", r.id, "term", term)
+		r.failProposals()
+	}
+	r.role = follower
+	if port != 0 {
+		r.leader = port
+	}
+}
+
+// becomeLeader takes over after the server won an election. r.mu must be held.
+func (r *raftNode) becomeLeader() {
+	slog.Info("elected leader", "port", r.id, "term", r.term())
+	r.role = leader
+	r.leader = r.id
+	r.leaderSince = time.Now()
+	for _, p := range r.peers {
+		r.nextIndex[p] = r.log.lastIndex() + 1
+		r.matchIndex[p] = 0
+	}
+	// entries of earlier terms are only known to be committed once an entry
+	// of the current term is
+	index := r.log.lastIndex() + 1
+	r.termStart = index
+	if err := r.log.append(&raftEntry{Index: index, Term: r.term(), Entry: &logEntry{Seq: index, Op: opNoop}}); err != nil {
+		slog.Error("could not append to raft log", "port", r.id, "err", err)
+	}
+	for _, p := range r.peers {
+		r.done.Add(1)
+		go r.replicate(p, r.term())
+	}
+}
+
+// failProposals fails all proposals that are waiting to be applied. Their
+// entries may still be committed by the next leader. r.mu must be held.
+func (r *raftNode) failProposals() {
+	for i, p := range r.waiting {
+		p.done <- NotLeaderError{Leader: r.leader}
+		delete(r.waiting, i)
+	}
+}
+
+// propose replicates e and waits until it has been applied or ctx is done,
+// when it fails with Unavailable. The entry may still be committed then.
+func (r *raftNode) propose(ctx context.Context, e *logEntry) error {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return errors.New("metadata server is stopped")
+	}
+	if r.role != leader {
+		r.mu.Unlock()
+		return NotLeaderError{Leader: r.leader}
+	}
+	e.Seq = r.log.lastIndex() + 1
+	if err := r.log.append(&raftEntry{Index: e.Seq, Term: r.term(), Entry: e}); err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	p := &proposal{term: r.term(), done: make(chan error, 1)}
+	r.waiting[e.Seq] = p
+	r.mu.Unlock()
+	for _, c := range r.notify {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.waiting[e.Seq] == p {
+		delete(r.waiting, e.Seq)
+	} else {
+		// applied or failed in the meantime
+		return <-p.done
+	}
+	return status.Errorf(codes.Unavailable, "entry %d was not applied before the request ended, it may still be: %v", e.Seq, ctx.Err())
+}
+
+// replicate sends new entries and heartbeats to peer for as long as the
+// server is the leader of term.
+func (r *raftNode) replicate(peer int, term uint64) {
+	defer r.done.Done()
+	heartbeat := time.NewTicker(r.electionTimeout / 4)
+	defer heartbeat.Stop()
+	for r.sendEntries(peer, term) {
+		select {
+		case <-r.stop:
+			return
+		case <-r.notify[peer]:
+		case <-heartbeat.C:
+		}
+	}
+}
+
+// sendEntries sends the entries peer is missing. It returns false once the
+// server is no longer the leader of term.
+func (r *raftNode) sendEntries(peer int, term uint64) bool {
+	r.mu.Lock()
+	if r.role != leader || r.term() != term {
+		r.mu.Unlock()
+		return false
+	}
+	next := r.nextIndex[peer]
+	if next <= r.log.state.SnapIndex {
+		r.mu.Unlock()
+		return r.sendSnapshot(peer, term)
+	}
+	prevTerm, _ := r.log.term(next - 1)
+	req := &AppendRequest{
+		Term:         term,
+		Leader:       int32(r.id),
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  prevTerm,
+		LeaderCommit: r.commitIndex,
+	}
+	for _, e := range r.log.slice(next, maxAppendEntries) {
+		data, err := json.Marshal(e.Entry)
+		if err != nil {
+			r.mu.Unlock()
+			slog.Error("could not encode raft entry", "index", e.Index, "err", err)
+			return true
+		}
+		req.Entries = append(req.Entries, &RaftEntry{Index: e.Index, Term: e.Term, Data: data})
+	}
+	r.mu.Unlock()
+
+	sent := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), r.electionTimeout)
+	defer cancel()
+	res, err := r.clients[peer].AppendEntries(ctx, req)
+	if err != nil {
+		// retried with the next heartbeat
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if res.Term > r.term() {
+		r.becomeFollower(res.Term, 0)
+		return false
+	}
+	if r.role != leader || r.term() != term {
+		return false
+	}
+	r.ack(peer, sent)
+	if res.Success {
+		match := req.PrevLogIndex + uint64(len(req.Entries))
+		r.matchIndex[peer] = max(r.matchIndex[peer], match)
+		r.nextIndex[peer] = max(r.nextIndex[peer], match+1)
+		r.advanceCommit()
+	} else {
+		r.nextIndex[peer] = max(1, min(res.ConflictIndex, req.PrevLogIndex))
+	}
+	if r.nextIndex[peer] <= r.log.lastIndex() {
+		// more to send, do not wait for the next heartbeat
+		select {
+		case r.notify[peer] <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// sendSnapshot sends the latest snapshot to a peer that is missing entries
+// which have been compacted away.
+func (r *raftNode) sendSnapshot(peer int, term uint64) bool {
+	data, err := r.s.journal.readSnapshot()
+	if err != nil || data == nil {
+		slog.Error("could not read snapshot", "err", err)
+		return true
+	}
+	var head struct {
+		Seq uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		slog.Error("could not decode snapshot", "err", err)
+		return true
+	}
+	r.mu.Lock()
+	snapTerm, ok := r.log.term(head.Seq)
+	r.mu.Unlock()
+	if !ok {
+		slog.Error("snapshot is older than the raft log", "seq", head.Seq)
+		return true
+	}
+
+	sent := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*r.electionTimeout)
+	defer cancel()
+	res, err := r.clients[peer].InstallSnapshot(ctx, &InstallSnapshotRequest{
+		Term:              term,
+		Leader:            int32(r.id),
+		LastIncludedIndex: head.Seq,
+		LastIncludedTerm:  snapTerm,
+		Data:              data,
+	})
+	if err != nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if res.Term > r.term() {
+		r.becomeFollower(res.Term, 0)
+		return false
+	}
+	if r.role != leader || r.term() != term {
+		return false
+	}
+	r.ack(peer, sent)
+	r.matchIndex[peer] = max(r.matchIndex[peer], head.Seq)
+	r.nextIndex[peer] = max(r.nextIndex[peer], head.Seq+1)
+	r.advanceCommit()
+	select {
+	case r.notify[peer] <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// ack records that peer answered a request of the current term sent at
+// sent. r.mu must be held.
+func (r *raftNode) ack(peer int, sent time.Time) {
+	if sent.After(r.acked[peer]) {
+		r.acked[peer] = sent
+	}
+}
+
+// advanceCommit commits the entries stored on a majority of the servers.
+// r.mu must be held.
+func (r *raftNode) advanceCommit() {
+	matches := []uint64{r.log.lastIndex()}
+	for _, p := range r.peers {
+		matches = append(matches, r.matchIndex[p])
+	}
+	slices.Sort(matches)
+	n := matches[(len(matches)-1)/2]
+	// only entries of the current term are committed by counting replicas
+	if t, ok := r.log.term(n); n > r.commitIndex && ok && t == r.term() {
+		r.commitIndex = n
+		r.applyCond.Broadcast()
+	}
+}
+
+// applyLoop applies committed entries to the namespace in log order.
+func (r *raftNode) applyLoop() {
+	defer r.done.Done()
+	for {
+		r.mu.Lock()
+		for r.commitIndex <= r.lastApplied && !r.stopped {
+			r.applyCond.Wait()
+		}
+		stopped := r.stopped
+		r.mu.Unlock()
+		if stopped {
+			return
+		}
+
+		r.muApply.Lock()
+		r.mu.Lock()
+		// a snapshot may have been installed in the meantime
+		var entries []*raftEntry
+		if r.commitIndex > r.lastApplied {
+			entries = r.log.slice(r.lastApplied+1, int(r.commitIndex-r.lastApplied))
+		}
+		r.mu.Unlock()
+
+		for _, e := range entries {
+			err := r.s.applyReplicated(e.Entry)
+			r.mu.Lock()
+			r.lastApplied = e.Index
+			if p, ok := r.waiting[e.Index]; ok {
+				delete(r.waiting, e.Index)
+				if p.term != e.Term {
+					// the proposal was overwritten by another leader
+					err = NotLeaderError{Leader: r.leader}
+				}
+				p.done <- err
+			}
+			r.mu.Unlock()
+		}
+		r.muApply.Unlock()
+		r.s.maybeCompact()
+	}
+}
+
+// compact drops the entries contained in the snapshot taken at index.
+func (r *raftNode) compact(index uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	term, ok := r.log.term(index)
+	if !ok {
+		return nil
+	}
+	return r.log.compact(index, term)
+}
+
+func (r *raftNode) RequestVote(ctx context.Context, req *VoteRequest) (*VoteResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return nil, errors.New("metadata server is stopped")
+	}
+	// a candidate that did not hear from the leader, e.g. because it is cut
+	// off from it, does not replace it while the leader holds its lease
+	if req.Term > r.term() && r.heardFromLeader() {
+		return &VoteResponse{Term: r.term()}, nil
+	}
+	if req.Term > r.term() {
+		r.becomeFollower(req.Term, 0)
+	}
+	res := &VoteResponse{Term: r.term()}
+	if req.Term < r.term() {
+		return res, nil
+	}
+	// only vote for candidates that have all entries this server has
+	upToDate := req.LastLogTerm > r.log.lastTerm() ||
+		(req.LastLogTerm == r.log.lastTerm() && req.LastLogIndex >= r.log.lastIndex())
+	voted := r.log.state.VotedFor
+	if (voted == 0 || voted == int(req.Candidate)) && upToDate {
+		if err := r.log.setState(req.Term, int(req.Candidate)); err != nil {
+			return nil, err
+		}
+		r.lastContact = time.Now()
+		res.Granted = true
+	}
+	return res, nil
+}
+
+func (r *raftNode) AppendEntries(ctx context.Context, req *AppendRequest) (*AppendResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return nil, errors.New("metadata server is stopped")
+	}
+	if req.Term < r.term() {
+		return &AppendResponse{Term: r.term()}, nil
+	}
+	r.becomeFollower(req.Term, int(req.Leader))
+	r.lastContact = time.Now()
+	res := &AppendResponse{Term: r.term()}
+
+	if req.PrevLogIndex > r.log.lastIndex() {
+		res.ConflictIndex = r.log.lastIndex() + 1
+		return res, nil
+	}
+	// entries before the snapshot are committed and match the leader's
+	if t, ok := r.log.term(req.PrevLogIndex); ok && t != req.PrevLogTerm {
+		// skip the whole conflicting term instead of going back one entry at a time
+		i := req.PrevLogIndex
+		for i > r.log.state.SnapIndex+1 {
+			if prev, _ := r.log.term(i - 1); prev != t {
+				break
+			}
+			i--
+		}
+		res.ConflictIndex = i
+		return res, nil
+	}
+	for i, re := range req.Entries {
+		if re.Index <= r.log.state.SnapIndex {
+			continue
+		}
+		if t, ok := r.log.term(re.Index); ok {
+			if t == re.Term {
+				continue
+			}
+			if err := r.log.truncate(re.Index); err != nil {
+				return nil, err
+			}
+		}
+		entries, err := decodeEntries(req.Entries[i:])
+		if err != nil {
+			return nil, err
+		}
+		if err := r.log.append(entries...); err != nil {
+			return nil, err
+		}
+		break
+	}
+	last := req.PrevLogIndex + uint64(len(req.Entries))
+	if c := min(req.LeaderCommit, last); c > r.commitIndex {
+		r.commitIndex = c
+		r.applyCond.Broadcast()
+	}
+	res.Success = true
+	return res, nil
+}
+
+func decodeEntries(in []*RaftEntry) ([]*raftEntry, error) {
+	var entries []*raftEntry
+	for _, re := range in {
+		e := new(logEntry)
+		if err := json.Unmarshal(re.Data, e); err != nil {
+			return nil, fmt.Errorf("corrupt raft entry %d: %w", re.Index, err)
+		}
+		entries = append(entries, &raftEntry{Index: re.Index, Term: re.Term, Entry: e})
+	}
+	return entries, nil
+}
+
+func (r *raftNode) InstallSnapshot(ctx context.Context, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return nil, errors.New("metadata server is stopped")
+	}
+	if req.Term < r.term() {
+		defer r.mu.Unlock()
+		return &InstallSnapshotResponse{Term: r.term()}, nil
+	}
+	r.becomeFollower(req.Term, int(req.Leader))
+	r.lastContact = time.Now()
+	r.mu.Unlock()
+
+	snap := new(snapshot)
+	if err := json.Unmarshal(req.Data, snap); err != nil {
+		return nil, fmt.Errorf("corrupt snapshot: %w", err)
+	}
+	// waits for the entries being applied
+	r.muApply.Lock()
+	defer r.muApply.Unlock()
+	r.mu.Lock()
+	applied := r.lastApplied
+	r.mu.Unlock()
+	if req.LastIncludedIndex > applied {
+		slog.Info("installing snapshot", "port", r.id, "seq", snap.Seq)
+		if err := r.s.installSnapshot(snap); err != nil {
+			return nil, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.log.compact(req.LastIncludedIndex, req.LastIncludedTerm); err != nil {
+		return nil, err
+	}
+	r.lastApplied = max(r.lastApplied, req.LastIncludedIndex)
+	r.commitIndex = max(r.commitIndex, req.LastIncludedIndex)
+	return &InstallSnapshotResponse{Term: r.term()}, nil
+}
+
+// redirectToLeader rejects requests that reach a follower. The port of the
+// leader is sent in LeaderTrailer so that the client can retry there. Reads
+// are served by the leader as well, so that clients see their own writes.
+func (s *MetaDataServer) redirectToLeader(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if s.raft == nil || !leaderMethod(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	var res interface{}
+	var err error
+	if l, ok := s.raft.isLeader(); ok {
+		res, err = handler(ctx, req)
+	} else {
+		err = NotLeaderError{Leader: l}
+	}
+	var notLeader NotLeaderError
+	if !errors.As(err, &notLeader) {
+		return res, err
+	}
+	if notLeader.Leader == 0 {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	grpc.SetTrailer(ctx, grpcmd.Pairs(LeaderTrailer, strconv.Itoa(notLeader.Leader)))
+	return nil, status.Error(codes.FailedPrecondition, err.Error())
+}
+
+// leaderMethod reports whether the unary method is served by the leader,
+// which are all but Ping of the metadata and the shard service.
+func leaderMethod(method string) bool {
+	return (strings.HasPrefix(method, "/metadata.MetadataService/") || strings.HasPrefix(method, "/metadata.ShardService/")) &&
+		method != "/metadata.MetadataService/Ping"
+}
+
+// redirectStreamToLeader is redirectToLeader for streaming requests. Watch
+// is served by followers too, since they apply the same changes as the
+// leader and the watches resume by sequence number anyway.
+func (s *MetaDataServer) redirectStreamToLeader(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if s.raft == nil || !strings.HasPrefix(info.FullMethod, "/metadata.MetadataService/") || info.FullMethod == "/metadata.MetadataService/Watch" {
+		return handler(srv, ss)
+	}
+	l, ok := s.raft.isLeader()
+	if ok {
+		return handler(srv, ss)
+	}
+	err := NotLeaderError{Leader: l}
+	if l == 0 {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	ss.SetTrailer(grpcmd.Pairs(LeaderTrailer, strconv.Itoa(l)))
+	return status.Error(codes.FailedPrecondition, err.Error())
+}
+
+// how often a request is retried while the metadata servers elect a leader
+const maxLeaderRetries = 20
+
+// FollowLeader is a client interceptor that retries requests on the leader a follower redirects to. While
+// no leader is elected the request is retried after a short pause. Requests
+// whose symbolic links lead into another shard are retried there with the
+// path the links resolve to, see ShardTrailer.
+func FollowLeader(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	for range maxLeaderRetries {
+		var trailer grpcmd.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+		switch status.Code(err) {
+		case codes.FailedPrecondition:
+			leader := trailer.Get(LeaderTrailer)
+			if port, redirected, ok := followShard(trailer, req); ok {
+				slog.Debug("redirected to another metadata shard", "port", port)
+				leader, req = []string{port}, redirected
+			}
+			if len(leader) == 0 {
+				return err
+			}
+			slog.Debug("redirected to the metadata leader", "port", leader[0])
+			conn, err := grpc.NewClient(":"+leader[0], grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			cc = conn
+		case codes.Unavailable:
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(100 * time.Millisecond):
+			}
+		default:
+			return err
+		}
+	}
+	return errors.New("no metadata leader found")
+}
+
+// FollowLeaderStream is FollowLeader for streams with a single request, like
+// Find and WalkTree. A follower or a shard rejects them before sending
+// anything, so the stream is opened again on the leader or the other shard if
+// its first receive is redirected.
+func FollowLeaderStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil || desc.ClientStreams {
+		return stream, err
+	}
+	return &leaderStream{ClientStream: stream, ctx: ctx, desc: desc, cc: cc, method: method, streamer: streamer, opts: opts}, nil
+}
+
+// leaderStream is a server stream that follows redirects to the leader or
+// another shard until it received its first message.
+type leaderStream struct {
+	grpc.ClientStream
+	ctx      context.Context
+	desc     *grpc.StreamDesc
+	cc       *grpc.ClientConn
+	method   string
+	streamer grpc.Streamer
+	opts     []grpc.CallOption
+	// the request, sent again to the leader or the other shard
+	req any
+	// set once a message was received
+	received bool
+	// connection to the leader, closed once the stream ended
+	conn *grpc.ClientConn
+}
+
+func (s *leaderStream) SendMsg(m any) error {
+	s.req = m
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *leaderStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	for retries := 0; err != nil && !s.received && retries < maxLeaderRetries; retries++ {
+		var cc *grpc.ClientConn
+		switch status.Code(err) {
+		case codes.FailedPrecondition:
+			trailer := s.ClientStream.Trailer()
+			leader := trailer.Get(LeaderTrailer)
+			if port, redirected, ok := followShard(trailer, s.req); ok {
+				slog.Debug("redirected to another metadata shard", "port", port)
+				leader, s.req = []string{port}, redirected
+			}
+			if len(leader) == 0 {
+				return err
+			}
+			slog.Debug("redirected to the metadata leader", "port", leader[0])
+			if cc, err = grpc.NewClient(":"+leader[0], grpc.WithTransportCredentials(insecure.NewCredentials())); err != nil {
+				return err
+			}
+		case codes.Unavailable:
+			select {
+			case <-s.ctx.Done():
+				return err
+			case <-time.After(100 * time.Millisecond):
+			}
+			if cc = s.conn; cc == nil {
+				cc = s.cc
+			}
+		default:
+			return err
+		}
+		if cc != s.conn && cc != s.cc {
+			s.close()
+			s.conn = cc
+		}
+		if err = s.reopen(cc); err == nil {
+			err = s.ClientStream.RecvMsg(m)
+		}
+	}
+	if err != nil {
+		s.close()
+		return err
+	}
+	s.received = true
+	return nil
+}
+
+// reopen opens the stream again on cc and sends the request.
+func (s *leaderStream) reopen(cc *grpc.ClientConn) error {
+	stream, err := s.streamer(s.ctx, s.desc, cc, s.method, s.opts...)
+	if err != nil {
+		return err
+	}
+	s.ClientStream = stream
+	if err := stream.SendMsg(s.req); err != nil {
+		return err
+	}
+	return stream.CloseSend()
+}
+
+// close closes the connection to the leader if one was opened.
+func (s *leaderStream) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
@@ -0,0 +1,289 @@
+package metadata
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+const (
+	raftLogFile   = "raft.log"
+	raftStateFile = "raft-state.json"
+)
+
+// raftEntry is a journal entry together with its position in the replicated log.
+type raftEntry struct {
+	Index uint64    `json:"index"`
+	Term  uint64    `json:"term"`
+	Entry *logEntry `json:"entry"`
+}
+
+// raftState is the state a raft member has to remember across restarts
+// besides its log.
+type raftState struct {
+	Term uint64 `json:"term"`
+	// port of the candidate voted for in Term, 0 if none
+	VotedFor int `json:"votedFor,omitempty"`
+	// last entry dropped from the log because it is contained in a snapshot
+	SnapIndex uint64 `json:"snapIndex,omitempty"`
+	SnapTerm  uint64 `json:"snapTerm,omitempty"`
+}
+
+// raftLog stores the replicated log of a metadata server. It takes the place
+// of the journal's segments, after a restart the committed entries following
+// the snapshot are applied from it again. Besides them it holds entries that
+// are not committed yet and may be overwritten by a new leader.
+// It is not safe for concurrent use, raftNode guards it with its mutex.
+type raftLog struct {
+	dir  string
+	sync bool
+
+	state raftState
+	// entries after state.SnapIndex, entries[i].Index == state.SnapIndex+1+i
+	entries []*raftEntry
+	file    *os.File
+	w       *bufio.Writer
+}
+
+func openRaftLog(dir string, sync bool) (*raftLog, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	l := &raftLog{dir: dir, sync: sync}
+	b, err := os.ReadFile(filepath.Join(dir, raftStateFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &l.state); err != nil {
+			return nil, fmt.Errorf("corrupt raft state: %w", err)
+		}
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, raftLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	l.w = bufio.NewWriter(f)
+	return l, nil
+}
+
+// load reads the entries of the log file. Entries may appear more than once
+// if the log was cut off before the file was rewritten, the last one wins.
+func (l *raftLog) load() error {
+	p := filepath.Join(l.dir, raftLogFile)
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		e := new(raftEntry)
+		if err == io.EOF || json.Unmarshal(line, e) != nil {
+			slog.Warn("truncating torn raft log entry", "offset", offset)
+			return os.Truncate(p, offset)
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		if e.Index <= l.state.SnapIndex {
+			continue
+		}
+		if e.Index > l.lastIndex()+1 {
+			return fmt.Errorf("gap in raft log before index %d", e.Index)
+		}
+		l.entries = append(l.entries[:e.Index-l.state.SnapIndex-1], e)
+	}
+}
+
+func (l *raftLog) lastIndex() uint64 {
+	return l.state.SnapIndex + uint64(len(l.entries))
+}
+
+func (l *raftLog) lastTerm() uint64 {
+	if len(l.entries) == 0 {
+		return l.state.SnapTerm
+	}
+	return l.entries[len(l.entries)-1].Term
+}
+
+// term returns the term of the entry at index. It fails for entries that
+// have been compacted away, except for the last one.
+func (l *raftLog) term(index uint64) (uint64, bool) {
+	switch {
+	case index == l.state.SnapIndex:
+		return l.state.SnapTerm, true
+	case index < l.state.SnapIndex || index > l.lastIndex():
+		return 0, false
+	}
+	return l.entries[index-l.state.SnapIndex-1].Term, true
+}
+
+// slice returns up to max entries starting at from.
+func (l *raftLog) slice(from uint64, max int) []*raftEntry {
+	if from <= l.state.SnapIndex || from > l.lastIndex() {
+		return nil
+	}
+	entries := l.entries[from-l.state.SnapIndex-1:]
+	if len(entries) > max {
+		entries = entries[:max]
+	}
+	return entries
+}
+
+// append writes entries to the end of the log and returns once they are durable.
+func (l *raftLog) append(entries ...*raftEntry) error {
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := l.w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	if l.sync {
+		if err := l.file.Sync(); err != nil {
+			return err
+		}
+	}
+	l.entries = append(l.entries, entries...)
+	return nil
+}
+
+// truncate drops the entries starting at from. Entries from a previous leader
+// that conflict with the current one are removed this way.
+func (l *raftLog) truncate(from uint64) error {
+	if from <= l.state.SnapIndex || from > l.lastIndex() {
+		return nil
+	}
+	l.entries = l.entries[:from-l.state.SnapIndex-1]
+	return l.rewrite()
+}
+
+// compact drops the entries up to and including index, which are contained
+// in a snapshot. If the entry at index does not match term the whole log is
+// dropped, since it has been superseded by the snapshot.
+func (l *raftLog) compact(index, term uint64) error {
+	if index <= l.state.SnapIndex {
+		return nil
+	}
+	if t, ok := l.term(index); ok && t == term {
+		l.entries = l.entries[index-l.state.SnapIndex:]
+	} else {
+		l.entries = nil
+	}
+	l.state.SnapIndex = index
+	l.state.SnapTerm = term
+	// the state has to be saved first, it tells which entries of the old
+	// file to skip if the rewrite does not complete
+	if err := l.saveState(); err != nil {
+		return err
+	}
+	return l.rewrite()
+}
+
+// setState persists the current term and vote.
+func (l *raftLog) setState(term uint64, votedFor int) error {
+	l.state.Term = term
+	l.state.VotedFor = votedFor
+	return l.saveState()
+}
+
+func (l *raftLog) saveState() error {
+	b, err := json.Marshal(l.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(l.dir, raftStateFile), b, l.sync)
+}
+
+// rewrite replaces the log file with the entries held in memory.
+func (l *raftLog) rewrite() error {
+	tmp := filepath.Join(l.dir, raftLogFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range l.entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(l.dir, raftLogFile)); err != nil {
+		f.Close()
+		return err
+	}
+	l.file.Close()
+	l.file = f
+	l.w = w
+	return nil
+}
+
+func (l *raftLog) close() error {
+	if err := l.w.Flush(); err != nil {
+		return err
+	}
+	return l.file.Close()
+}
+
+// writeFileAtomic replaces the file at p with b.
+func writeFileAtomic(p string, b []byte, sync bool) error {
+	tmp := p + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
@@ -0,0 +1,270 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// freePorts returns n ports that nothing listens on.
+func freePorts(t *testing.T, n int) []int {
+	t.Helper()
+	var ports []int
+	for range n {
+		lis, err := net.Listen("tcp", ":0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lis.Close()
+		ports = append(ports, lis.Addr().(*net.TCPAddr).Port)
+	}
+	return ports
+}
+
+func startMember(t *testing.T, dir string, port int, ports []int) *MetaDataServer {
+	t.Helper()
+	peers := slices.DeleteFunc(slices.Clone(ports), func(p int) bool { return p == port })
+	s := New(port,
+		WithDataDir(filepath.Join(dir, strconv.Itoa(port))),
+		WithSyncWrites(false),
+		WithSnapshotInterval(10),
+		WithPeers(peers...),
+		WithElectionTimeout(150*time.Millisecond),
+	)
+	s.Start(0)
+	return s
+}
+
+// waitForLeader waits until one of the running servers has been elected.
+func waitForLeader(t *testing.T, servers map[int]*MetaDataServer) *MetaDataServer {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, s := range servers {
+			if _, ok := s.raft.isLeader(); ok {
+				return s
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("no leader was elected")
+	return nil
+}
+
+// eventually fails the test if cond does not become true within a few seconds.
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRaftCluster(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ports := freePorts(t, 3)
+	servers := make(map[int]*MetaDataServer)
+	for _, p := range ports {
+		servers[p] = startMember(t, dir, p, ports)
+	}
+	defer func() {
+		for _, s := range servers {
+			s.Stop()
+		}
+	}()
+
+	l := waitForLeader(t, servers)
+	if err := l.commit(ctx, &logEntry{Op: opRegister, Port: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.MkDir(ctx, &MkDirRequest{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, l, &RecRequest{Name: "a/f.txt", FileSize: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range servers {
+		eventually(t, fmt.Sprintf("a/f.txt was not replicated to %d", s.port), func() bool {
+			_, ok := s.location("a/f.txt")
+			return ok
+		})
+	}
+
+	// followers send clients to the leader
+	for port, s := range servers {
+		if s == l {
+			continue
+		}
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: "b"}); err == nil {
+			t.Errorf("follower %d accepted a mutation", port)
+		}
+		conn, err := grpc.NewClient(fmt.Sprintf(":%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var trailer grpcmd.MD
+		_, err = NewMetadataServiceClient(conn).Stat(ctx, &StatRequest{Name: "a"}, grpc.Trailer(&trailer))
+		conn.Close()
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("expected follower %d to reject the request, got %v", port, err)
+		}
+		if got := trailer.Get(LeaderTrailer); len(got) != 1 || got[0] != strconv.Itoa(l.port) {
+			t.Errorf("expected follower %d to redirect to %d, got %v", port, l.port, got)
+		}
+
+		// and so do the streams
+		find := func(opts ...grpc.DialOption) ([]string, error) {
+			conn, err := grpc.NewClient(fmt.Sprintf(":%d", port), append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			stream, err := NewMetadataServiceClient(conn).Find(ctx, &FindRequest{Name: "a"})
+			if err != nil {
+				return nil, err
+			}
+			var found []string
+			for {
+				info, err := stream.Recv()
+				if err == io.EOF {
+					return found, nil
+				}
+				if err != nil {
+					return found, err
+				}
+				found = append(found, info.FullPath)
+			}
+		}
+		if _, err := find(); status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("expected follower %d to reject the stream, got %v", port, err)
+		}
+		if found, err := find(grpc.WithStreamInterceptor(FollowLeaderStream)); err != nil || !slices.Contains(found, "a/f.txt") {
+			t.Errorf("expected the stream to be redirected from follower %d, got %v, %v", port, found, err)
+		}
+	}
+
+	// the remaining servers elect a new leader and keep the namespace
+	old := l.port
+	l.Stop()
+	delete(servers, old)
+	l = waitForLeader(t, servers)
+	for i := range 25 {
+		if _, err := l.MkDir(ctx, &MkDirRequest{Name: fmt.Sprintf("a/%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Unlink(ctx, &UnlinkRequest{Name: "a/f.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the old leader has missed entries that were compacted away and catches
+	// up from a snapshot
+	s := startMember(t, dir, old, ports)
+	servers[old] = s
+	eventually(t, "the restarted server did not catch up", func() bool {
+		s.muDir.RLock()
+		defer s.muDir.RUnlock()
+		_, ok := s.location("a/f.txt")
+		return !ok && isDir(s.rootDir, "a/24")
+	})
+	if c := s.clientFor(1); c == nil {
+		t.Error("the registered file server was not restored from the snapshot")
+	}
+
+	// the entries are only written to the raft log, from which the whole
+	// cluster restores the namespace
+	l = waitForLeader(t, servers)
+	if _, err := l.MkDir(ctx, &MkDirRequest{Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range servers {
+		s.Stop()
+	}
+	wals, err := filepath.Glob(filepath.Join(dir, "*", walPrefix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wal := range wals {
+		if info, err := os.Stat(wal); err != nil || info.Size() != 0 {
+			t.Errorf("expected no entries in the journal segment %s, got %v, %v", wal, info, err)
+		}
+	}
+	for _, p := range ports {
+		servers[p] = startMember(t, dir, p, ports)
+	}
+	waitForLeader(t, servers)
+	for _, s := range servers {
+		eventually(t, fmt.Sprintf("%d did not restore the namespace", s.port), func() bool {
+			s.muDir.RLock()
+			defer s.muDir.RUnlock()
+			return isDir(s.rootDir, "a/24") && isDir(s.rootDir, "c")
+		})
+	}
+}
+
+func TestRaftPartitionedLeader(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ports := freePorts(t, 3)
+	servers := make(map[int]*MetaDataServer)
+	for _, p := range ports {
+		servers[p] = startMember(t, dir, p, ports)
+	}
+	l := waitForLeader(t, servers)
+	defer l.Stop()
+
+	// a proposal is not waited for longer than its request lasts
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.commit(cancelled, &logEntry{Op: opMkDir, Path: "a"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected a cancelled proposal to be unavailable, got %v", err)
+	}
+
+	// a leader cut off from the other servers steps down instead of waiting
+	// for them forever, and stops serving reads and locks once its lease
+	// ran out
+	for port, s := range servers {
+		if s != l {
+			s.Stop()
+			delete(servers, port)
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.MkDir(ctx, &MkDirRequest{Name: "b"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		var notLeader NotLeaderError
+		if !errors.As(err, &notLeader) {
+			t.Errorf("expected the mutation to fail once the leader stepped down, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the mutation to fail once the leader stepped down")
+	}
+	if _, ok := l.raft.isLeader(); ok {
+		t.Error("expected the cut off server not to lead anymore")
+	}
+	if _, err := l.Lock(ctx, &LockRequest{Name: "a", Mode: LockMode_EXCLUSIVE}); err == nil {
+		t.Error("expected the cut off server not to grant locks")
+	}
+}
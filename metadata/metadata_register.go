@@ -0,0 +1,120 @@
+package metadata
+
+import (
+	context "context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+
+	"github.com/tevintchuinkam/dfs/files"
+	"github.com/tevintchuinkam/dfs/helpers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// RegisterFileServer adds a file server that new files can be placed on. The
+// server is pinged first so that only reachable servers are registered.
+// Registering a server again only updates its capacity.
+func (s *MetaDataServer) RegisterFileServer(ctx context.Context, req *RegisterFileServerRequest) (*RegisterFileServerResponse, error) {
+	port := int(req.Port)
+	if port <= 0 {
+		return nil, fmt.Errorf("invalid file server port %d", req.Port)
+	}
+	f := helpers.NewFileServiceClient(req.Port)
+	challenge := rand.Int63()
+	resp, err := f.Ping(ctx, &files.PingRequest{Challenge: challenge})
+	if err != nil {
+		slog.Error("could not reach registering file server", "port", port, "err", err)
+		return nil, err
+	}
+	if challenge != resp.Challenge {
+		return nil, fmt.Errorf("file server failed challenge: %d !=  %d (expected)", resp.Challenge, challenge)
+	}
+	if srv := s.registeredServer(port); srv != nil {
+		srv.muLoad.Lock()
+		same := req.Capacity <= 0 || req.Capacity == srv.capacity
+		srv.muLoad.Unlock()
+		if same {
+			return &RegisterFileServerResponse{}, nil
+		}
+	}
+	// journaled so that the registration and the capacity survive restarts
+	// and are known to all metadata servers of a cluster
+	if err := s.commit(ctx, &logEntry{Op: opRegister, Port: port, Capacity: req.Capacity}); err != nil {
+		return nil, err
+	}
+	slog.Info("registered file server", "port", port, "capacity", req.Capacity)
+	return &RegisterFileServerResponse{}, nil
+}
+
+// DeregisterFileServer removes a file server so that no new files are placed
+// on it, e.g. before it is shut down. Files stored on it are not moved.
+func (s *MetaDataServer) DeregisterFileServer(ctx context.Context, req *DeregisterFileServerRequest) (*DeregisterFileServerResponse, error) {
+	port := int(req.Port)
+	if s.clientFor(port) == nil {
+		return &DeregisterFileServerResponse{}, nil
+	}
+	if err := s.commit(ctx, &logEntry{Op: opDeregister, Port: port}); err != nil {
+		return nil, err
+	}
+	slog.Info("deregistered file server", "port", port)
+	return &DeregisterFileServerResponse{}, nil
+}
+
+// ensures that file servers can join the metadata service through a Registrar
+var _ files.Registrar = (*Registrar)(nil)
+
+// Registrar registers file servers with the metadata service. It is closed
+// once the file servers using it stopped.
+type Registrar struct {
+	conns   []*grpc.ClientConn
+	clients []MetadataServiceClient
+}
+
+// NewRegistrar returns a Registrar that registers file servers with the
+// metadata servers listening on mdsPorts. Every shard of a partitioned
+// namespace has to be given, for a replicated shard any of its members will do.
+func NewRegistrar(mdsPorts ...int) (*Registrar, error) {
+	r := new(Registrar)
+	for _, port := range mdsPorts {
+		conn, err := grpc.NewClient(fmt.Sprintf(":%d", port),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithUnaryInterceptor(FollowLeader),
+		)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.conns = append(r.conns, conn)
+		r.clients = append(r.clients, NewMetadataServiceClient(conn))
+	}
+	return r, nil
+}
+
+// Close closes the connections to the metadata servers.
+func (r *Registrar) Close() error {
+	var errs []error
+	for _, conn := range r.conns {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Registrar) Register(ctx context.Context, port int, capacity int64) error {
+	var errs []error
+	for _, c := range r.clients {
+		_, err := c.RegisterFileServer(ctx, &RegisterFileServerRequest{Port: int32(port), Capacity: capacity})
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Registrar) Deregister(ctx context.Context, port int) error {
+	var errs []error
+	for _, c := range r.clients {
+		_, err := c.DeregisterFileServer(ctx, &DeregisterFileServerRequest{Port: int32(port)})
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,394 @@
+package metadata
+
+import (
+	context "context"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tevintchuinkam/dfs/files"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// repairStats is the progress of the repair of under-replicated files,
+// reported by GetRepairStatus.
+type repairStats struct {
+	// guards the results of the last scan
+	mu              sync.Mutex
+	underReplicated int64
+	lost            int64
+	degraded        int64
+	lastScan        time.Time
+
+	inProgress  atomic.Int64
+	repaired    atomic.Int64
+	failed      atomic.Int64
+	bytesCopied atomic.Int64
+}
+
+// repairJob is a file that has fewer copies on live file servers than it
+// should have.
+type repairJob struct {
+	path     string
+	objectID string
+	size     int64
+	// live servers holding a copy, in the order they are tried as a source
+	live   []int
+	target int
+}
+
+// replicasOf returns the file servers holding the data of f. The repair may
+// change them while the file is read.
+func (s *MetaDataServer) replicasOf(f *fileInfo) []int {
+	s.muLocation.RLock()
+	defer s.muLocation.RUnlock()
+	return slices.Clone(f.servers())
+}
+
+func (s *MetaDataServer) applyReplicas(e *logEntry, persist func() error) error {
+	f, err := s.rootDir.walkTo(e.Path)
+	if err != nil || f.isDir || f.objectID != e.ObjectID {
+		return fmt.Errorf("file %s was removed or replaced while it was repaired", e.Path)
+	}
+	if len(e.Replicas) == 0 {
+		return fmt.Errorf("no copies of %s left", e.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	s.addLoad(f, -int(f.size))
+	s.muLocation.Lock()
+	f.port = e.Replicas[0]
+	f.replicas = e.Replicas
+	if f.links != nil {
+		// the other entries holding the data share the copies
+		for _, l := range f.links.holders {
+			l.port = e.Replicas[0]
+			l.replicas = e.Replicas
+		}
+	}
+	s.muLocation.Unlock()
+	s.addLoad(f, int(f.size))
+	return nil
+}
+
+// GetRepairStatus reports how many files lack copies and how the repair
+// is progressing.
+func (s *MetaDataServer) GetRepairStatus(ctx context.Context, req *RepairStatusRequest) (*RepairStatusResponse, error) {
+	r := &s.repair
+	res := &RepairStatusResponse{
+		InProgress:  r.inProgress.Load(),
+		Repaired:    r.repaired.Load(),
+		Failed:      r.failed.Load(),
+		BytesCopied: r.bytesCopied.Load(),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res.UnderReplicated = r.underReplicated
+	res.Lost = r.lost
+	res.Degraded = r.degraded
+	if !r.lastScan.IsZero() {
+		res.LastScan = timestamppb.New(r.lastScan)
+	}
+	return res, nil
+}
+
+// triggerRepair makes the repair look for under-replicated files right away.
+func (s *MetaDataServer) triggerRepair() {
+	select {
+	case s.repairNow <- struct{}{}:
+	default:
+	}
+}
+
+// repairLoop periodically restores the missing copies of files. Only the
+// leader repairs, the followers learn about the new copies from its log.
+func (s *MetaDataServer) repairLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.opts.repairInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-s.repairNow:
+		}
+		if s.raft != nil {
+			if _, ok := s.raft.isLeader(); !ok {
+				continue
+			}
+		}
+		s.repairAll(stop)
+	}
+}
+
+// repairAll copies the files that lack copies to other live file servers
+// until they have as many as they should or stop is closed.
+func (s *MetaDataServer) repairAll(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	jobs := make(chan repairJob)
+	var wg sync.WaitGroup
+	limit := newRateLimiter(s.opts.repairRate)
+	for range s.opts.repairWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				s.repairFile(ctx, job, limit)
+			}
+		}()
+	}
+	for _, job := range s.scan() {
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// liveServers returns the registered file servers that are not dead.
+func (s *MetaDataServer) liveServers() []Server {
+	var live []Server
+	now := time.Now()
+	for _, srv := range s.registeredServers() {
+		srv.muLoad.Lock()
+		if s.stateOf(srv, now) != FileServerState_DEAD {
+			live = append(live, Server{Port: srv.port, Load: int64(srv.load), Capacity: srv.capacity})
+		}
+		srv.muLoad.Unlock()
+	}
+	return live
+}
+
+// scan returns the files that have fewer copies on live servers than they
+// should have, including the ones only kept by snapshots.
+func (s *MetaDataServer) scan() []repairJob {
+	live := make(map[int]bool)
+	for _, srv := range s.liveServers() {
+		live[srv.Port] = true
+	}
+	var jobs []repairJob
+	var lost, degraded int64
+	// files with hard links or kept by snapshots are repaired once
+	seen := make(map[string]bool)
+	snapshotFiles := s.snapshotFiles()
+	s.muLocation.RLock()
+	check := func(p string, f *fileInfo) {
+		if seen[f.objectID] {
+			return
+		}
+		seen[f.objectID] = true
+		if f.pending {
+			// the data may not have been written yet
+			return
+		}
+		if f.fragmented() {
+			// lost fragments are not rebuilt, reads of erasure coded files
+			// reconstruct them from the ones left and striped files, which
+			// have no parity shards, are lost with any of them
+			var missing int
+			for _, port := range f.fragments {
+				if !live[port] {
+					missing++
+				}
+			}
+			switch {
+			case missing > f.parityShards:
+				lost++
+				slog.Error("too many fragments of file are on dead file servers", "file", p, "missing", missing, "parity", f.parityShards)
+			case missing > 0:
+				degraded++
+				slog.Warn("fragments of file are on dead file servers", "file", p, "missing", missing, "parity", f.parityShards)
+			}
+			return
+		}
+		target := f.replication
+		if target <= 0 {
+			target = s.opts.replication
+		}
+		var alive []int
+		for _, port := range f.servers() {
+			if live[port] {
+				alive = append(alive, port)
+			}
+		}
+		switch {
+		case len(alive) == 0:
+			lost++
+			slog.Error("all copies of file are on dead file servers", "file", p, "replicas", f.servers())
+		case len(alive) < target:
+			jobs = append(jobs, repairJob{path: p, objectID: f.objectID, size: f.size, live: alive, target: target})
+		}
+	}
+	for p, f := range s.fileLocation {
+		check(p, f)
+	}
+	for p, f := range snapshotFiles {
+		check(p, f)
+	}
+	s.muLocation.RUnlock()
+
+	s.repair.mu.Lock()
+	s.repair.underReplicated = int64(len(jobs))
+	s.repair.lost = lost
+	s.repair.degraded = degraded
+	s.repair.lastScan = time.Now()
+	s.repair.mu.Unlock()
+	if len(jobs) > 0 || lost > 0 || degraded > 0 {
+		slog.Info("found files lacking copies", "under_replicated", len(jobs), "lost", lost, "degraded", degraded)
+	}
+	return jobs
+}
+
+// snapshotFiles returns the files kept by snapshots by their path below
+// .snap. Files shared with the live tree are found there first, so they are
+// only returned if nothing else holds their data.
+func (s *MetaDataServer) snapshotFiles() map[string]*fileInfo {
+	if s.dirSnapshots.Load() == 0 {
+		return nil
+	}
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	found := make(map[string]*fileInfo)
+	visited := make(map[*fileInfo]bool)
+	var walk func(f *fileInfo, p string, snapshot bool)
+	walk = func(f *fileInfo, p string, snapshot bool) {
+		if visited[f] || f.isSymlink() {
+			return
+		}
+		visited[f] = true
+		if !f.isDir {
+			if snapshot {
+				found[p] = f
+			}
+			return
+		}
+		f.rlock()
+		entries := slices.Clone(f.subEntries)
+		f.runlock()
+		for _, e := range entries {
+			walk(e, path.Join(p, e.name), snapshot)
+		}
+		// after the live entries, which the snapshots share
+		for name, snap := range f.snaps {
+			walk(snap.root, path.Join(p, snapDir, name), true)
+		}
+	}
+	walk(s.rootDir, ".", false)
+	return found
+}
+
+// repairFile copies the file to as many other live servers as it lacks and
+// records the new copies. Copies on dead servers are dropped, their data is
+// left behind should the servers come back.
+func (s *MetaDataServer) repairFile(ctx context.Context, job repairJob, limit *rateLimiter) {
+	s.repair.inProgress.Add(1)
+	defer s.repair.inProgress.Add(-1)
+
+	candidates := slices.DeleteFunc(s.liveServers(), func(srv Server) bool {
+		return slices.Contains(job.live, srv.Port)
+	})
+	if len(candidates) == 0 {
+		slog.Debug("no file server to copy to", "file", job.path)
+		return
+	}
+	targets, err := s.placeReplicas(job.path, job.size, job.target-len(job.live), candidates)
+	if err != nil {
+		slog.Error("could not place copies", "file", job.path, "err", err)
+		s.repair.failed.Add(1)
+		return
+	}
+	replicas := slices.Clone(job.live)
+	for _, port := range targets {
+		if err := limit.wait(ctx, job.size); err != nil {
+			return
+		}
+		if err := s.copyFile(ctx, job, port); err != nil {
+			slog.Error("could not copy file", "file", job.path, "to", port, "err", err)
+			continue
+		}
+		replicas = append(replicas, port)
+	}
+	if len(replicas) == len(job.live) {
+		s.repair.failed.Add(1)
+		return
+	}
+	e := &logEntry{Op: opReplicas, Path: job.path, ObjectID: job.objectID, Replicas: replicas}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("could not record new copies", "file", job.path, "err", err)
+		s.repair.failed.Add(1)
+		return
+	}
+	slog.Info("repaired file", "file", job.path, "replicas", replicas)
+	s.repair.repaired.Add(1)
+}
+
+// copyFile makes the file server listening on port pull the file from the
+// first live copy that answers.
+func (s *MetaDataServer) copyFile(ctx context.Context, job repairJob, port int) error {
+	client := s.clientFor(port)
+	if client == nil {
+		return fmt.Errorf("file server %d is not registered", port)
+	}
+	var err error
+	for _, source := range job.live {
+		var res *files.CopyFileResponse
+		res, err = (*client).CopyFile(ctx, &files.CopyFileRequest{Name: job.objectID, Source: int32(source)})
+		if err == nil {
+			s.repair.bytesCopied.Add(res.BytesWritten)
+			return nil
+		}
+	}
+	return err
+}
+
+// rateLimiter spaces out copies so that together they copy no more than
+// rate bytes per second.
+type rateLimiter struct {
+	rate int64
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	return &rateLimiter{rate: bytesPerSecond}
+}
+
+// wait blocks until n more bytes may be copied.
+func (l *rateLimiter) wait(ctx context.Context, n int64) error {
+	if l.rate <= 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	l.mu.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
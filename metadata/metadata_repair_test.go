@@ -0,0 +1,140 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRepair(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir(), WithRepair(time.Hour, 2, 0))
+	defer s.Stop()
+	fakes := addFileServers(s, 3)
+	for i := range 6 {
+		if _, err := createFile(ctx, s, &RecRequest{Name: fmt.Sprintf("%d.txt", i), FileSize: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// files only kept by a snapshot are repaired too
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if _, err := createFile(ctx, s, &RecRequest{Name: fmt.Sprintf("d/%d.txt", i), FileSize: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "d", Snapshot: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := range 6 {
+		names = append(names, fmt.Sprintf("%d.txt", i))
+	}
+	for i := range 3 {
+		if _, err := s.Unlink(ctx, &UnlinkRequest{Name: fmt.Sprintf("d/%d.txt", i)}); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, fmt.Sprintf("d/.snap/s/%d.txt", i))
+	}
+
+	// server 3 dies, every file that had a copy on it gets one on the
+	// remaining servers
+	var affected int
+	for _, name := range names {
+		loc, err := s.GetLocation(ctx, &LocRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.Contains(loc.Replicas, 3) {
+			affected++
+		}
+	}
+	if affected == 0 {
+		t.Fatal("no file was placed on server 3")
+	}
+	dead := s.serverByPort(3)
+	dead.muLoad.Lock()
+	dead.lastHeartbeat = time.Now().Add(-time.Hour)
+	dead.muLoad.Unlock()
+	s.repairAll(make(chan struct{}))
+
+	for _, name := range names {
+		loc, err := s.GetLocation(ctx, &LocRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := slices.Clone(loc.Replicas)
+		slices.Sort(got)
+		if fmt.Sprint(got) != "[1 2]" {
+			t.Errorf("expected %s on servers 1 and 2, got %v", name, loc.Replicas)
+		}
+	}
+	if copies := len(fakes[1].copied) + len(fakes[2].copied); copies != affected {
+		t.Errorf("expected %d copies, got %d", affected, copies)
+	}
+	status, err := s.GetRepairStatus(ctx, &RepairStatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.UnderReplicated != int64(affected) || status.Repaired != int64(affected) || status.Failed != 0 {
+		t.Errorf("unexpected repair status %v", status)
+	}
+	if jobs := s.scan(); len(jobs) != 0 {
+		t.Errorf("files still lack copies after the repair: %v", jobs)
+	}
+}
+
+func TestRepairFragments(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir(), WithRepair(time.Hour, 2, 0))
+	defer s.Stop()
+	addFileServers(s, 6)
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "cold"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetStorageClass(ctx, &SetStorageClassRequest{Name: "cold", StorageClass: StorageClass_ERASURE_CODED, DataShards: 4, ParityShards: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "cold/f", FileSize: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "wide"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetStorageClass(ctx, &SetStorageClassRequest{Name: "wide", StorageClass: StorageClass_STRIPED, StripeSize: 1024, StripeCount: 6}); err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := createFile(ctx, s, &RecRequest{Name: "wide/f", FileSize: 6 * 1024}); err != nil || len(rec.Fragments) != 6 {
+		t.Fatalf("expected the file to be striped over all servers, got %v, %v", rec, err)
+	}
+	kill := func(port int) {
+		srv := s.serverByPort(port)
+		srv.muLoad.Lock()
+		srv.lastHeartbeat = time.Now().Add(-time.Hour)
+		srv.muLoad.Unlock()
+	}
+	check := func(degraded, lost int64) {
+		t.Helper()
+		s.scan()
+		status, err := s.GetRepairStatus(ctx, &RepairStatusRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.Degraded != degraded || status.Lost != lost {
+			t.Errorf("expected %d degraded and %d lost files, got %v", degraded, lost, status)
+		}
+	}
+
+	// the erasure coded file can be read as long as no more fragments than
+	// the parity shards are missing, the striped one is lost with any stripe
+	check(0, 0)
+	kill(1)
+	kill(2)
+	check(1, 1)
+	kill(3)
+	check(0, 2)
+}
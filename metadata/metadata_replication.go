@@ -0,0 +1,127 @@
+package metadata
+
+import (
+	context "context"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"time"
+)
+
+// servers returns the ports of the file servers holding the data of f.
+func (f *fileInfo) servers() []int {
+	if len(f.replicas) > 0 {
+		return f.replicas
+	}
+	return []int{f.port}
+}
+
+// addLoad adds delta bytes to the load of every file server holding f. The
+// servers holding the fragments of an erasure coded or striped file only get
+// their share.
+func (s *MetaDataServer) addLoad(f *fileInfo, delta int) {
+	ports := f.servers()
+	if f.fragmented() {
+		ports = f.fragments
+		n := len(f.fragments)
+		if f.dataShards > 0 {
+			n = f.dataShards
+		}
+		share := int(fragmentSize(int64(abs(delta)), n))
+		if delta < 0 {
+			share = -share
+		}
+		delta = share
+	}
+	for _, port := range ports {
+		srv := s.serverByPort(port)
+		srv.muLoad.Lock()
+		srv.load += delta
+		srv.muLoad.Unlock()
+	}
+}
+
+func toPorts32(ports []int) []int32 {
+	res := make([]int32, len(ports))
+	for i, p := range ports {
+		res[i] = int32(p)
+	}
+	return res
+}
+
+// SetReplication sets the number of file servers the data of files created
+// below a directory is stored on. Files that already exist keep their copies.
+func (s *MetaDataServer) SetReplication(ctx context.Context, req *SetReplicationRequest) (*SetReplicationResponse, error) {
+	if req.Factor < 0 {
+		return nil, fmt.Errorf("invalid replication factor %d", req.Factor)
+	}
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	e := &logEntry{
+		Op:          opSetRepl,
+		Path:        p,
+		Replication: int(req.Factor),
+		Time:        time.Now().UnixNano(),
+	}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to set replication factor", "dir", e.Path, "error", err)
+		return nil, err
+	}
+	return &SetReplicationResponse{}, nil
+}
+
+func (s *MetaDataServer) applySetReplication(e *logEntry, persist func() error) error {
+	d, err := s.own(e.Path)
+	if err != nil || !d.isDir {
+		return fmt.Errorf("the directory %s doesn't exist", e.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	d.replication = e.Replication
+	return nil
+}
+
+// replicationFor returns the replication factor of a new file at p: the
+// requested one if set, otherwise the one of the closest directory that has
+// one or the server's default.
+func (s *MetaDataServer) replicationFor(p string, requested int) int {
+	if requested > 0 {
+		return requested
+	}
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		if d, err := s.rootDir.walkTo(dir); err == nil && d.replication > 0 {
+			return d.replication
+		}
+		if dir == "." || dir == "/" {
+			return s.opts.replication
+		}
+	}
+}
+
+// placeReplicas asks the placement policy for n distinct servers out of
+// candidates, in the order they were picked. If there are fewer candidates
+// all of them are used, the missing copies are made by the repair once more
+// servers are alive.
+func (s *MetaDataServer) placeReplicas(p string, size int64, n int, candidates []Server) ([]int, error) {
+	if n > len(candidates) {
+		slog.Warn("not enough file servers for all copies", "file", p, "copies", n, "servers", len(candidates))
+		n = len(candidates)
+	}
+	candidates = slices.Clone(candidates)
+	var ports []int
+	for range n {
+		i := s.opts.placement.Place(p, size, candidates)
+		if i < 0 || i >= len(candidates) {
+			return nil, fmt.Errorf("placement policy picked server %d of %d", i, len(candidates))
+		}
+		ports = append(ports, candidates[i].Port)
+		candidates = slices.Delete(candidates, i, i+1)
+	}
+	return ports, nil
+}
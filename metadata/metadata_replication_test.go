@@ -0,0 +1,90 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/tevintchuinkam/dfs/files"
+)
+
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir, WithReplication(2))
+	fakes := addFileServers(s, 3)
+
+	rec, err := createFile(ctx, s, &RecRequest{Name: "f", FileSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Replicas) != 2 || rec.Replicas[0] == rec.Replicas[1] || rec.Port != rec.Replicas[0] {
+		t.Errorf("expected 2 distinct replicas starting with %d, got %v", rec.Port, rec.Replicas)
+	}
+
+	// directories override the default and files override their directory
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "a/b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetReplication(ctx, &SetReplicationRequest{Name: "a", Factor: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		factor int32
+		want   int
+	}{
+		{"a/b/f", 0, 3},
+		{"a/g", 1, 1},
+	} {
+		rec, err := createFile(ctx, s, &RecRequest{Name: tt.name, FileSize: 1, Replication: tt.factor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rec.Replicas) != tt.want {
+			t.Errorf("expected %s to be stored %d times, got %v", tt.name, tt.want, rec.Replicas)
+		}
+	}
+	// missing servers leave the file under-replicated until the repair finds some
+	if rec, err := createFile(ctx, s, &RecRequest{Name: "h", Replication: 4}); err != nil || len(rec.Replicas) != 3 {
+		t.Errorf("expected h to be stored on all 3 servers, got %v, %v", rec, err)
+	}
+
+	loc, err := s.GetLocation(ctx, &LocRequest{Name: "a/b/f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := slices.Clone(loc.Replicas)
+	slices.Sort(got)
+	if fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("expected a/b/f on all servers, got %v", loc.Replicas)
+	}
+
+	// the copies survive a restart and are all deleted with the file
+	s.Stop()
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	for port, fake := range fakes {
+		var c files.FileServiceClient = fake
+		s.serverByPort(port).client = &c
+	}
+	restored, err := s.GetLocation(ctx, &LocRequest{Name: "f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(restored.Replicas, rec.Replicas) {
+		t.Errorf("replicas changed on restart: %v != %v", restored.Replicas, rec.Replicas)
+	}
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "f"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, port := range rec.Replicas {
+		if fake := fakes[int(port)]; len(fake.deleted) != 1 || fake.deleted[0] != rec.ObjectId {
+			t.Errorf("expected the copy on %d to be deleted, got %v", port, fake.deleted)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package metadata
+
+import (
+	context "context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ShardTrailer is the trailer in which a shard tells clients the port of the
+// shard a symbolic link led to. PathTrailer holds the name of the request and
+// the path it resolves to, which is what the request is retried with there.
+const (
+	ShardTrailer = "mds-shard"
+	PathTrailer  = "mds-path"
+)
+
+// ForeignPathError is returned for a name whose symbolic links lead to a
+// path owned by another shard.
+type ForeignPathError struct {
+	Name  string
+	Path  string
+	Shard int
+}
+
+func (e ForeignPathError) Error() string {
+	return fmt.Sprintf("%s leads to %s on metadata shard %d", e.Name, e.Path, e.Shard)
+}
+
+// ShardOf returns the index of the metadata server that owns p when the
+// namespace is partitioned across n of them. Every top-level entry is owned
+// by a single shard together with everything below it. The root directory
+// exists on all shards, its attributes are served by the first one.
+func ShardOf(p string, n int) int {
+	p = strings.TrimPrefix(path.Clean(p), "/")
+	if n <= 1 || p == "." || p == "" {
+		return 0
+	}
+	top, _, _ := strings.Cut(p, "/")
+	h := fnv.New32a()
+	h.Write([]byte(top))
+	return int(h.Sum32() % uint32(n))
+}
+
+// owns reports whether p belongs to the shard of the namespace held by s.
+func (s *MetaDataServer) owns(p string) bool {
+	p = path.Clean(p)
+	return s.opts.shards <= 1 || p == "." || ShardOf(p, s.opts.shards) == s.opts.shard
+}
+
+// checkShard rejects requests for paths owned by another shard, which would
+// otherwise end up in the wrong part of the namespace. Requests whose
+// symbolic links lead into another shard are redirected there, see
+// ShardTrailer.
+func (s *MetaDataServer) checkShard(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	var name string
+	switch r := req.(type) {
+	case *RenameRequest:
+		// the entry may be moved to another shard, see moveOut
+		name = r.OldName
+	case *LinkRequest:
+		name = r.OldName
+	case interface{ GetName() string }:
+		name = r.GetName()
+	}
+	if err := s.checkOwns(name); err != nil {
+		return nil, err
+	}
+	res, err := handler(ctx, req)
+	md, err := s.redirectToShard(err)
+	if md != nil {
+		grpc.SetTrailer(ctx, md)
+	}
+	return res, err
+}
+
+// checkShardStream is checkShard for streams, their name is only known to
+// the handler.
+func (s *MetaDataServer) checkShardStream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	md, err := s.redirectToShard(handler(srv, ss))
+	if md != nil {
+		ss.SetTrailer(md)
+	}
+	return err
+}
+
+// redirectToShard turns a ForeignPathError into the trailer and the error
+// that send the client to the shard the path belongs to. Without the ports
+// of the shards the request is rejected.
+func (s *MetaDataServer) redirectToShard(err error) (grpcmd.MD, error) {
+	var foreign ForeignPathError
+	if !errors.As(err, &foreign) {
+		return nil, err
+	}
+	if foreign.Shard >= len(s.opts.shardPorts) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	md := grpcmd.Pairs(ShardTrailer, strconv.Itoa(s.opts.shardPorts[foreign.Shard]), PathTrailer, foreign.Name, PathTrailer, foreign.Path)
+	return md, status.Error(codes.FailedPrecondition, err.Error())
+}
+
+// local fails if name resolved to a path p that belongs to another shard,
+// with a ForeignPathError if symbolic links led there and like checkOwns if
+// the name was sent to the wrong shard.
+func (s *MetaDataServer) local(name, p string) error {
+	if s.owns(p) {
+		return nil
+	}
+	if path.Clean(name) == p {
+		return s.checkOwns(p)
+	}
+	return ForeignPathError{Name: name, Path: p, Shard: ShardOf(p, s.opts.shards)}
+}
+
+// checkOwns fails with InvalidArgument if p belongs to another shard.
+func (s *MetaDataServer) checkOwns(p string) error {
+	if !s.owns(p) {
+		return status.Errorf(codes.InvalidArgument, "%s belongs to metadata shard %d, not %d", p, ShardOf(p, s.opts.shards), s.opts.shard)
+	}
+	return nil
+}
+
+// followShard returns the port of the shard trailer redirects to and a copy
+// of req with the name from PathTrailer replaced by the path it resolves to.
+// ok is false if the trailer does not redirect req to another shard.
+func followShard(trailer grpcmd.MD, req any) (port string, redirected any, ok bool) {
+	ports, paths := trailer.Get(ShardTrailer), trailer.Get(PathTrailer)
+	m, isProto := req.(proto.Message)
+	if len(ports) == 0 || len(paths) != 2 || !isProto {
+		return "", nil, false
+	}
+	m = proto.Clone(m)
+	r := m.ProtoReflect()
+	r.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Kind() == protoreflect.StringKind && !fd.IsList() && !fd.IsMap() && path.Clean(v.String()) == path.Clean(paths[0]) {
+			r.Set(fd, protoreflect.ValueOfString(paths[1]))
+			ok = true
+			return false
+		}
+		return true
+	})
+	return ports[0], m, ok
+}
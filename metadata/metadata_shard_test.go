@@ -0,0 +1,340 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestShardOf(t *testing.T) {
+	const n = 4
+	if s := ShardOf(".", n); s != 0 {
+		t.Errorf("expected the root to be served by shard 0, got %d", s)
+	}
+	for _, p := range []string{"a", "/a", "a/b", "a/b/c.txt", "./a/b/"} {
+		if ShardOf(p, n) != ShardOf("a", n) {
+			t.Errorf("%s is not on the shard of its top-level directory", p)
+		}
+	}
+	used := make(map[int]bool)
+	for i := range 100 {
+		used[ShardOf(fmt.Sprintf("dir-%d", i), n)] = true
+	}
+	if len(used) != n {
+		t.Errorf("100 top-level directories only use %d of %d shards", len(used), n)
+	}
+	if s := ShardOf("a/b", 1); s != 0 {
+		t.Errorf("expected a single shard to own everything, got %d", s)
+	}
+}
+
+func TestCheckShard(t *testing.T) {
+	const n = 2
+	var mine, other string
+	for i := 0; mine == "" || other == ""; i++ {
+		p := fmt.Sprintf("dir-%d", i)
+		if ShardOf(p, n) == 1 {
+			mine = p
+		} else {
+			other = p
+		}
+	}
+	s := newTestServer(t, t.TempDir(), WithShard(1, n))
+	defer s.Stop()
+	call := func(req any) error {
+		_, err := s.checkShard(context.Background(), req, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+		return err
+	}
+	for _, req := range []any{
+		&MkDirRequest{Name: mine + "/sub"},
+		&ReadDirRequest{Name: "."},
+		&RenameRequest{OldName: mine + "/x", NewName: mine + "/y"},
+		// moved to the other shard, see TestShardRename
+		&RenameRequest{OldName: mine, NewName: other},
+		&PingRequest{},
+	} {
+		if err := call(req); err != nil {
+			t.Errorf("%v was rejected: %v", req, err)
+		}
+	}
+	for _, req := range []any{
+		&MkDirRequest{Name: other},
+		&StatRequest{Name: other + "/x"},
+		&RenameRequest{OldName: other, NewName: mine},
+	} {
+		if err := call(req); err == nil {
+			t.Errorf("%v for another shard was accepted", req)
+		}
+	}
+}
+
+// startShards starts a metadata server for each of n shards that knows the
+// ports of the others.
+func startShards(t *testing.T, n int) []*MetaDataServer {
+	t.Helper()
+	dir := t.TempDir()
+	ports := freePorts(t, n)
+	var shards []*MetaDataServer
+	for i := range ports {
+		shards = append(shards, startShard(t, dir, i, ports))
+	}
+	return shards
+}
+
+// startShard starts the metadata server of shard i, one of the servers
+// listening on ports, with the namespace it persisted in dir.
+func startShard(t *testing.T, dir string, i int, ports []int) *MetaDataServer {
+	t.Helper()
+	s := New(ports[i],
+		WithDataDir(filepath.Join(dir, strconv.Itoa(ports[i]))),
+		WithSyncWrites(false),
+		WithShard(i, len(ports)),
+		WithShardPorts(ports...),
+	)
+	addFileServers(s, 1)
+	s.Start(0)
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// shardPaths returns a top-level name owned by each of n shards.
+func shardPaths(n int) []string {
+	paths := make([]string, n)
+	for i, found := 0, 0; found < n; i++ {
+		p := fmt.Sprintf("dir-%d", i)
+		if s := ShardOf(p, n); paths[s] == "" {
+			paths[s] = p
+			found++
+		}
+	}
+	return paths
+}
+
+func TestShardLinks(t *testing.T) {
+	ctx := context.Background()
+	shards := startShards(t, 2)
+	paths := shardPaths(2)
+	mine, other := paths[1], paths[0]
+	for i, p := range paths {
+		if _, err := shards[i].MkDir(ctx, &MkDirRequest{Name: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := createFile(ctx, shards[0], &RecRequest{Name: other + "/f.txt", FileSize: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shards[1].Symlink(ctx, &SymlinkRequest{Name: mine + "/l", Target: "../" + other}); err != nil {
+		t.Fatal(err)
+	}
+	dial := func(opts ...grpc.DialOption) MetadataServiceClient {
+		conn, err := grpc.NewClient(fmt.Sprintf(":%d", shards[1].port), append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return NewMetadataServiceClient(conn)
+	}
+
+	// the shard of the link sends the client to the shard of its target
+	var trailer grpcmd.MD
+	_, err := dial().Stat(ctx, &StatRequest{Name: mine + "/l/f.txt"}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected the request to be redirected, got %v", err)
+	}
+	if got := trailer.Get(ShardTrailer); len(got) != 1 || got[0] != strconv.Itoa(shards[0].port) {
+		t.Errorf("expected a redirect to shard 0 on port %d, got %v", shards[0].port, got)
+	}
+	if got := trailer.Get(PathTrailer); !slices.Equal(got, []string{mine + "/l/f.txt", other + "/f.txt"}) {
+		t.Errorf("expected the resolved path in the trailer, got %v", got)
+	}
+
+	// which the client follows, for reads, writes and streams
+	mds := dial(grpc.WithUnaryInterceptor(FollowLeader), grpc.WithStreamInterceptor(FollowLeaderStream))
+	if info, err := mds.Stat(ctx, &StatRequest{Name: mine + "/l/f.txt"}); err != nil || info.Size != 3 {
+		t.Errorf("expected the file behind the link, got %v, %v", info, err)
+	}
+	if _, err := mds.MkDir(ctx, &MkDirRequest{Name: mine + "/l/sub"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shards[0].resolve(other + "/sub"); err != nil {
+		t.Errorf("expected the directory to be created on the shard of the target: %v", err)
+	}
+	stream, err := mds.Find(ctx, &FindRequest{Name: mine + "/l"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for {
+		info, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = append(found, info.FullPath)
+	}
+	if !slices.Contains(found, other+"/f.txt") {
+		t.Errorf("expected the search to continue on the shard of the target, got %v", found)
+	}
+
+	// a shard that does not know the others can only reject the request
+	s := newTestServer(t, t.TempDir(), WithShard(1, 2))
+	defer s.Stop()
+	l := mine + "/l"
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: mine}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Symlink(ctx, &SymlinkRequest{Name: l, Target: "../" + other}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.checkShard(ctx, &StatRequest{Name: l + "/f.txt"}, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		return s.Stat(ctx, req.(*StatRequest))
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected the request to be rejected, got %v", err)
+	}
+}
+
+func TestShardRename(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ports := freePorts(t, 2)
+	shards := []*MetaDataServer{startShard(t, dir, 0, ports), startShard(t, dir, 1, ports)}
+	paths := shardPaths(2)
+	mine, other := paths[1], paths[0]
+	src := shards[1]
+	for i, p := range paths {
+		if _, err := shards[i].MkDir(ctx, &MkDirRequest{Name: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{"d", "d/sub", "full", "full/x", "linked", "kept", "kept/y", "late"} {
+		if _, err := src.MkDir(ctx, &MkDirRequest{Name: path.Join(mine, p)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec, err := createFile(ctx, src, &RecRequest{Name: mine + "/d/sub/f.txt", FileSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.SetXattr(ctx, &SetXattrRequest{Name: mine + "/d", Key: "user.k", Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	quota := func(s *MetaDataServer, p string) int64 {
+		q, err := s.GetQuota(ctx, &QuotaRequest{Name: p})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return q.UsedBytes
+	}
+
+	// the directory is moved with everything below it and keeps its data
+	if _, err := src.Rename(ctx, &RenameRequest{OldName: mine + "/d", NewName: other + "/moved"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.resolve(mine + "/d"); err == nil {
+		t.Errorf("expected %s/d to be gone from the source", mine)
+	}
+	loc, err := shards[0].GetLocation(ctx, &LocRequest{Name: other + "/moved/sub/f.txt"})
+	if err != nil || loc.ObjectId != rec.ObjectId || loc.Size != 3 {
+		t.Errorf("expected the file on the destination with its data, got %v, %v", loc, err)
+	}
+	if x, err := shards[0].GetXattr(ctx, &GetXattrRequest{Name: other + "/moved", Key: "user.k"}); err != nil || string(x.Value) != "v" {
+		t.Errorf("expected the attributes to be moved, got %v, %v", x, err)
+	}
+	if got := quota(src, mine); got != 0 {
+		t.Errorf("expected the source to use no bytes anymore, got %d", got)
+	}
+	if got := quota(shards[0], other); got != 3 {
+		t.Errorf("expected the destination to use 3 bytes, got %d", got)
+	}
+	if deleted := (*src.clientFor(1)).(*fakeFileServer).deleted; len(deleted) > 0 {
+		t.Errorf("expected the data to be kept, deleted %v", deleted)
+	}
+	if len(shards[0].movedIn) != 0 {
+		t.Errorf("expected the destination to forget the finished move, got %v", shards[0].movedIn)
+	}
+
+	// refused by the destination, the entry stays where it was
+	if _, err := shards[0].MkDir(ctx, &MkDirRequest{Name: other + "/full"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shards[0].MkDir(ctx, &MkDirRequest{Name: other + "/full/z"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, newName := range []string{other + "/missing/x", other + "/full"} {
+		if _, err := src.Rename(ctx, &RenameRequest{OldName: mine + "/full", NewName: newName}); err == nil {
+			t.Errorf("expected the move to %s to be refused", newName)
+		}
+	}
+	if _, err := src.resolve(mine + "/full/x"); err != nil || len(src.moves) != 0 {
+		t.Errorf("expected the refused moves to be dropped, got %v, %v", err, src.moves)
+	}
+
+	// data shared by other names cannot leave the shard
+	if _, err := createFile(ctx, src, &RecRequest{Name: mine + "/linked/f", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Link(ctx, &LinkRequest{OldName: mine + "/linked/f", NewName: mine + "/linked/g"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: mine + "/kept", Snapshot: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"linked", "linked/f", "kept", "kept/y"} {
+		_, err := src.Rename(ctx, &RenameRequest{OldName: path.Join(mine, p), NewName: path.Join(other, p)})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("expected the move of %s to be refused, got %v", p, err)
+		}
+	}
+	_, err = src.Link(ctx, &LinkRequest{OldName: mine + "/linked/f", NewName: other + "/h"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected a hard link on another shard to be refused, got %v", err)
+	}
+
+	// a move the destination did not answer is kept, also across restarts,
+	// and finished once it is back
+	shards[0].Stop()
+	_, err = src.Rename(ctx, &RenameRequest{OldName: mine + "/late", NewName: other + "/late"})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the move to wait for the destination, got %v", err)
+	}
+	if _, err := src.MkDir(ctx, &MkDirRequest{Name: mine + "/late/sub"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected changes of an entry being moved to be refused, got %v", err)
+	}
+	// restored from the snapshot
+	if err := src.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	src.Stop()
+	src = startShard(t, dir, 1, ports)
+	if len(src.moves) != 1 {
+		t.Fatalf("expected the move to be restored, got %v", src.moves)
+	}
+	dst := startShard(t, dir, 0, ports)
+	eventually(t, "the move was not finished", func() bool {
+		src.muDir.RLock()
+		defer src.muDir.RUnlock()
+		return len(src.moves) == 0
+	})
+	if _, err := dst.Stat(ctx, &StatRequest{Name: other + "/late"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := src.Stat(ctx, &StatRequest{Name: mine + "/late"}); err == nil {
+		t.Errorf("expected %s/late to be gone from the source", mine)
+	}
+}
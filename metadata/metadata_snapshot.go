@@ -0,0 +1,309 @@
+package metadata
+
+import (
+	context "context"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// A snapshot of a directory is the directory node itself, which the live
+// tree and the snapshot share from then on. Before a node is changed, every
+// shared node on the way to it is copied, see own, so the snapshot keeps
+// seeing the tree as it was while the copies only cost what was changed.
+// Snapshots are read below dir/.snap/name and cannot be changed.
+
+// snapDir is the name under which the snapshots of a directory are read.
+// Entries cannot be created with it.
+const snapDir = ".snap"
+
+// dirSnapshot is a read-only view of a directory's subtree.
+type dirSnapshot struct {
+	root    *fileInfo
+	created time.Time
+}
+
+// inSnapshot reports whether p leads into a snapshot.
+func inSnapshot(p string) bool {
+	return slices.Contains(strings.Split(path.Clean(p), "/"), snapDir)
+}
+
+// writable returns an error if p cannot be changed since it leads into a
+// snapshot.
+func writable(p string) error {
+	if inSnapshot(p) {
+		return status.Errorf(codes.FailedPrecondition, "%s is read-only, %s holds snapshots", p, snapDir)
+	}
+	return nil
+}
+
+func checkSnapshotName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return status.Errorf(codes.InvalidArgument, "invalid snapshot name %q", name)
+	}
+	return nil
+}
+
+// sharedPath reports whether the root or an entry on the way to p is shared
+// with a snapshot. The caller must hold muDir.
+func (s *MetaDataServer) sharedPath(p string) bool {
+	if s.dirSnapshots.Load() == 0 {
+		return false
+	}
+	d := s.rootDir
+	if d.shared > 0 {
+		return true
+	}
+	for _, part := range strings.Split(path.Clean(p), "/") {
+		if part == "." {
+			continue
+		}
+		d.rlock()
+		next := d.lookup(part)
+		d.runlock()
+		if next == nil {
+			return false
+		}
+		if next.shared > 0 {
+			return true
+		}
+		d = next
+	}
+	return false
+}
+
+// own walks to the entry at p like walkTo and copies the root and every
+// entry on the way that is shared with a snapshot, so that the returned
+// entry can be changed. Since nothing is shared while there are no
+// snapshots, the caller only needs to hold muDir exclusively if sharedPath
+// reports p.
+func (s *MetaDataServer) own(p string) (*fileInfo, error) {
+	if err := writable(p); err != nil {
+		return nil, err
+	}
+	if s.rootDir.shared > 0 {
+		s.rootDir = s.unshare(nil, s.rootDir)
+	}
+	d := s.rootDir
+	for _, part := range strings.Split(path.Clean(p), "/") {
+		if part == "." {
+			continue
+		}
+		d.rlock()
+		next := d.lookup(part)
+		d.runlock()
+		if next == nil {
+			return nil, DirNonExistantError{part}
+		}
+		if next.shared > 0 {
+			next = s.unshare(d, next)
+		}
+		d = next
+	}
+	return d, nil
+}
+
+// unshare puts a copy of the shared entry f in place of it in parent, the
+// root if parent is nil, and returns the copy. The entries of a directory
+// become shared by both versions. A file keeps its data, which the copy
+// holds too, see shareData. The extended attributes stay with the copy, f
+// keeps the ones it has now. muDir must be held exclusively.
+func (s *MetaDataServer) unshare(parent, f *fileInfo) *fileInfo {
+	var c *fileInfo
+	if f.isDir {
+		c = f.cloneDir()
+		// the snapshots belong to the live directory
+		c.snaps, f.snaps = f.snaps, nil
+		for _, e := range c.subEntries {
+			e.shared++
+		}
+	} else {
+		c = f.clone()
+		s.shareData(f, c)
+	}
+	f.xattrs = f.xattrs.clone()
+	f.shared--
+	if parent != nil {
+		parent.replace(c)
+	}
+	if !c.isDir && !c.isSymlink() {
+		s.setLocation(c.fullPath, c)
+	}
+	return c
+}
+
+// cloneDir returns a copy of the directory d with the same entries.
+func (d *fileInfo) cloneDir() *fileInfo {
+	c := d.clone()
+	c.isDir = true
+	c.mu = new(sync.RWMutex)
+	c.subEntries = slices.Clone(d.subEntries)
+	c.index = make(map[string]*fileInfo, len(d.index))
+	for name, e := range d.index {
+		c.index[name] = e
+	}
+	c.nextSeq = d.nextSeq
+	c.storageClass = d.storageClass
+	c.pendingEntries = d.pendingEntries
+	c.quotaBytes = d.quotaBytes
+	c.quotaEntries = d.quotaEntries
+	c.usedBytes = atomic.LoadInt64(&d.usedBytes)
+	c.usedEntries = atomic.LoadInt64(&d.usedEntries)
+	return c
+}
+
+// shareData makes the copy c, which takes the place of f in the live tree,
+// hold the data of f too. f is only kept by snapshots from then on, so the
+// number of names of the file stays the same.
+func (s *MetaDataServer) shareData(f, c *fileInfo) {
+	if f.isSymlink() {
+		return
+	}
+	s.muLocation.Lock()
+	defer s.muLocation.Unlock()
+	if f.links == nil {
+		f.links = &linkGroup{count: 1, holders: []*fileInfo{f}}
+	}
+	c.links = f.links
+	c.links.holders = append(c.links.holders, c)
+}
+
+// release drops a reference to the tree rooted at f, which was removed from
+// the live tree or is a deleted snapshot, and returns the files that are
+// not held by another tree anymore.
+func release(f *fileInfo) []*fileInfo {
+	if f.shared > 0 {
+		f.shared--
+		return nil
+	}
+	if !f.isDir {
+		return []*fileInfo{f}
+	}
+	var gone []*fileInfo
+	for _, e := range f.subEntries {
+		gone = append(gone, release(e)...)
+	}
+	return gone
+}
+
+// hasSnapshots reports whether d or a directory below it has snapshots.
+func hasSnapshots(d *fileInfo) bool {
+	if len(d.snaps) > 0 {
+		return true
+	}
+	for _, e := range d.subEntries {
+		if e.isDir && hasSnapshots(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateSnapshot freezes the subtree of a directory as it is now. The
+// snapshot is read below the returned path and keeps the data of its files
+// on the file servers until it is deleted.
+func (s *MetaDataServer) CreateSnapshot(ctx context.Context, req *CreateSnapshotRequest) (*CreateSnapshotResponse, error) {
+	if err := checkSnapshotName(req.Snapshot); err != nil {
+		return nil, err
+	}
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	if p == "." && s.opts.shards > 1 {
+		return nil, status.Error(codes.FailedPrecondition, "the root of a sharded namespace cannot be snapshotted")
+	}
+	e := &logEntry{Op: opCreateSnapshot, Path: p, Snapshot: req.Snapshot, Time: time.Now().UnixNano()}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to create snapshot", "dir", p, "snapshot", req.Snapshot, "error", err)
+		return nil, err
+	}
+	return &CreateSnapshotResponse{Path: path.Join(p, snapDir, req.Snapshot)}, nil
+}
+
+func (s *MetaDataServer) applyCreateSnapshot(e *logEntry, persist func() error) error {
+	d, err := s.own(e.Path)
+	if err != nil || !d.isDir {
+		return fmt.Errorf("the directory %s doesn't exist", e.Path)
+	}
+	if _, ok := d.snaps[e.Snapshot]; ok {
+		return status.Errorf(codes.AlreadyExists, "snapshot %s of %s already exists", e.Snapshot, e.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	if d.snaps == nil {
+		d.snaps = make(map[string]*dirSnapshot)
+	}
+	d.snaps[e.Snapshot] = &dirSnapshot{root: d, created: e.time()}
+	d.shared++
+	s.dirSnapshots.Add(1)
+	return nil
+}
+
+// DeleteSnapshot removes a snapshot and deletes the data of the files only
+// it kept.
+func (s *MetaDataServer) DeleteSnapshot(ctx context.Context, req *DeleteSnapshotRequest) (*DeleteSnapshotResponse, error) {
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	e := &logEntry{Op: opDeleteSnapshot, Path: p, Snapshot: req.Snapshot, Time: time.Now().UnixNano()}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to delete snapshot", "dir", p, "snapshot", req.Snapshot, "error", err)
+		return nil, err
+	}
+	s.deleteData(e.orphaned)
+	return &DeleteSnapshotResponse{}, nil
+}
+
+func (s *MetaDataServer) applyDeleteSnapshot(e *logEntry, persist func() error) error {
+	if err := writable(e.Path); err != nil {
+		return err
+	}
+	d, err := s.rootDir.walkTo(e.Path)
+	if err != nil || !d.isDir {
+		return fmt.Errorf("the directory %s doesn't exist", e.Path)
+	}
+	snap, ok := d.snaps[e.Snapshot]
+	if !ok {
+		return status.Errorf(codes.NotFound, "no snapshot %s of %s", e.Snapshot, e.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	delete(d.snaps, e.Snapshot)
+	s.dirSnapshots.Add(-1)
+	e.orphaned = s.forgetFiles(nil, release(snap.root))
+	return nil
+}
+
+// ListSnapshots returns the snapshots of a directory ordered by name.
+func (s *MetaDataServer) ListSnapshots(ctx context.Context, req *ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	d, err := s.rootDir.walkTo(p)
+	if err != nil || !d.isDir {
+		return nil, fmt.Errorf("the directory %s doesn't exist", p)
+	}
+	res := new(ListSnapshotsResponse)
+	for name, snap := range d.snaps {
+		res.Snapshots = append(res.Snapshots, &SnapshotInfo{Name: name, CreateTime: timestamppb.New(snap.created)})
+	}
+	sort.Slice(res.Snapshots, func(i, j int) bool { return res.Snapshots[i].Name < res.Snapshots[j].Name })
+	return res, nil
+}
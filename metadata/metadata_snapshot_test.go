@@ -0,0 +1,168 @@
+package metadata
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	for _, d := range []string{"d", "d/sub"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := createFile(ctx, s, &RecRequest{Name: "d/f", FileSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "d/sub/g", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "d", Snapshot: "s1"})
+	if err != nil || res.Path != "d/.snap/s1" {
+		t.Fatalf("expected the snapshot to be read under d/.snap/s1, got %v, %v", res, err)
+	}
+	if _, err := s.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "d", Snapshot: "s1"}); err == nil {
+		t.Error("expected a second snapshot with the same name to fail")
+	}
+
+	// changes of the live tree are not seen by the snapshot
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "d/f"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "d/h", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "d/sub/g", NewName: "d/g"}); err != nil {
+		t.Fatal(err)
+	}
+	list := func(name string) []string {
+		t.Helper()
+		all, err := s.ReadDirAll(ctx, &ReadDirRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, e := range all.Entries {
+			paths = append(paths, e.FullPath)
+		}
+		return paths
+	}
+	check := func() {
+		t.Helper()
+		if got, want := list("d"), []string{"d/sub", "d/h", "d/g"}; !slices.Equal(got, want) {
+			t.Errorf("expected d to hold %v, got %v", want, got)
+		}
+		if got, want := list("d/.snap/s1"), []string{"d/.snap/s1/sub", "d/.snap/s1/f"}; !slices.Equal(got, want) {
+			t.Errorf("expected the snapshot to hold %v, got %v", want, got)
+		}
+		if loc, err := s.GetLocation(ctx, &LocRequest{Name: "d/.snap/s1/f"}); err != nil || loc.ObjectId != f.ObjectId {
+			t.Errorf("expected to locate the removed file in the snapshot, got %v, %v", loc, err)
+		}
+		if _, err := s.Stat(ctx, &StatRequest{Name: "d/.snap/s1/sub/g"}); err != nil {
+			t.Errorf("expected the moved file to stay in the snapshot, got %v", err)
+		}
+		// the copies kept by the snapshot are no names of the files
+		for _, name := range []string{"d/g", "d/.snap/s1/sub/g", "d/.snap/s1/f"} {
+			if info, err := s.Stat(ctx, &StatRequest{Name: name}); err != nil || info.Links != 1 {
+				t.Errorf("expected %s to have a single name, got %v, %v", name, info, err)
+			}
+		}
+	}
+	check()
+	fake := (*s.serverByPort(1).client).(*fakeFileServer)
+	if len(fake.deleted) != 0 {
+		t.Errorf("expected the snapshot to keep the data, got %v deleted", fake.deleted)
+	}
+
+	// snapshots are read-only and keep their directory from being removed
+	if _, err := createFile(ctx, s, &RecRequest{Name: "d/.snap/s1/x", FileSize: 1}); err == nil {
+		t.Error("expected creating a file in a snapshot to fail")
+	}
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "d/.snap/s1/f"}); err == nil {
+		t.Error("expected removing a file of a snapshot to fail")
+	}
+	if _, err := s.RmDir(ctx, &RmDirRequest{Name: "d", Recursive: true}); err == nil {
+		t.Error("expected removing a directory with snapshots to fail")
+	}
+
+	// the shared tree survives a restart, from the journal and a snapshot of it
+	s.Stop()
+	s = newTestServer(t, dir)
+	check()
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	check()
+	if res, err := s.ListSnapshots(ctx, &ListSnapshotsRequest{Name: "d"}); err != nil || len(res.Snapshots) != 1 || res.Snapshots[0].Name != "s1" {
+		t.Errorf("expected to list s1, got %v, %v", res, err)
+	}
+
+	// deleting the snapshot deletes the data only it kept
+	if _, err := s.DeleteSnapshot(ctx, &DeleteSnapshotRequest{Name: "d", Snapshot: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	fake = (*s.serverByPort(1).client).(*fakeFileServer)
+	if !slices.Equal(fake.deleted, []string{f.ObjectId}) {
+		t.Errorf("expected only the data of d/f to be deleted, got %v", fake.deleted)
+	}
+	if _, err := s.Stat(ctx, &StatRequest{Name: "d/.snap/s1"}); err == nil {
+		t.Error("expected the snapshot to be gone")
+	}
+	if _, err := s.RmDir(ctx, &RmDirRequest{Name: "d", Recursive: true}); err != nil {
+		t.Errorf("expected d to be removable without snapshots, got %v", err)
+	}
+	if len(fake.deleted) != 3 {
+		t.Errorf("expected the data of all three files to be deleted, got %v", fake.deleted)
+	}
+
+	// removing a hard link of a snapshotted file leaves a single name
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "e"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "e/x", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Link(ctx, &LinkRequest{OldName: "e/x", NewName: "e/y"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "e/sub"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "e/sub/z", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "e", Snapshot: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	// moving a directory leaves the entries of the snapshot where they were
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "e/sub", NewName: "e/moved"}); err != nil {
+		t.Fatal(err)
+	}
+	if z, err := s.rootDir.walkTo("e/.snap/s/sub/z"); err != nil || z.fullPath != "e/sub/z" {
+		t.Errorf("expected the snapshot to keep e/sub/z, got %v", err)
+	}
+	if loc, err := s.GetLocation(ctx, &LocRequest{Name: "e/moved/z"}); err != nil || loc.ObjectId == "" {
+		t.Errorf("expected to locate the moved file, got %v, %v", loc, err)
+	}
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "e/y"}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := s.Stat(ctx, &StatRequest{Name: "e/x"}); err != nil || info.Links != 1 {
+		t.Errorf("expected e/x to have a single name, got %v, %v", info, err)
+	}
+	if _, err := s.DeleteSnapshot(ctx, &DeleteSnapshotRequest{Name: "e", Snapshot: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.deleted) != 3 {
+		t.Errorf("expected the data of e/x to be kept, got %v deleted", fake.deleted)
+	}
+}
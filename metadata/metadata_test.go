@@ -0,0 +1,325 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sync"
+	"testing"
+
+	"github.com/tevintchuinkam/dfs/files"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeFileServer records the calls the metadata server makes to a file server.
+type fakeFileServer struct {
+	files.FileServiceClient
+	mu      sync.Mutex
+	deleted []string
+	copied  []string
+}
+
+func (f *fakeFileServer) CopyFile(ctx context.Context, in *files.CopyFileRequest, opts ...grpc.CallOption) (*files.CopyFileResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.copied = append(f.copied, in.Name)
+	return &files.CopyFileResponse{BytesWritten: 1}, nil
+}
+
+func (f *fakeFileServer) DeleteFile(ctx context.Context, in *files.DeleteFileRequest, opts ...grpc.CallOption) (*files.DeleteFileResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, in.Name)
+	return &files.DeleteFileResponse{}, nil
+}
+
+func newTestServer(t *testing.T, dir string, opts ...Option) *MetaDataServer {
+	t.Helper()
+	opts = append([]Option{WithDataDir(dir), WithSyncWrites(false)}, opts...)
+	s := New(0, opts...)
+	// pretend a file server has registered so that files can be placed
+	addFileServers(s, 1)
+	return s
+}
+
+// addFileServers pretends that fake file servers on the ports 1 to n have
+// registered and returns them by port.
+func addFileServers(s *MetaDataServer, n int) map[int]*fakeFileServer {
+	fakes := make(map[int]*fakeFileServer)
+	for port := 1; port <= n; port++ {
+		fakes[port] = new(fakeFileServer)
+		var c files.FileServiceClient = fakes[port]
+		s.serverByPort(port).client = &c
+	}
+	return fakes
+}
+
+// createFile registers a new file and commits it as if its data had been
+// written.
+func createFile(ctx context.Context, s *MetaDataServer, req *RecRequest) (*RecResponse, error) {
+	rec, err := s.RegisterFileCreation(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.CommitFile(ctx, &CommitFileRequest{Name: req.Name, ObjectId: rec.ObjectId, Size: req.FileSize})
+	return rec, err
+}
+
+func TestRemove(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir())
+	defer s.Stop()
+	fake := (*s.serverByPort(1).client).(*fakeFileServer)
+
+	for _, d := range []string{"a", "a/b", "c"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"a/1.txt", "a/b/2.txt", "c/3.txt"} {
+		if _, err := createFile(ctx, s, &RecRequest{Name: f, FileSize: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "a"}); err == nil {
+		t.Error("unlinking a directory should fail")
+	}
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "c/3.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetLocation(ctx, &LocRequest{Name: "c/3.txt"}); err == nil {
+		t.Error("c/3.txt still has a location after being unlinked")
+	}
+	if _, err := s.RmDir(ctx, &RmDirRequest{Name: "a"}); err == nil {
+		t.Error("removing a non-empty directory without recursive should fail")
+	}
+	res, err := s.RmDir(ctx, &RmDirRequest{Name: "a", Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FilesRemoved != 2 {
+		t.Errorf("expected 2 files to be removed, got %d", res.FilesRemoved)
+	}
+	if entryAlreadyExists(s.rootDir, "a") {
+		t.Error("a still exists after being removed")
+	}
+	if len(fake.deleted) != 3 {
+		t.Errorf("expected the data of 3 files to be deleted, got %v", fake.deleted)
+	}
+	if load := s.serverByPort(1).load; load != 0 {
+		t.Errorf("expected no load after removing all files, got %d", load)
+	}
+}
+
+func TestRename(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir())
+	defer s.Stop()
+	fake := (*s.serverByPort(1).client).(*fakeFileServer)
+
+	for _, d := range []string{"a", "a/b", "c"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := make(map[string]string)
+	for _, f := range []string{"a/b/1.txt", "c/tmp", "c/final"} {
+		rec, err := createFile(ctx, s, &RecRequest{Name: f, FileSize: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		created[f] = rec.ObjectId
+	}
+
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "a", NewName: "a/b/x"}); err == nil {
+		t.Error("moving a directory into itself should fail")
+	}
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "a", NewName: "c/a"}); err != nil {
+		t.Fatal(err)
+	}
+	loc, err := s.GetLocation(ctx, &LocRequest{Name: "c/a/b/1.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.ObjectId != created["a/b/1.txt"] {
+		t.Errorf("object id changed on rename: %s != %s", loc.ObjectId, created["a/b/1.txt"])
+	}
+	if _, err := s.GetLocation(ctx, &LocRequest{Name: "a/b/1.txt"}); err == nil {
+		t.Error("the old path still has a location")
+	}
+	f, err := s.rootDir.walkTo("c/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.fullPath != "c/a/b" {
+		t.Errorf("expected full path c/a/b, got %s", f.fullPath)
+	}
+
+	// write to a temporary file, then rename it into place
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "c/tmp", NewName: "c/final"}); err != nil {
+		t.Fatal(err)
+	}
+	loc, err = s.GetLocation(ctx, &LocRequest{Name: "c/final"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.ObjectId != created["c/tmp"] {
+		t.Errorf("c/final does not point to the renamed data")
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] != created["c/final"] {
+		t.Errorf("expected the replaced data to be deleted, got %v", fake.deleted)
+	}
+}
+
+func TestStat(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "a", Owner: "alice", Group: "lab"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.Stat(ctx, &StatRequest{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !before.IsDir || before.Mode != 0o755 || before.Owner != "alice" || before.Group != "lab" {
+		t.Errorf("unexpected attributes for a: %v", before)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "a/f", FileSize: 3}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.Stat(ctx, &StatRequest{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModifyTime.AsTime().After(before.ModifyTime.AsTime()) {
+		t.Error("creating a file did not update the modification time of its directory")
+	}
+	f, err := s.Stat(ctx, &StatRequest{Name: "a/f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.IsDir || f.Mode != 0o644 || f.Size != 3 {
+		t.Errorf("unexpected attributes for a/f: %v", f)
+	}
+	// only the permission bits are kept
+	if _, err := createFile(ctx, s, &RecRequest{Name: "a/g", Mode: uint32(fs.ModeSetuid | fs.ModeDir | 0o600)}); err != nil {
+		t.Fatal(err)
+	}
+	if g, err := s.Stat(ctx, &StatRequest{Name: "a/g"}); err != nil || g.IsDir || g.Mode != 0o600 {
+		t.Errorf("expected a/g to have the mode 0600, got %v, %v", g, err)
+	}
+	s.Stop()
+
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	restored, err := s.Stat(ctx, &StatRequest{Name: "a/f"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.CreateTime.AsTime().Equal(f.CreateTime.AsTime()) {
+		t.Errorf("creation time changed on restart: %v != %v", restored.CreateTime.AsTime(), f.CreateTime.AsTime())
+	}
+}
+
+func TestReadDirPlus(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		if _, err := createFile(ctx, s, &RecRequest{Name: fmt.Sprintf("d/f%d", i), FileSize: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "d/pending"}); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	read := func(cursor []byte) ([]byte, bool) {
+		t.Helper()
+		res, err := s.ReadDirPlus(ctx, &ReadDirPlusRequest{Name: "d", Cursor: cursor, BatchSize: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Entries) > 3 {
+			t.Errorf("expected at most 3 entries, got %d", len(res.Entries))
+		}
+		for _, e := range res.Entries {
+			seen[e.Name]++
+		}
+		return res.Cursor, res.Eof
+	}
+	cursor, _ := read(nil)
+
+	// entries removed, added and renamed during the listing are not
+	// returned twice, the ones left untouched are all returned
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "d/f2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "d/f5"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "d/f0", NewName: "d/g0"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "d/f5", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	cursor, _ = read(cursor)
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "e"}); err != nil {
+		t.Fatal(err)
+	}
+	root, err := s.ReadDirPlus(ctx, &ReadDirPlusRequest{Name: ".", BatchSize: 1})
+	if err != nil || root.Eof {
+		t.Fatalf("expected a page of the root, got %v, %v", root, err)
+	}
+
+	// the cursors stay valid across a restart, also the ones of the root,
+	// which is restored from the journal and from a snapshot of it
+	s.Stop()
+	s = newTestServer(t, dir)
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	if rest, err := s.ReadDirPlus(ctx, &ReadDirPlusRequest{Name: ".", Cursor: root.Cursor}); err != nil || len(rest.Entries) != 1 || rest.Entries[0].Name == root.Entries[0].Name {
+		t.Errorf("expected the listing of the root to continue after %s, got %v, %v", root.Entries[0].Name, rest, err)
+	}
+	for eof := false; !eof; {
+		cursor, eof = read(cursor)
+	}
+	for i := range 10 {
+		name := fmt.Sprintf("f%d", i)
+		if seen[name] != 1 {
+			t.Errorf("expected %s to be returned once, got %d", name, seen[name])
+		}
+	}
+	for name, n := range seen {
+		if n > 1 || name == "pending" {
+			t.Errorf("expected %s not to be returned %d times", name, n)
+		}
+	}
+
+	if _, err := s.ReadDirPlus(ctx, &ReadDirPlusRequest{Name: "d", Cursor: []byte("garbage")}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected an invalid cursor to be rejected, got %v", err)
+	}
+	if _, err := s.RmDir(ctx, &RmDirRequest{Name: "d", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ReadDirPlus(ctx, &ReadDirPlusRequest{Name: "d", Cursor: cursor}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected the cursor of a removed directory to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A walk position is the list of seqs of the entries leading from the root
+// of the walk to an entry. Since seqs grow within a directory, depth first
+// order is the order of positions and breadth first order the one of their
+// lengths and then of the positions. Resuming after a position thus skips
+// what was sent even if entries were added or removed in the meantime.
+
+// encodeWalkToken returns the token that resumes a walk after pos.
+func encodeWalkToken(created int64, order WalkOrder, pos []uint64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(created))
+	b = append(b, byte(order))
+	for _, seq := range pos {
+		b = binary.AppendUvarint(b, seq)
+	}
+	return b
+}
+
+func decodeWalkToken(b []byte) (created int64, order WalkOrder, pos []uint64, err error) {
+	if len(b) < 9 {
+		return 0, 0, nil, errors.New("resume token is too short")
+	}
+	created = int64(binary.BigEndian.Uint64(b))
+	order = WalkOrder(b[8])
+	for b = b[9:]; len(b) > 0; {
+		seq, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, 0, nil, errors.New("invalid resume token")
+		}
+		pos = append(pos, seq)
+		b = b[n:]
+	}
+	return created, order, pos, nil
+}
+
+// walkEntry is an entry read by a walk, dir is set for directories.
+type walkEntry struct {
+	info *FileInfo
+	seq  uint64
+	dir  *fileInfo
+}
+
+// path returns where the walk found the entry, which differs from its live
+// path below a snapshot.
+func (e walkEntry) path() string {
+	return e.info.FullPath
+}
+
+// walker sends the entries of a walk in batches.
+type walker struct {
+	s        *MetaDataServer
+	stream   MetadataService_WalkTreeServer
+	created  int64
+	order    WalkOrder
+	maxDepth int
+	n        int
+	batch    []*FileInfo
+	// position of the last entry in batch
+	last []uint64
+}
+
+// WalkTree streams every entry below a directory in depth or breadth first
+// order. Symbolic links are not followed below the directory. A walk that was
+// interrupted continues after the last batch received when its token is
+// passed back.
+func (s *MetaDataServer) WalkTree(req *WalkTreeRequest, stream MetadataService_WalkTreeServer) error {
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return err
+	}
+	if err := s.checkOwns(p); err != nil {
+		return err
+	}
+	s.muDir.RLock()
+	root, err := s.rootDir.walkTo(p)
+	s.muDir.RUnlock()
+	if err != nil || !root.isDir {
+		return fmt.Errorf("the directory %s doesn't exist", p)
+	}
+	n := int(req.BatchSize)
+	if n <= 0 {
+		n = defaultReadDirBatch
+	}
+	w := &walker{
+		s:        s,
+		stream:   stream,
+		created:  root.generation(),
+		order:    req.Order,
+		maxDepth: int(req.MaxDepth),
+		n:        min(n, maxReadDirBatch),
+	}
+	var resume []uint64
+	if len(req.ResumeToken) > 0 {
+		created, order, pos, err := decodeWalkToken(req.ResumeToken)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if created != w.created || order != w.order {
+			return status.Errorf(codes.InvalidArgument, "the resume token belongs to another walk than the %v walk of %s", req.Order, p)
+		}
+		resume = pos
+	}
+	if w.order == WalkOrder_BFS {
+		err = w.bfs(root, p, resume)
+	} else {
+		err = w.dfs(root, p, nil, 1, resume)
+	}
+	if err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// dfs walks the entries of d, which is found at dirPath depth levels below
+// the root, and their subtrees. Entries up to the position resume were
+// already sent.
+func (w *walker) dfs(d *fileInfo, dirPath string, pos []uint64, depth int, resume []uint64) error {
+	var from uint64
+	if len(resume) > 0 {
+		from = resume[0]
+	}
+	entries, err := w.read(d, dirPath, from)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := append(slices.Clone(pos), e.seq)
+		var sub []uint64
+		if len(resume) > 0 && e.seq == resume[0] {
+			// the entry was sent, its subtree maybe partly
+			sub = resume[1:]
+		} else if err := w.emit(e.info, p); err != nil {
+			return err
+		}
+		if e.dir != nil && (w.maxDepth == 0 || depth < w.maxDepth) {
+			if err := w.dfs(e.dir, e.path(), p, depth+1, sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bfs walks the tree below root level by level. The levels above the one of
+// resume are read again to find the directories of that level.
+func (w *walker) bfs(root *fileInfo, rootPath string, resume []uint64) error {
+	type frontierDir struct {
+		dir  *fileInfo
+		path string
+		pos  []uint64
+	}
+	frontier := []frontierDir{{dir: root, path: rootPath}}
+	for depth := 1; len(frontier) > 0 && (w.maxDepth == 0 || depth <= w.maxDepth); depth++ {
+		var next []frontierDir
+		for _, f := range frontier {
+			entries, err := w.read(f.dir, f.path, 0)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				p := append(slices.Clone(f.pos), e.seq)
+				if depth > len(resume) || (depth == len(resume) && slices.Compare(p, resume) > 0) {
+					if err := w.emit(e.info, p); err != nil {
+						return err
+					}
+				}
+				if e.dir != nil {
+					next = append(next, frontierDir{dir: e.dir, path: e.path(), pos: p})
+				}
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+// read returns the entries of d, which is found at dirPath, from the one
+// with seq on, leaving out pending files.
+func (w *walker) read(d *fileInfo, dirPath string, seq uint64) ([]walkEntry, error) {
+	if err := w.stream.Context().Err(); err != nil {
+		return nil, err
+	}
+	w.s.muDir.RLock()
+	defer w.s.muDir.RUnlock()
+	d.rlock()
+	defer d.runlock()
+	i := sort.Search(len(d.subEntries), func(i int) bool {
+		return d.subEntries[i].seq >= seq
+	})
+	var entries []walkEntry
+	for _, e := range d.subEntries[i:] {
+		if e.pending {
+			continue
+		}
+		we := walkEntry{info: convert(e), seq: e.seq}
+		we.info.FullPath = path.Join(dirPath, e.name)
+		if e.isDir {
+			we.dir = e
+		}
+		entries = append(entries, we)
+	}
+	return entries, nil
+}
+
+func (w *walker) emit(info *FileInfo, pos []uint64) error {
+	w.batch = append(w.batch, info)
+	w.last = pos
+	if len(w.batch) < w.n {
+		return nil
+	}
+	return w.flush()
+}
+
+// flush sends the batched entries. Send blocks while the client does not
+// keep up, which holds the walk.
+func (w *walker) flush() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	err := w.stream.Send(&WalkTreeResponse{
+		Entries:     w.batch,
+		ResumeToken: encodeWalkToken(w.created, w.order, w.last),
+	})
+	w.batch = nil
+	return err
+}
@@ -0,0 +1,110 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// walkStream collects the entries of a walk and breaks off after limit
+// batches if it is set.
+type walkStream struct {
+	grpc.ServerStream
+	limit   int
+	batches int
+	paths   []string
+	token   []byte
+}
+
+func (w *walkStream) Context() context.Context { return context.Background() }
+
+func (w *walkStream) Send(res *WalkTreeResponse) error {
+	if w.limit > 0 && w.batches == w.limit {
+		return errors.New("connection lost")
+	}
+	w.batches++
+	for _, e := range res.Entries {
+		w.paths = append(w.paths, e.FullPath)
+	}
+	w.token = res.ResumeToken
+	return nil
+}
+
+func TestWalkTree(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, t.TempDir())
+	defer s.Stop()
+
+	for _, d := range []string{"a", "a/b", "c"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"a/f", "a/b/g", "c/h", "i"} {
+		if _, err := createFile(ctx, s, &RecRequest{Name: f, FileSize: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "a/pending"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dfs := []string{"a", "a/b", "a/b/g", "a/f", "c", "c/h", "i"}
+	bfs := []string{"a", "c", "i", "a/b", "a/f", "c/h", "a/b/g"}
+	for _, tc := range []struct {
+		req  *WalkTreeRequest
+		want []string
+	}{
+		{&WalkTreeRequest{Name: "."}, dfs},
+		{&WalkTreeRequest{Name: ".", Order: WalkOrder_BFS, BatchSize: 3}, bfs},
+		{&WalkTreeRequest{Name: ".", MaxDepth: 2}, []string{"a", "a/b", "a/f", "c", "c/h", "i"}},
+		{&WalkTreeRequest{Name: "a", Order: WalkOrder_BFS}, []string{"a/b", "a/f", "a/b/g"}},
+	} {
+		stream := new(walkStream)
+		if err := s.WalkTree(tc.req, stream); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(stream.paths, tc.want) {
+			t.Errorf("expected %v to walk %v, got %v", tc.req, tc.want, stream.paths)
+		}
+	}
+
+	// an interrupted walk resumes after the last batch received, without
+	// sending entries twice or skipping the ones left untouched
+	for _, order := range []WalkOrder{WalkOrder_DFS, WalkOrder_BFS} {
+		req := &WalkTreeRequest{Name: ".", Order: order, BatchSize: 2}
+		first := &walkStream{limit: 2}
+		if err := s.WalkTree(req, first); err == nil {
+			t.Fatal("expected the walk to break off")
+		}
+		if _, err := createFile(ctx, s, &RecRequest{Name: "a/b/new", FileSize: 1}); err != nil {
+			t.Fatal(err)
+		}
+		req.ResumeToken = first.token
+		rest := new(walkStream)
+		if err := s.WalkTree(req, rest); err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]int)
+		for _, p := range append(first.paths, rest.paths...) {
+			seen[p]++
+		}
+		for _, p := range dfs {
+			if seen[p] != 1 {
+				t.Errorf("expected the %v walk to send %s once, got %d", order, p, seen[p])
+			}
+		}
+		if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "a/b/new"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream := new(walkStream)
+	token := encodeWalkToken(1, WalkOrder_DFS, nil)
+	if err := s.WalkTree(&WalkTreeRequest{Name: ".", ResumeToken: token}, stream); err == nil {
+		t.Error("expected the token of another walk to be rejected")
+	}
+}
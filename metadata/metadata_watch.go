@@ -0,0 +1,253 @@
+package metadata
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// WatchSeqHeader is the header in which the server tells a watcher the
+// sequence number its watch starts after, which the watch can be resumed
+// from even if no event was received yet.
+const WatchSeqHeader = "mds-watch-seq"
+
+const (
+	// number of past events kept for watchers that resume
+	watchHistory = 4096
+	// events buffered for a watcher before it is dropped for falling behind
+	watchBuffer = 256
+)
+
+// watchHub hands the events of applied journal entries to watchers in the
+// order of their sequence numbers. Entries in different directories are
+// applied concurrently, so every persisted entry reserves a slot when it
+// gets its sequence number and events are only released once all entries
+// before them are done.
+type watchHub struct {
+	mu sync.Mutex
+	// entries persisted but not released yet, ordered by seq
+	slots []*watchSlot
+	// recently released events, the oldest first
+	history []*WatchEvent
+	// seq of the last event that is not kept in history anymore
+	dropped uint64
+	// seq of the last entry released
+	seq      uint64
+	watchers map[*watcher]struct{}
+}
+
+type watchSlot struct {
+	seq   uint64
+	done  bool
+	event *WatchEvent
+}
+
+// watcher receives released events until it falls behind, when events is
+// closed.
+type watcher struct {
+	events chan *WatchEvent
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{watchers: make(map[*watcher]struct{})}
+}
+
+// reserve calls persist, which assigns the next sequence number to e, and
+// reserves the slot of e in the order of events.
+func (h *watchHub) reserve(e *logEntry, persist func() error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := persist(); err != nil {
+		return err
+	}
+	h.slots = append(h.slots, &watchSlot{seq: e.Seq})
+	return nil
+}
+
+// done fills the slot reserved for seq and releases the events that are not
+// waiting for earlier entries anymore. A nil event only frees the slot.
+func (h *watchHub) done(seq uint64, ev *WatchEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, sl := range h.slots {
+		if sl.seq == seq {
+			sl.done = true
+			sl.event = ev
+			break
+		}
+	}
+	n := 0
+	for ; n < len(h.slots) && h.slots[n].done; n++ {
+		h.seq = h.slots[n].seq
+		if ev := h.slots[n].event; ev != nil {
+			h.release(ev)
+		}
+	}
+	h.slots = h.slots[n:]
+}
+
+func (h *watchHub) release(ev *WatchEvent) {
+	if len(h.history) == watchHistory {
+		h.dropped = h.history[0].Seq
+		h.history = h.history[1:]
+	}
+	h.history = append(h.history, ev)
+	for w := range h.watchers {
+		select {
+		case w.events <- ev:
+		default:
+			close(w.events)
+			delete(h.watchers, w)
+		}
+	}
+}
+
+// forget drops the history up to seq, which a snapshot replaced.
+func (h *watchHub) forget(seq uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = nil
+	h.dropped = max(h.dropped, seq)
+	h.seq = max(h.seq, seq)
+}
+
+// subscribe registers a watcher and returns the events after seq it missed.
+// A seq of 0 only asks for new events, the ones after the returned seq.
+func (h *watchHub) subscribe(seq uint64) (*watcher, []*WatchEvent, uint64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var missed []*WatchEvent
+	if seq == 0 {
+		seq = h.seq
+	} else {
+		if seq < h.dropped {
+			return nil, nil, 0, status.Errorf(codes.OutOfRange, "events after %d are not kept anymore, the oldest is %d", seq, h.dropped+1)
+		}
+		for _, ev := range h.history {
+			if ev.Seq > seq {
+				missed = append(missed, ev)
+			}
+		}
+	}
+	w := &watcher{events: make(chan *WatchEvent, watchBuffer)}
+	h.watchers[w] = struct{}{}
+	return w, missed, seq, nil
+}
+
+func (h *watchHub) unsubscribe(w *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.watchers, w)
+}
+
+// Watch streams the changes of a directory, or of its whole subtree if
+// recursive is set. Every metadata server of a cluster serves watches since
+// all of them apply the same entries. The sequence number the watch starts
+// after is sent in the WatchSeqHeader.
+func (s *MetaDataServer) Watch(req *WatchRequest, stream MetadataService_WatchServer) error {
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return err
+	}
+	if err := s.checkOwns(p); err != nil {
+		return err
+	}
+	w, missed, last, err := s.events.subscribe(req.FromSeq)
+	if err != nil {
+		return err
+	}
+	defer s.events.unsubscribe(w)
+	if err := stream.SendHeader(grpcmd.Pairs(WatchSeqHeader, strconv.FormatUint(last, 10))); err != nil {
+		return err
+	}
+	send := func(ev *WatchEvent) error {
+		last = ev.Seq
+		if !watched(p, req.Recursive, ev.Path) && !(ev.NewPath != "" && watched(p, req.Recursive, ev.NewPath)) {
+			return nil
+		}
+		return stream.Send(ev)
+	}
+	for _, ev := range missed {
+		if err := send(ev); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case ev, ok := <-w.events:
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "watcher fell behind, resume after %d", last)
+			}
+			if err := send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watched reports whether a change of the entry at p is seen by a watch of
+// dir.
+func watched(dir string, recursive bool, p string) bool {
+	if p == dir || path.Dir(p) == dir {
+		return true
+	}
+	return recursive && (dir == "." || strings.HasPrefix(p, dir+"/"))
+}
+
+// watchEvent returns the event describing the applied entry e, nil if it
+// does not change what clients see of the namespace.
+func watchEvent(e *logEntry) *WatchEvent {
+	ev := &WatchEvent{Seq: e.Seq, Path: e.Path, Time: timestamppb.New(e.time())}
+	switch e.Op {
+	case opMkDir:
+		ev.Type = WatchEventType_CREATE
+		ev.IsDir = true
+	case opCommit, opSymlink:
+		ev.Type = WatchEventType_CREATE
+	case opLink:
+		ev.Type = WatchEventType_CREATE
+		ev.Path = e.NewPath
+	case opUnlink:
+		// pending files were never visible
+		if len(e.removed) > 0 && e.removed[0].pending {
+			return nil
+		}
+		ev.Type = WatchEventType_DELETE
+	case opRmDir:
+		ev.Type = WatchEventType_DELETE
+		ev.IsDir = true
+	case opReset:
+		ev.Type = WatchEventType_DELETE
+		ev.Path = "."
+		ev.IsDir = true
+	case opRename, opMoveDone:
+		ev.Type = WatchEventType_RENAME
+		ev.NewPath = e.NewPath
+	case opMoveIn:
+		// the source of the move reports the rename
+		ev.Type = WatchEventType_CREATE
+		ev.Path = e.NewPath
+		ev.IsDir = e.Record.IsDir
+	case opCreateSnapshot:
+		ev.Type = WatchEventType_CREATE
+		ev.Path = path.Join(e.Path, snapDir, e.Snapshot)
+		ev.IsDir = true
+	case opDeleteSnapshot:
+		ev.Type = WatchEventType_DELETE
+		ev.Path = path.Join(e.Path, snapDir, e.Snapshot)
+		ev.IsDir = true
+	case opSetRepl, opSetStorage, opSetQuota, opSetXattr, opRemoveXattr:
+		ev.Type = WatchEventType_ATTRIB
+	default:
+		return nil
+	}
+	return ev
+}
@@ -0,0 +1,179 @@
+package metadata
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// watchStream collects the events a watch sends.
+type watchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events chan *WatchEvent
+	// the header, closed once it was sent
+	header chan grpcmd.MD
+}
+
+func (w *watchStream) Context() context.Context { return w.ctx }
+
+func (w *watchStream) SendHeader(md grpcmd.MD) error {
+	w.header <- md
+	close(w.header)
+	return nil
+}
+
+// start returns the sequence number the watch starts after.
+func (w *watchStream) start(t *testing.T) uint64 {
+	t.Helper()
+	select {
+	case md := <-w.header:
+		seq, err := strconv.ParseUint(md.Get(WatchSeqHeader)[0], 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return seq
+	case <-time.After(5 * time.Second):
+		t.Fatal("no header received")
+		return 0
+	}
+}
+
+func (w *watchStream) Send(ev *WatchEvent) error {
+	w.events <- ev
+	return nil
+}
+
+// watch starts watching name and returns the stream and the result of Watch.
+func watch(t *testing.T, s *MetaDataServer, req *WatchRequest) (*watchStream, chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	stream := &watchStream{ctx: ctx, events: make(chan *WatchEvent, 100), header: make(chan grpcmd.MD, 1)}
+	done := make(chan error, 1)
+	go func() { done <- s.Watch(req, stream) }()
+	return stream, done
+}
+
+func next(t *testing.T, stream *watchStream) *WatchEvent {
+	t.Helper()
+	select {
+	case ev := <-stream.events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return nil
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	for _, d := range []string{"drop", "drop/sub", "other"} {
+		if _, err := s.MkDir(ctx, &MkDirRequest{Name: d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "drop/sub/f", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "other/g", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "drop/pending"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "drop/pending"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "other/g", NewName: "drop/g"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetXattr(ctx, &SetXattrRequest{Name: "drop/g", Key: "user.seen", Value: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		typ  WatchEventType
+		path string
+	}
+	want := []event{
+		{WatchEventType_CREATE, "drop/sub"},
+		{WatchEventType_CREATE, "drop/sub/f"},
+		{WatchEventType_RENAME, "other/g"},
+		{WatchEventType_ATTRIB, "drop/g"},
+	}
+	check := func(stream *watchStream, want []event) uint64 {
+		t.Helper()
+		var seq uint64
+		for _, w := range want {
+			ev := next(t, stream)
+			if ev.Type != w.typ || ev.Path != w.path {
+				t.Errorf("expected %v of %s, got %v of %s", w.typ, w.path, ev.Type, ev.Path)
+			}
+			if ev.Seq <= seq {
+				t.Errorf("expected increasing sequence numbers, got %d after %d", ev.Seq, seq)
+			}
+			seq = ev.Seq
+		}
+		return seq
+	}
+
+	// the events since the creation of drop are resumed from the history and
+	// the new ones follow, pending files are left out
+	stream, _ := watch(t, s, &WatchRequest{Name: "drop", Recursive: true, FromSeq: 1})
+	last := check(stream, want)
+	if _, err := s.Unlink(ctx, &UnlinkRequest{Name: "drop/sub/f"}); err != nil {
+		t.Fatal(err)
+	}
+	check(stream, []event{{WatchEventType_DELETE, "drop/sub/f"}})
+	shallow, _ := watch(t, s, &WatchRequest{Name: "drop", FromSeq: 1})
+	check(shallow, []event{want[0], want[2], want[3]})
+
+	// the sequence numbers survive a restart
+	s.Stop()
+	s = newTestServer(t, dir)
+	stream, _ = watch(t, s, &WatchRequest{Name: "drop", Recursive: true, FromSeq: last})
+	if seq := stream.start(t); seq != last {
+		t.Errorf("expected the resumed watch to start after %d, got %d", last, seq)
+	}
+	last = check(stream, []event{{WatchEventType_DELETE, "drop/sub/f"}})
+
+	// a new watch is told where it starts, from where it can be resumed
+	// before it received any event
+	stream, _ = watch(t, s, &WatchRequest{Name: "drop"})
+	start := stream.start(t)
+	if start != last {
+		t.Errorf("expected the new watch to start after %d, got %d", last, start)
+	}
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "drop/new"}); err != nil {
+		t.Fatal(err)
+	}
+	resumed, _ := watch(t, s, &WatchRequest{Name: "drop", FromSeq: start})
+	check(resumed, []event{{WatchEventType_CREATE, "drop/new"}})
+	check(stream, []event{{WatchEventType_CREATE, "drop/new"}})
+	last = start
+
+	// events replaced by a snapshot cannot be resumed
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	_, done := watch(t, s, &WatchRequest{Name: "drop", FromSeq: last})
+	if err := <-done; status.Code(err) != codes.OutOfRange {
+		t.Errorf("expected resuming from a compacted sequence number to fail, got %v", err)
+	}
+	stream, _ = watch(t, s, &WatchRequest{Name: "drop"})
+	if seq := stream.start(t); seq <= start {
+		t.Errorf("expected a new watch to start after the snapshot, got %d", seq)
+	}
+}
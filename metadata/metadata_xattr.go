@@ -0,0 +1,306 @@
+package metadata
+
+import (
+	context "context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// limits on extended attributes, like the ones of Linux for a single value
+const (
+	maxXattrKey   = 255
+	maxXattrValue = 64 << 10
+	// bytes of all keys and values of a single file or directory
+	maxXattrTotal = 256 << 10
+)
+
+// SetXattr sets an extended attribute of a file or directory.
+func (s *MetaDataServer) SetXattr(ctx context.Context, req *SetXattrRequest) (*SetXattrResponse, error) {
+	if err := checkXattrKey(req.Key); err != nil {
+		return nil, err
+	}
+	if len(req.Value) > maxXattrValue {
+		return nil, status.Errorf(codes.InvalidArgument, "value of %s is %d bytes, at most %d are allowed", req.Key, len(req.Value), maxXattrValue)
+	}
+	if req.Create && req.Replace {
+		return nil, status.Error(codes.InvalidArgument, "an attribute cannot be both created and replaced")
+	}
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	e := &logEntry{
+		Op:         opSetXattr,
+		Path:       p,
+		XattrKey:   req.Key,
+		XattrValue: req.Value,
+		Time:       time.Now().UnixNano(),
+	}
+	switch {
+	case req.Create:
+		e.XattrFlag = xattrCreate
+	case req.Replace:
+		e.XattrFlag = xattrReplace
+	}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to set extended attribute", "entry", p, "key", req.Key, "error", err)
+		return nil, err
+	}
+	return &SetXattrResponse{}, nil
+}
+
+// RemoveXattr removes an extended attribute of a file or directory.
+func (s *MetaDataServer) RemoveXattr(ctx context.Context, req *RemoveXattrRequest) (*RemoveXattrResponse, error) {
+	p, err := s.follow(req.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	e := &logEntry{Op: opRemoveXattr, Path: p, XattrKey: req.Key, Time: time.Now().UnixNano()}
+	if err := s.commit(ctx, e); err != nil {
+		slog.Error("failed to remove extended attribute", "entry", p, "key", req.Key, "error", err)
+		return nil, err
+	}
+	return &RemoveXattrResponse{}, nil
+}
+
+// GetXattr returns the value of an extended attribute.
+func (s *MetaDataServer) GetXattr(ctx context.Context, req *GetXattrRequest) (*GetXattrResponse, error) {
+	res := new(GetXattrResponse)
+	err := s.readXattrs(req.Name, func(xattrs map[string][]byte) error {
+		v, ok := xattrs[req.Key]
+		if !ok {
+			return status.Errorf(codes.NotFound, "no attribute %s on %s", req.Key, req.Name)
+		}
+		res.Value = slices.Clone(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ListXattr returns the keys of the extended attributes of a file or
+// directory.
+func (s *MetaDataServer) ListXattr(ctx context.Context, req *ListXattrRequest) (*ListXattrResponse, error) {
+	res := new(ListXattrResponse)
+	err := s.readXattrs(req.Name, func(xattrs map[string][]byte) error {
+		for k := range xattrs {
+			res.Keys = append(res.Keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(res.Keys)
+	return res, nil
+}
+
+// readXattrs calls read with the extended attributes of the entry at p while
+// they cannot change.
+func (s *MetaDataServer) readXattrs(p string, read func(map[string][]byte) error) error {
+	p, err := s.follow(p, true)
+	if err != nil {
+		return err
+	}
+	s.muDir.RLock()
+	defer s.muDir.RUnlock()
+	parent, err := s.rootDir.walkTo(path.Dir(p))
+	if err != nil || !parent.isDir {
+		return fmt.Errorf("the entry %s doesn't exist", p)
+	}
+	parent.rlock()
+	defer parent.runlock()
+	f := parent
+	if p != "." {
+		f = parent.lookup(path.Base(p))
+	}
+	if f == nil || f.pending {
+		return fmt.Errorf("the entry %s doesn't exist", p)
+	}
+	return read(f.xattrs.get())
+}
+
+// applySetXattr holds the lock of the parent directory, which guards the
+// extended attributes of its entries. The root directory guards its own.
+func (s *MetaDataServer) applySetXattr(e *logEntry, persist func() error) error {
+	if err := s.ownXattrs(e.Path); err != nil {
+		return err
+	}
+	f, parent, err := s.lockEntry(e.Path)
+	if err != nil {
+		return err
+	}
+	defer parent.unlock()
+	old, exists := f.xattrs.get()[e.XattrKey]
+	switch {
+	case e.XattrFlag == xattrCreate && exists:
+		return status.Errorf(codes.AlreadyExists, "attribute %s of %s already exists", e.XattrKey, e.Path)
+	case e.XattrFlag == xattrReplace && !exists:
+		return status.Errorf(codes.NotFound, "no attribute %s on %s", e.XattrKey, e.Path)
+	}
+	total := xattrSize(f.xattrs.get()) + len(e.XattrValue)
+	if exists {
+		total -= len(old)
+	} else {
+		total += len(e.XattrKey)
+	}
+	if total > maxXattrTotal {
+		return status.Errorf(codes.ResourceExhausted, "attributes of %s would take %d bytes, at most %d are allowed", e.Path, total, maxXattrTotal)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	x := f.shareXattrs()
+	if x.attrs == nil {
+		x.attrs = make(map[string][]byte)
+	}
+	x.attrs[e.XattrKey] = e.XattrValue
+	return nil
+}
+
+func (s *MetaDataServer) applyRemoveXattr(e *logEntry, persist func() error) error {
+	if err := s.ownXattrs(e.Path); err != nil {
+		return err
+	}
+	f, parent, err := s.lockEntry(e.Path)
+	if err != nil {
+		return err
+	}
+	defer parent.unlock()
+	if _, ok := f.xattrs.get()[e.XattrKey]; !ok {
+		return status.Errorf(codes.NotFound, "no attribute %s on %s", e.XattrKey, e.Path)
+	}
+	if err := persist(); err != nil {
+		return err
+	}
+	delete(f.xattrs.attrs, e.XattrKey)
+	return nil
+}
+
+// ownXattrs gives the entries that share the extended attributes of the file
+// at p but are held by a snapshot a copy of their own, so that changing the
+// attributes of the file leaves the snapshots as they are. muDir must be
+// held exclusively if the file has hard links or p is shared with a
+// snapshot, see linked and sharedPath.
+func (s *MetaDataServer) ownXattrs(p string) error {
+	f, err := s.own(p)
+	if err != nil {
+		return fmt.Errorf("the entry %s doesn't exist", p)
+	}
+	if f.links == nil || f.xattrs == nil {
+		return nil
+	}
+	s.muLocation.Lock()
+	holders := slices.Clone(f.links.holders)
+	s.muLocation.Unlock()
+	for _, h := range holders {
+		if h == f || h.xattrs != f.xattrs {
+			continue
+		}
+		if live, err := s.rootDir.walkTo(h.fullPath); err != nil || live != h {
+			// only kept by snapshots
+			h.xattrs = h.xattrs.clone()
+		} else if s.sharedPath(h.fullPath) {
+			// a name of the file below a snapshotted directory
+			if _, err := s.own(h.fullPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// linked reports whether the entry at p shares its data with other entries,
+// its other names or the copies kept by snapshots.
+func (s *MetaDataServer) linked(p string) bool {
+	f, err := s.rootDir.walkTo(p)
+	return err == nil && f.links != nil
+}
+
+// lockEntry write locks the parent directory of p and returns the entry at
+// p together with it. The caller must hold muDir and unlock the parent.
+func (s *MetaDataServer) lockEntry(p string) (f, parent *fileInfo, err error) {
+	parent, err = s.lockParent(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("the entry %s doesn't exist", p)
+	}
+	f = parent
+	if p != "." {
+		f = parent.lookup(path.Base(p))
+	}
+	if f == nil || f.pending {
+		parent.unlock()
+		return nil, nil, fmt.Errorf("the entry %s doesn't exist", p)
+	}
+	if f.shared > 0 {
+		f = s.unshare(parent, f)
+	}
+	return f, parent, nil
+}
+
+// xattrSet holds the extended attributes of a file or directory. The names
+// of a file with hard links share it.
+type xattrSet struct {
+	attrs map[string][]byte
+}
+
+// newXattrSet returns a set holding attrs, nil if there are none.
+func newXattrSet(attrs map[string][]byte) *xattrSet {
+	if len(attrs) == 0 {
+		return nil
+	}
+	return &xattrSet{attrs: attrs}
+}
+
+// get returns the attributes in x, nil if there are none.
+func (x *xattrSet) get() map[string][]byte {
+	if x == nil {
+		return nil
+	}
+	return x.attrs
+}
+
+// clone returns a copy of x.
+func (x *xattrSet) clone() *xattrSet {
+	if x == nil {
+		return nil
+	}
+	return &xattrSet{attrs: maps.Clone(x.attrs)}
+}
+
+// shareXattrs returns the extended attributes of f, which are created if it
+// has none yet so that they can be shared with another name.
+func (f *fileInfo) shareXattrs() *xattrSet {
+	if f.xattrs == nil {
+		f.xattrs = new(xattrSet)
+	}
+	return f.xattrs
+}
+
+func checkXattrKey(key string) error {
+	if key == "" {
+		return status.Error(codes.InvalidArgument, "empty attribute name")
+	}
+	if len(key) > maxXattrKey {
+		return status.Errorf(codes.InvalidArgument, "attribute name is %d bytes, at most %d are allowed", len(key), maxXattrKey)
+	}
+	return nil
+}
+
+// xattrSize returns the bytes taken by the keys and values of xattrs.
+func xattrSize(xattrs map[string][]byte) int {
+	n := 0
+	for k, v := range xattrs {
+		n += len(k) + len(v)
+	}
+	return n
+}
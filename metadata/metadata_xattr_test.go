@@ -0,0 +1,139 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestXattr(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s := newTestServer(t, dir)
+
+	if _, err := s.MkDir(ctx, &MkDirRequest{Name: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createFile(ctx, s, &RecRequest{Name: "d/f", FileSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{".", "d", "d/f"} {
+		for _, key := range []string{"user.run", "user.origin"} {
+			if _, err := s.SetXattr(ctx, &SetXattrRequest{Name: name, Key: key, Value: []byte(name + key)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	code := func(err error, want codes.Code) {
+		t.Helper()
+		if status.Code(err) != want {
+			t.Errorf("expected %v, got %v", want, err)
+		}
+	}
+	_, err := s.SetXattr(ctx, &SetXattrRequest{Name: "d/f", Key: "user.run", Create: true})
+	code(err, codes.AlreadyExists)
+	_, err = s.SetXattr(ctx, &SetXattrRequest{Name: "d/f", Key: "user.missing", Replace: true})
+	code(err, codes.NotFound)
+	_, err = s.SetXattr(ctx, &SetXattrRequest{Name: "d/f", Key: "user.big", Value: make([]byte, maxXattrValue+1)})
+	code(err, codes.InvalidArgument)
+	for i := range 4 {
+		_, err = s.SetXattr(ctx, &SetXattrRequest{Name: "d", Key: fmt.Sprintf("user.%d", i), Value: make([]byte, maxXattrValue)})
+	}
+	code(err, codes.ResourceExhausted)
+	if _, err := s.RegisterFileCreation(ctx, &RecRequest{Name: "d/pending"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetXattr(ctx, &SetXattrRequest{Name: "d/pending", Key: "user.run"}); err == nil {
+		t.Error("expected pending files to have no attributes")
+	}
+
+	// the attributes are restored from the journal and from snapshots and
+	// move with their entry
+	s.Stop()
+	s = newTestServer(t, dir)
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	s = newTestServer(t, dir)
+	if _, err := s.Rename(ctx, &RenameRequest{OldName: "d/f", NewName: "g"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{".", "d", "g"} {
+		orig := name
+		if name == "g" {
+			orig = "d/f"
+		}
+		res, err := s.GetXattr(ctx, &GetXattrRequest{Name: name, Key: "user.origin"})
+		if err != nil || string(res.Value) != orig+"user.origin" {
+			t.Errorf("expected the origin of %s to be kept, got %v, %v", name, res, err)
+		}
+	}
+	if _, err := s.RemoveXattr(ctx, &RemoveXattrRequest{Name: "g", Key: "user.run"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.RemoveXattr(ctx, &RemoveXattrRequest{Name: "g", Key: "user.run"})
+	code(err, codes.NotFound)
+	_, err = s.GetXattr(ctx, &GetXattrRequest{Name: "g", Key: "user.run"})
+	code(err, codes.NotFound)
+	list, err := s.ListXattr(ctx, &ListXattrRequest{Name: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"user.0", "user.1", "user.2", "user.origin", "user.run"}; !slices.Equal(list.Keys, want) {
+		t.Errorf("expected the keys %v, got %v", want, list.Keys)
+	}
+
+	// the names of a file share its attributes, the snapshots keep the ones
+	// they were taken with
+	if _, err := s.Link(ctx, &LinkRequest{OldName: "g", NewName: "d/l"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetXattr(ctx, &SetXattrRequest{Name: "d/l", Key: "user.run", Value: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateSnapshot(ctx, &CreateSnapshotRequest{Name: "d", Snapshot: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetXattr(ctx, &SetXattrRequest{Name: "g", Key: "user.run", Value: []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	value := func(name string) string {
+		t.Helper()
+		res, err := s.GetXattr(ctx, &GetXattrRequest{Name: name, Key: "user.run"})
+		if err != nil {
+			return err.Error()
+		}
+		return string(res.Value)
+	}
+	check := func(run string) {
+		t.Helper()
+		for name, want := range map[string]string{"g": run, "d/l": run, "d/.snap/s/l": "1"} {
+			if got := value(name); got != want {
+				t.Errorf("expected user.run of %s to be %q, got %q", name, want, got)
+			}
+		}
+	}
+	check("2")
+	if _, err := s.SetXattr(ctx, &SetXattrRequest{Name: "d/l", Key: "user.run", Value: []byte("3")}); err != nil {
+		t.Fatal(err)
+	}
+	check("3")
+
+	// and share them again once restored
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	s.Stop()
+	s = newTestServer(t, dir)
+	defer s.Stop()
+	check("3")
+	if _, err := s.SetXattr(ctx, &SetXattrRequest{Name: "g", Key: "user.run", Value: []byte("4")}); err != nil {
+		t.Fatal(err)
+	}
+	check("4")
+}